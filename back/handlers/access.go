@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+)
+
+// workspaceRole returns the user's effective role in the workspace, which
+// may come from a team or an organization, or nil if they have no access.
+func workspaceRole(userRepo repository.User, userWorkspaceRoleRepo repository.UserWorkspaceRole, username string, workspaceId uint) (*models.UserWorkspaceRole, error) {
+	user, err := userRepo.FindByUsername(username)
+	if err != nil || user == nil {
+		return nil, err
+	}
+
+	roles, err := userWorkspaceRoleRepo.FindEffectiveByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, role := range roles {
+		if role.Workspace_id == workspaceId {
+			return role, nil
+		}
+	}
+	return nil, nil
+}
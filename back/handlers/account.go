@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+)
+
+type AccountHandler struct {
+	UserRepo              repository.User
+	UserWorkspaceRoleRepo repository.UserWorkspaceRole
+	WorkspaceRepo         repository.Workspace
+	TaskRepo              repository.Task
+	SessionRepo           repository.Session
+	TokenRepo             repository.PersonalAccessToken
+}
+
+func NewAccountHandler(userRepo repository.User, userWorkspaceRoleRepo repository.UserWorkspaceRole, workspaceRepo repository.Workspace, taskRepo repository.Task, sessionRepo repository.Session, tokenRepo repository.PersonalAccessToken) *AccountHandler {
+	return &AccountHandler{
+		UserRepo:              userRepo,
+		UserWorkspaceRoleRepo: userWorkspaceRoleRepo,
+		WorkspaceRepo:         workspaceRepo,
+		TaskRepo:              taskRepo,
+		SessionRepo:           sessionRepo,
+		TokenRepo:             tokenRepo,
+	}
+}
+
+type AccountWorkspaceDTO struct {
+	ID          uint   `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Role        uint   `json:"role"`
+	Sole_owner  bool   `json:"sole_owner"`
+}
+
+// AccountExportDTO holds everything Gorello keeps about a user.
+type AccountExportDTO struct {
+	Exported_at   time.Time                     `json:"exported_at"`
+	Profile       UserProfileDTO                `json:"profile"`
+	Workspaces    []AccountWorkspaceDTO         `json:"workspaces"`
+	Tasks         []*models.Task                `json:"tasks"`
+	Sessions      []*models.Session             `json:"sessions"`
+	Access_tokens []*models.PersonalAccessToken `json:"access_tokens"`
+}
+
+type AccountWorkspaceActionDTO struct {
+	Workspace_id uint   `json:"workspace_id" validate:"required"`
+	Action       string `json:"action" validate:"required,oneof=transfer delete"`
+	Transfer_to  string `json:"transfer_to" validate:"required_if=Action transfer"`
+}
+
+type AccountDeleteDTO struct {
+	Workspaces        []AccountWorkspaceActionDTO `json:"workspaces" validate:"dive"`
+	Reassign_tasks_to string                      `json:"reassign_tasks_to"`
+}
+
+// self loads the user named in the path after checking that it is the
+// caller, logged in rather than using an access token. On failure the
+// response has already been written and the returned user is nil.
+func (h *AccountHandler) self(c echo.Context) (*models.User, error) {
+	username := c.Param("username")
+	authUsername := c.Get("username")
+	if authUsername != username {
+		return nil, c.JSON(http.StatusForbidden, "Access denied")
+	}
+	if c.Get("token_id") != nil {
+		return nil, c.JSON(http.StatusForbidden, "Accounts cannot be exported or deleted with an access token")
+	}
+
+	user, err := h.UserRepo.FindByUsername(username)
+	if err != nil {
+		log.Printf("error finding user: %s", err.Error())
+		return nil, c.NoContent(http.StatusInternalServerError)
+	}
+	if user == nil {
+		return nil, c.JSON(http.StatusNotFound, "User not found")
+	}
+	return user, nil
+}
+
+// workspaces lists the user's workspaces, marking those with no other
+// owner.
+func (h *AccountHandler) workspaces(user *models.User) ([]AccountWorkspaceDTO, error) {
+	roles, err := h.UserWorkspaceRoleRepo.FindByUserID(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	workspaces := make([]AccountWorkspaceDTO, 0, len(roles))
+	for _, role := range roles {
+		workspace, err := h.WorkspaceRepo.FindByID(role.Workspace_id)
+		if err != nil {
+			return nil, err
+		}
+
+		soleOwner := false
+		if role.Role == 1 {
+			members, err := h.UserWorkspaceRoleRepo.FindByWorkspaceID(role.Workspace_id)
+			if err != nil {
+				return nil, err
+			}
+			soleOwner = true
+			for _, member := range members {
+				if member.Role == 1 && member.User_id != user.ID {
+					soleOwner = false
+					break
+				}
+			}
+		}
+
+		workspaces = append(workspaces, AccountWorkspaceDTO{
+			ID:          workspace.ID,
+			Name:        workspace.Name,
+			Description: workspace.Description,
+			Role:        role.Role,
+			Sole_owner:  soleOwner,
+		})
+	}
+	return workspaces, nil
+}
+
+// ExportAccount returns all of the user's data as a JSON download.
+func (h *AccountHandler) ExportAccount(c echo.Context) error {
+	user, err := h.self(c)
+	if user == nil {
+		return err
+	}
+
+	workspaces, err := h.workspaces(user)
+	if err != nil {
+		log.Printf("error fetching workspaces: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	tasks, err := h.TaskRepo.FindByAssigneeID(user.ID)
+	if err != nil {
+		log.Printf("error fetching tasks: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	sessions, err := h.SessionRepo.FindActiveByUserID(user.ID)
+	if err != nil {
+		log.Printf("error fetching sessions: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	tokens, err := h.TokenRepo.FindByUserID(user.ID)
+	if err != nil {
+		log.Printf("error fetching access tokens: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf("attachment; filename=\"gorello-%s.json\"", user.Username))
+	return c.JSON(http.StatusOK, AccountExportDTO{
+		Exported_at:   time.Now().UTC(),
+		Profile:       newUserProfileDTO(user),
+		Workspaces:    workspaces,
+		Tasks:         tasks,
+		Sessions:      sessions,
+		Access_tokens: tokens,
+	})
+}
+
+// DeleteAccount deletes the caller's account. Every workspace the user
+// solely owns must be transferred to another member or deleted; the
+// user's tasks are reassigned to reassign_tasks_to where that user is a
+// member and unassigned elsewhere.
+func (h *AccountHandler) DeleteAccount(c echo.Context) error {
+	user, err := h.self(c)
+	if user == nil {
+		return err
+	}
+
+	if !ifMatch(c, user.Version) {
+		return c.JSON(http.StatusPreconditionFailed, "User was modified by another request")
+	}
+
+	accountDeleteDTO := new(AccountDeleteDTO)
+	if err := c.Bind(accountDeleteDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(accountDeleteDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	workspaces, err := h.workspaces(user)
+	if err != nil {
+		log.Printf("error fetching workspaces: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	soleOwned := make(map[uint]bool)
+	for _, workspace := range workspaces {
+		if workspace.Sole_owner {
+			soleOwned[workspace.ID] = true
+		}
+	}
+
+	plan := repository.AccountDeletionPlan{Transfer_workspaces: make(map[uint]uint)}
+	handled := make(map[uint]bool)
+	for _, action := range accountDeleteDTO.Workspaces {
+		if !soleOwned[action.Workspace_id] || handled[action.Workspace_id] {
+			return c.JSON(http.StatusBadRequest, fmt.Sprintf("Workspace %d is not a workspace you solely own", action.Workspace_id))
+		}
+		handled[action.Workspace_id] = true
+
+		if action.Action == "delete" {
+			plan.Delete_workspaces = append(plan.Delete_workspaces, action.Workspace_id)
+			continue
+		}
+
+		role, err := workspaceRole(h.UserRepo, h.UserWorkspaceRoleRepo, action.Transfer_to, action.Workspace_id)
+		if err != nil {
+			log.Printf("error finding workspace role: %s", err.Error())
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		if role == nil || role.User_id == user.ID {
+			return c.JSON(http.StatusBadRequest, fmt.Sprintf("%q is not another member of workspace %d", action.Transfer_to, action.Workspace_id))
+		}
+		plan.Transfer_workspaces[action.Workspace_id] = role.User_id
+	}
+
+	pending := make([]AccountWorkspaceDTO, 0)
+	for _, workspace := range workspaces {
+		if workspace.Sole_owner && !handled[workspace.ID] {
+			pending = append(pending, workspace)
+		}
+	}
+	if len(pending) > 0 {
+		return c.JSON(http.StatusConflict, map[string]interface{}{
+			"error":      "Transfer or delete the workspaces you solely own first",
+			"workspaces": pending,
+		})
+	}
+
+	if username := accountDeleteDTO.Reassign_tasks_to; username != "" {
+		assignee, err := h.UserRepo.FindByUsername(username)
+		if err != nil {
+			log.Printf("error finding user: %s", err.Error())
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		if assignee == nil || assignee.ID == user.ID {
+			return c.JSON(http.StatusBadRequest, fmt.Sprintf("Cannot reassign tasks to %q", username))
+		}
+		plan.Reassign_tasks_to = assignee.ID
+	}
+
+	err = h.UserRepo.DeleteAccount(user, plan)
+	if errors.Is(err, repository.ErrStaleVersion) {
+		return c.JSON(http.StatusPreconditionFailed, "User was modified by another request")
+	}
+	if errors.Is(err, repository.ErrLastOwner) {
+		return c.JSON(http.StatusConflict, "A workspace you own lost its other owners, review your workspaces and try again")
+	}
+	if errors.Is(err, repository.ErrLastAdmin) {
+		return c.JSON(http.StatusConflict, "Make another member an admin of the organizations you solely administer first")
+	}
+	if err != nil {
+		log.Printf("error deleting account: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,824 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+)
+
+// archiveVersion is bumped whenever the export format changes in a way older
+// importers can't read.
+const archiveVersion = 1
+
+const maxImportSize = 32 << 20
+
+type ArchiveHandler struct {
+	WorkspaceRepo         repository.Workspace
+	TaskRepo              repository.Task
+	LabelRepo             repository.Label
+	UserWorkspaceRoleRepo repository.UserWorkspaceRole
+	UserRepo              repository.User
+	WorkflowRepo          repository.Workflow
+	SprintRepo            repository.Sprint
+	CustomFieldRepo       repository.CustomField
+}
+
+func NewArchiveHandler(workspaceRepo repository.Workspace, taskRepo repository.Task, labelRepo repository.Label, userWorkspaceRoleRepo repository.UserWorkspaceRole, userRepo repository.User, workflowRepo repository.Workflow, sprintRepo repository.Sprint, customFieldRepo repository.CustomField) *ArchiveHandler {
+	return &ArchiveHandler{
+		WorkspaceRepo:         workspaceRepo,
+		TaskRepo:              taskRepo,
+		LabelRepo:             labelRepo,
+		UserWorkspaceRoleRepo: userWorkspaceRoleRepo,
+		UserRepo:              userRepo,
+		WorkflowRepo:          workflowRepo,
+		SprintRepo:            sprintRepo,
+		CustomFieldRepo:       customFieldRepo,
+	}
+}
+
+// WorkspaceArchiveDTO is a workspace export. Without statuses or priorities
+// the workspace uses the default workflow.
+type WorkspaceArchiveDTO struct {
+	Version       int                       `json:"version"`
+	ExportedAt    time.Time                 `json:"exported_at"`
+	Workspace     ArchiveWorkspaceDTO       `json:"workspace"`
+	Members       []ArchiveMemberDTO        `json:"members"`
+	Labels        []ArchiveLabelDTO         `json:"labels"`
+	Statuses      []models.TemplateStatus   `json:"statuses,omitempty"`
+	Priorities    []models.TemplatePriority `json:"priorities,omitempty"`
+	Sprints       []ArchiveSprintDTO        `json:"sprints,omitempty"`
+	Custom_fields []ArchiveCustomFieldDTO   `json:"custom_fields,omitempty"`
+	Tasks         []ArchiveTaskDTO          `json:"tasks"`
+	Metadata      map[string]interface{}    `json:"metadata,omitempty"`
+}
+
+type ArchiveWorkspaceDTO struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type ArchiveMemberDTO struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Role     uint   `json:"role"`
+}
+
+type ArchiveLabelDTO struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+type ArchiveSprintDTO struct {
+	Name             string     `json:"name"`
+	Goal             string     `json:"goal"`
+	Start_date       time.Time  `json:"start_date"`
+	End_date         time.Time  `json:"end_date"`
+	State            string     `json:"state"`
+	Started_at       *time.Time `json:"started_at,omitempty"`
+	Completed_at     *time.Time `json:"completed_at,omitempty"`
+	Committed_points uint       `json:"committed_points"`
+	Completed_points uint       `json:"completed_points"`
+}
+
+type ArchiveCustomFieldDTO struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	Options  []string `json:"options,omitempty"`
+	Required bool     `json:"required"`
+}
+
+type ArchiveTaskDTO struct {
+	Title          string              `json:"title"`
+	Description    string              `json:"description"`
+	Status         uint                `json:"status"`
+	Priority       uint                `json:"priority"`
+	Estimated_time string              `json:"estimated_time"`
+	Actual_time    string              `json:"actual_time"`
+	Due_date       string              `json:"due_date"`
+	Assignee       string              `json:"assignee,omitempty"`
+	Image_url      string              `json:"image_url"`
+	Story_points   uint                `json:"story_points"`
+	Labels         []string            `json:"labels"`
+	SubTasks       []ArchiveSubTaskDTO `json:"subtasks"`
+	CreatedAt      time.Time           `json:"created_at"`
+	Archived_at    *time.Time          `json:"archived_at,omitempty"`
+	// Sprint is the index of the task's sprint in the archive's sprints.
+	Sprint *int `json:"sprint,omitempty"`
+	// Custom_fields maps custom field names to the task's values, with
+	// usernames for user fields.
+	Custom_fields map[string]json.RawMessage `json:"custom_fields,omitempty"`
+}
+
+type ArchiveSubTaskDTO struct {
+	Title        string `json:"title"`
+	Is_completed bool   `json:"is_completed"`
+	Assignee     string `json:"assignee,omitempty"`
+}
+
+type ImportReportDTO struct {
+	Workspace           *models.Workspace `json:"workspace"`
+	Format              string            `json:"format"`
+	LabelsCreated       int               `json:"labels_created"`
+	SprintsCreated      int               `json:"sprints_created"`
+	CustomFieldsCreated int               `json:"custom_fields_created"`
+	TasksCreated        int               `json:"tasks_created"`
+	SubTasksCreated     int               `json:"subtasks_created"`
+	Warnings            []string          `json:"warnings"`
+}
+
+// The subset of a Trello board JSON export that the importer understands.
+type trelloBoardDTO struct {
+	Name       string               `json:"name"`
+	Desc       string               `json:"desc"`
+	Lists      []trelloListDTO      `json:"lists"`
+	Cards      []trelloCardDTO      `json:"cards"`
+	Checklists []trelloChecklistDTO `json:"checklists"`
+	Labels     []trelloLabelDTO     `json:"labels"`
+	Members    []trelloMemberDTO    `json:"members"`
+}
+
+type trelloListDTO struct {
+	ID     string  `json:"id"`
+	Name   string  `json:"name"`
+	Closed bool    `json:"closed"`
+	Pos    float64 `json:"pos"`
+}
+
+type trelloCardDTO struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Desc      string   `json:"desc"`
+	Closed    bool     `json:"closed"`
+	Due       string   `json:"due"`
+	IDList    string   `json:"idList"`
+	IDLabels  []string `json:"idLabels"`
+	IDMembers []string `json:"idMembers"`
+}
+
+type trelloChecklistDTO struct {
+	ID         string               `json:"id"`
+	IDCard     string               `json:"idCard"`
+	Name       string               `json:"name"`
+	CheckItems []trelloCheckItemDTO `json:"checkItems"`
+}
+
+type trelloCheckItemDTO struct {
+	Name  string  `json:"name"`
+	State string  `json:"state"`
+	Pos   float64 `json:"pos"`
+}
+
+type trelloLabelDTO struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+type trelloMemberDTO struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+func (h *ArchiveHandler) ExportWorkspace(c echo.Context) error {
+	authUsername, ok := c.Get("username").(string)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	workspaceId, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	role, err := workspaceRole(h.UserRepo, h.UserWorkspaceRoleRepo, authUsername, uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if role == nil {
+		return c.JSON(http.StatusForbidden, "Access denied to the workspace")
+	}
+
+	roles, err := h.UserWorkspaceRoleRepo.FindByWorkspaceID(uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	workspace, err := h.WorkspaceRepo.FindByID(uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	labels, err := h.LabelRepo.FindByWorkspaceID(workspace.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	tasks, err := h.TaskRepo.FindByWorkspaceIDWithDetails(workspace.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	statuses, err := h.WorkflowRepo.FindStatuses(workspace.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	priorities, err := h.WorkflowRepo.FindPriorities(workspace.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	sprints, err := h.SprintRepo.FindByWorkspaceID(workspace.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	fields, err := h.CustomFieldRepo.FindByWorkspaceID(workspace.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	values, err := h.CustomFieldRepo.FindValuesByWorkspaceID(workspace.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	archive := WorkspaceArchiveDTO{
+		Version:    archiveVersion,
+		ExportedAt: time.Now().UTC(),
+		Workspace: ArchiveWorkspaceDTO{
+			Name:        workspace.Name,
+			Description: workspace.Description,
+			CreatedAt:   workspace.CreatedAt,
+		},
+		Members: make([]ArchiveMemberDTO, 0, len(roles)),
+		Labels:  make([]ArchiveLabelDTO, 0, len(labels)),
+		Tasks:   make([]ArchiveTaskDTO, 0, len(tasks)),
+		Metadata: map[string]interface{}{
+			"workspace_id": workspace.ID,
+			"exported_by":  authUsername,
+		},
+	}
+	archive.Statuses, archive.Priorities = workflowTemplate(statuses, priorities)
+
+	// Tasks and user fields can refer to users who are members through a
+	// team or organization, so those are looked up as they come.
+	usernames := map[uint]string{0: ""}
+	username := func(id uint) (string, error) {
+		if name, ok := usernames[id]; ok {
+			return name, nil
+		}
+		user, err := h.UserRepo.FindByID(id)
+		if err != nil {
+			return "", err
+		}
+		usernames[id] = user.Username
+		return user.Username, nil
+	}
+
+	for _, role := range roles {
+		member, err := h.UserRepo.FindByID(role.User_id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		usernames[member.ID] = member.Username
+		archive.Members = append(archive.Members, ArchiveMemberDTO{
+			Username: member.Username,
+			Email:    member.Email,
+			Role:     role.Role,
+		})
+	}
+
+	for _, label := range labels {
+		archive.Labels = append(archive.Labels, ArchiveLabelDTO{Name: label.Name, Color: label.Color})
+	}
+
+	sprintIndexes := make(map[uint]int, len(sprints))
+	for i, sprint := range sprints {
+		sprintIndexes[sprint.ID] = i
+		archive.Sprints = append(archive.Sprints, ArchiveSprintDTO{
+			Name:             sprint.Name,
+			Goal:             sprint.Goal,
+			Start_date:       sprint.Start_date,
+			End_date:         sprint.End_date,
+			State:            sprint.State,
+			Started_at:       sprint.Started_at,
+			Completed_at:     sprint.Completed_at,
+			Committed_points: sprint.Committed_points,
+			Completed_points: sprint.Completed_points,
+		})
+	}
+
+	fieldsByID := make(map[uint]*models.CustomField, len(fields))
+	for _, field := range fields {
+		fieldsByID[field.ID] = field
+		archive.Custom_fields = append(archive.Custom_fields, ArchiveCustomFieldDTO{
+			Name:     field.Name,
+			Type:     field.Type,
+			Options:  field.Options,
+			Required: field.Required,
+		})
+	}
+	taskValues := make(map[uint]map[string]json.RawMessage)
+	for _, value := range values {
+		field := fieldsByID[value.Custom_field_id]
+		raw := json.RawMessage(value.Value)
+		if field.Type == models.CustomFieldTypeUser {
+			var id uint
+			json.Unmarshal(raw, &id)
+			name, err := username(id)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, err.Error())
+			}
+			raw, _ = json.Marshal(name)
+		}
+		if taskValues[value.Task_id] == nil {
+			taskValues[value.Task_id] = make(map[string]json.RawMessage)
+		}
+		taskValues[value.Task_id][field.Name] = raw
+	}
+
+	for _, task := range tasks {
+		assignee, err := username(task.Assignee_id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		archiveTask := ArchiveTaskDTO{
+			Title:          task.Title,
+			Description:    task.Description,
+			Status:         task.Status,
+			Priority:       task.Priority,
+			Estimated_time: task.Estimated_time,
+			Actual_time:    task.Actual_time,
+			Due_date:       task.Due_date,
+			Assignee:       assignee,
+			Image_url:      task.Image_url,
+			Story_points:   task.Story_points,
+			Labels:         make([]string, 0, len(task.Labels)),
+			SubTasks:       make([]ArchiveSubTaskDTO, 0, len(task.SubTasks)),
+			CreatedAt:      task.CreatedAt,
+			Archived_at:    task.Archived_at,
+			Custom_fields:  taskValues[task.ID],
+		}
+		if i, ok := sprintIndexes[task.Sprint_id]; ok {
+			archiveTask.Sprint = &i
+		}
+		for _, label := range task.Labels {
+			archiveTask.Labels = append(archiveTask.Labels, label.Name)
+		}
+		for _, subTask := range task.SubTasks {
+			assignee, err := username(subTask.Assignee_id)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, err.Error())
+			}
+			archiveTask.SubTasks = append(archiveTask.SubTasks, ArchiveSubTaskDTO{
+				Title:        subTask.Title,
+				Is_completed: subTask.Is_completed,
+				Assignee:     assignee,
+			})
+		}
+		archive.Tasks = append(archive.Tasks, archiveTask)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
+	c.Response().Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf("attachment; filename=\"workspace-%d.json\"", workspace.ID))
+	c.Response().WriteHeader(http.StatusOK)
+	return json.NewEncoder(c.Response()).Encode(archive)
+}
+
+// ImportWorkspace recreates a workspace from a Gorello archive or a Trello
+// board export. The format is taken from the "format" query parameter
+// (gorello or trello) and otherwise detected from the body. Nothing is
+// written unless the whole archive validates.
+func (h *ArchiveHandler) ImportWorkspace(c echo.Context) error {
+	authUsername, ok := c.Get("username").(string)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	user, err := h.UserRepo.FindByUsername(authUsername)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Response(), c.Request().Body, maxImportSize))
+	if err != nil {
+		return c.JSON(http.StatusRequestEntityTooLarge, err.Error())
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		var probe struct {
+			Cards json.RawMessage `json:"cards"`
+		}
+		if err := json.Unmarshal(body, &probe); err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		format = "gorello"
+		if probe.Cards != nil {
+			format = "trello"
+		}
+	}
+
+	var archive *WorkspaceArchiveDTO
+	var warnings []string
+	switch format {
+	case "gorello":
+		archive = new(WorkspaceArchiveDTO)
+		if err := json.Unmarshal(body, archive); err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+	case "trello":
+		board := new(trelloBoardDTO)
+		if err := json.Unmarshal(body, board); err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		archive, warnings = archiveFromTrello(board)
+	default:
+		return c.JSON(http.StatusBadRequest, "format must be gorello or trello")
+	}
+
+	statuses, priorities := templateWorkflow(archive.Statuses, archive.Priorities)
+	if errs := validateArchive(archive, statuses, priorities); len(errs) > 0 {
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{"errors": errs})
+	}
+
+	report := &ImportReportDTO{Format: format, Warnings: warnings}
+	workspace := &models.Workspace{
+		Name:        truncate(archive.Workspace.Name, 100, "workspace name", &report.Warnings),
+		Description: truncate(archive.Workspace.Description, 100, "workspace description", &report.Warnings),
+	}
+
+	// The importer is the new workspace's only member and owner. Usernames
+	// in an archive needn't belong to the same people here, so the other
+	// members are left for the importer to invite.
+	userIDs := map[string]uint{user.Username: user.ID}
+	roles := []*models.UserWorkspaceRole{{User_id: user.ID, Role: 1}}
+	for _, member := range archive.Members {
+		if member.Username == user.Username {
+			continue
+		}
+		report.Warnings = append(report.Warnings, fmt.Sprintf("member %q was not added, invite them to the workspace", member.Username))
+	}
+
+	labels := make([]*models.Label, 0, len(archive.Labels))
+	seenLabels := make(map[string]bool)
+	for _, label := range archive.Labels {
+		name := truncate(label.Name, 100, "label name", &report.Warnings)
+		if seenLabels[name] {
+			continue
+		}
+		seenLabels[name] = true
+		labels = append(labels, &models.Label{Name: name, Color: label.Color})
+	}
+
+	sprints := make([]*models.Sprint, 0, len(archive.Sprints))
+	for _, sprint := range archive.Sprints {
+		sprints = append(sprints, &models.Sprint{
+			Name:             truncate(sprint.Name, 100, "sprint name", &report.Warnings),
+			Goal:             truncate(sprint.Goal, 255, "sprint goal", &report.Warnings),
+			Start_date:       sprint.Start_date,
+			End_date:         sprint.End_date,
+			State:            sprint.State,
+			Started_at:       sprint.Started_at,
+			Completed_at:     sprint.Completed_at,
+			Committed_points: sprint.Committed_points,
+			Completed_points: sprint.Completed_points,
+		})
+	}
+
+	fields := make([]*models.CustomField, 0, len(archive.Custom_fields))
+	fieldsByName := make(map[string]*models.CustomField, len(archive.Custom_fields))
+	for _, archiveField := range archive.Custom_fields {
+		field := &models.CustomField{
+			Name:     archiveField.Name,
+			Type:     archiveField.Type,
+			Options:  archiveField.Options,
+			Required: archiveField.Required,
+		}
+		fields = append(fields, field)
+		fieldsByName[field.Name] = field
+	}
+
+	assigneeID := func(username string) uint {
+		if username == "" {
+			return 0
+		}
+		id, ok := userIDs[username]
+		if !ok {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("assignee %q is not a member and was dropped", username))
+		}
+		return id
+	}
+
+	tasks := make([]*repository.ImportTask, 0, len(archive.Tasks))
+	for _, archiveTask := range archive.Tasks {
+		task := &models.Task{
+			Title:          truncate(archiveTask.Title, 100, "task title", &report.Warnings),
+			Description:    truncate(archiveTask.Description, 100, "task description", &report.Warnings),
+			Status:         archiveTask.Status,
+			Priority:       archiveTask.Priority,
+			Estimated_time: archiveTask.Estimated_time,
+			Actual_time:    archiveTask.Actual_time,
+			Due_date:       archiveTask.Due_date,
+			Assignee_id:    assigneeID(archiveTask.Assignee),
+			Image_url:      archiveTask.Image_url,
+			Story_points:   archiveTask.Story_points,
+			Archived_at:    archiveTask.Archived_at,
+		}
+		for _, name := range archiveTask.Labels {
+			task.Labels = append(task.Labels, models.Label{Name: name})
+		}
+		for _, archiveSubTask := range archiveTask.SubTasks {
+			task.SubTasks = append(task.SubTasks, models.SubTask{
+				Title:        truncate(archiveSubTask.Title, 100, "subtask title", &report.Warnings),
+				Is_completed: archiveSubTask.Is_completed,
+				Assignee_id:  assigneeID(archiveSubTask.Assignee),
+			})
+		}
+		report.SubTasksCreated += len(task.SubTasks)
+
+		importTask := &repository.ImportTask{Task: task}
+		if archiveTask.Sprint != nil {
+			importTask.Sprint = sprints[*archiveTask.Sprint]
+		}
+		for name, raw := range archiveTask.Custom_fields {
+			field := fieldsByName[name]
+			if field.Type == models.CustomFieldTypeUser {
+				// User values are only kept for the importer, the only
+				// member.
+				var username string
+				json.Unmarshal(raw, &username)
+				id, ok := userIDs[username]
+				if !ok {
+					report.Warnings = append(report.Warnings, fmt.Sprintf("custom field %q value %q is not a member and was dropped", name, username))
+					continue
+				}
+				raw, _ = json.Marshal(id)
+			}
+			value, _ := customFieldValue(field, raw)
+			importTask.Custom_field_values = append(importTask.Custom_field_values, repository.ImportCustomFieldValue{Field: field, Value: value})
+		}
+		tasks = append(tasks, importTask)
+	}
+
+	err = h.WorkspaceRepo.Import(workspace, repository.WorkspaceContent{
+		Roles:         roles,
+		Labels:        labels,
+		Statuses:      statuses,
+		Priorities:    priorities,
+		Sprints:       sprints,
+		Custom_fields: fields,
+		Tasks:         tasks,
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	report.Workspace = workspace
+	report.LabelsCreated = len(labels)
+	report.SprintsCreated = len(sprints)
+	report.CustomFieldsCreated = len(fields)
+	report.TasksCreated = len(tasks)
+	if report.Warnings == nil {
+		report.Warnings = []string{}
+	}
+
+	return c.JSON(http.StatusCreated, report)
+}
+
+func validateArchive(archive *WorkspaceArchiveDTO, statuses []*models.WorkflowStatus, priorities []*models.WorkflowPriority) []string {
+	var errs []string
+
+	if archive.Version < 1 || archive.Version > archiveVersion {
+		errs = append(errs, fmt.Sprintf("unsupported archive version %d", archive.Version))
+	}
+	if archive.Workspace.Name == "" {
+		errs = append(errs, "workspace name cannot be empty")
+	}
+
+	if err := checkWorkflow(statuses, priorities); err != nil {
+		errs = append(errs, err.Error())
+	}
+	for i, status := range statuses {
+		if status.Name == "" || len(status.Name) > 50 || len(status.Color) > 20 {
+			errs = append(errs, fmt.Sprintf("statuses[%d]: name must be 1 to 50 and color at most 20 characters", i))
+		}
+		if status.Category != models.StatusCategoryTodo && status.Category != models.StatusCategoryInProgress && status.Category != models.StatusCategoryDone {
+			errs = append(errs, fmt.Sprintf("statuses[%d]: invalid category %q", i, status.Category))
+		}
+	}
+	for i, priority := range priorities {
+		if priority.Name == "" || len(priority.Name) > 50 || len(priority.Color) > 20 {
+			errs = append(errs, fmt.Sprintf("priorities[%d]: name must be 1 to 50 and color at most 20 characters", i))
+		}
+	}
+	w := newWorkflow(statuses, priorities)
+
+	active := 0
+	for i, sprint := range archive.Sprints {
+		if sprint.Name == "" {
+			errs = append(errs, fmt.Sprintf("sprints[%d]: name cannot be empty", i))
+		}
+		switch sprint.State {
+		case models.SprintActive:
+			active++
+		case models.SprintPlanned, models.SprintCompleted:
+		default:
+			errs = append(errs, fmt.Sprintf("sprints[%d]: invalid state %q", i, sprint.State))
+		}
+	}
+	if active > 1 {
+		errs = append(errs, "only one sprint can be active")
+	}
+
+	fields := make(map[string]*models.CustomField, len(archive.Custom_fields))
+	for i, field := range archive.Custom_fields {
+		switch {
+		case field.Name == "" || len(field.Name) > 100:
+			errs = append(errs, fmt.Sprintf("custom_fields[%d]: name must be 1 to 100 characters", i))
+		case fields[strings.ToLower(field.Name)] != nil:
+			errs = append(errs, fmt.Sprintf("custom_fields[%d]: %q is listed twice", i, field.Name))
+		case hasOptions(field.Type) != (len(field.Options) > 0):
+			errs = append(errs, fmt.Sprintf("custom_fields[%d]: only select fields have options, and they need at least one", i))
+		}
+		switch field.Type {
+		case models.CustomFieldTypeText, models.CustomFieldTypeNumber, models.CustomFieldTypeDate,
+			models.CustomFieldTypeSelect, models.CustomFieldTypeMultiSelect, models.CustomFieldTypeUser:
+		default:
+			errs = append(errs, fmt.Sprintf("custom_fields[%d]: invalid type %q", i, field.Type))
+		}
+		fields[strings.ToLower(field.Name)] = &models.CustomField{Name: field.Name, Type: field.Type, Options: field.Options}
+	}
+
+	for i, task := range archive.Tasks {
+		if task.Title == "" {
+			errs = append(errs, fmt.Sprintf("tasks[%d]: title cannot be empty", i))
+		}
+		if err := w.checkStatus(task.Status); err != nil {
+			errs = append(errs, fmt.Sprintf("tasks[%d]: %s", i, err.Error()))
+		}
+		if err := w.checkPriority(task.Priority); err != nil {
+			errs = append(errs, fmt.Sprintf("tasks[%d]: %s", i, err.Error()))
+		}
+		if task.Sprint != nil && (*task.Sprint < 0 || *task.Sprint >= len(archive.Sprints)) {
+			errs = append(errs, fmt.Sprintf("tasks[%d]: sprint %d is not in the archive", i, *task.Sprint))
+		}
+		for name, raw := range task.Custom_fields {
+			field := fields[strings.ToLower(name)]
+			if field == nil || field.Name != name {
+				errs = append(errs, fmt.Sprintf("tasks[%d]: unknown custom field %q", i, name))
+				continue
+			}
+			if field.Type == models.CustomFieldTypeUser {
+				var username string
+				if json.Unmarshal(raw, &username) != nil || username == "" {
+					errs = append(errs, fmt.Sprintf("tasks[%d]: custom field %q must be a username", i, name))
+				}
+				continue
+			}
+			if _, err := customFieldValue(field, raw); err != nil {
+				errs = append(errs, fmt.Sprintf("tasks[%d]: %s", i, err.Error()))
+			}
+		}
+		for j, subTask := range task.SubTasks {
+			if subTask.Title == "" {
+				errs = append(errs, fmt.Sprintf("tasks[%d].subtasks[%d]: title cannot be empty", i, j))
+			}
+		}
+	}
+	return errs
+}
+
+// archiveFromTrello maps a Trello board onto the archive format: open lists
+// become statuses in board order, cards become tasks, checklist items become
+// subtasks and labels carry over by name. Trello lists have no categories,
+// so the first list is taken as todo, the last as done and any in between
+// as in progress.
+func archiveFromTrello(board *trelloBoardDTO) (*WorkspaceArchiveDTO, []string) {
+	var warnings []string
+
+	lists := make([]trelloListDTO, 0, len(board.Lists))
+	for _, list := range board.Lists {
+		if !list.Closed {
+			lists = append(lists, list)
+		}
+	}
+	sort.SliceStable(lists, func(i, j int) bool { return lists[i].Pos < lists[j].Pos })
+
+	archive := &WorkspaceArchiveDTO{
+		Version:   archiveVersion,
+		Workspace: ArchiveWorkspaceDTO{Name: board.Name, Description: board.Desc},
+	}
+
+	// Status names must be unique, unlike list names.
+	statusNames := make(map[string]bool)
+	statusName := func(name string) string {
+		name = truncate(name, 50, "list name", &warnings)
+		for n := 2; name == "" || statusNames[name]; n++ {
+			suffix := fmt.Sprintf(" (%d)", n)
+			runes := []rune(name)
+			if len(runes)+len(suffix) > 50 {
+				runes = runes[:50-len(suffix)]
+			}
+			name = string(runes) + suffix
+		}
+		statusNames[name] = true
+		return name
+	}
+
+	statuses := make(map[string]uint)
+	for i, list := range lists {
+		statuses[list.ID] = uint(i)
+		name := statusName(list.Name)
+
+		category := models.StatusCategoryInProgress
+		switch {
+		case i == 0:
+			category = models.StatusCategoryTodo
+		case i == len(lists)-1:
+			category = models.StatusCategoryDone
+		}
+		archive.Statuses = append(archive.Statuses, models.TemplateStatus{Value: uint(i), Name: name, Category: category})
+	}
+	// A board with a single list still needs a done status.
+	if len(lists) == 1 {
+		archive.Statuses = append(archive.Statuses, models.TemplateStatus{Value: 1, Name: statusName("Done"), Category: models.StatusCategoryDone})
+	}
+
+	labelNames := make(map[string]string)
+	for _, label := range board.Labels {
+		name := label.Name
+		if name == "" {
+			name = label.Color
+		}
+		labelNames[label.ID] = name
+		archive.Labels = append(archive.Labels, ArchiveLabelDTO{Name: name, Color: label.Color})
+	}
+
+	usernames := make(map[string]string)
+	for _, member := range board.Members {
+		usernames[member.ID] = member.Username
+		archive.Members = append(archive.Members, ArchiveMemberDTO{Username: member.Username})
+	}
+
+	checklists := make(map[string][]trelloChecklistDTO)
+	for _, checklist := range board.Checklists {
+		checklists[checklist.IDCard] = append(checklists[checklist.IDCard], checklist)
+	}
+
+	for _, card := range board.Cards {
+		status, ok := statuses[card.IDList]
+		if card.Closed || !ok {
+			warnings = append(warnings, fmt.Sprintf("card %q is archived and was skipped", card.Name))
+			continue
+		}
+
+		task := ArchiveTaskDTO{
+			Title:       card.Name,
+			Description: card.Desc,
+			Status:      status,
+			Due_date:    card.Due,
+		}
+		if len(card.IDMembers) > 0 {
+			task.Assignee = usernames[card.IDMembers[0]]
+		}
+		for _, id := range card.IDLabels {
+			if name, ok := labelNames[id]; ok {
+				task.Labels = append(task.Labels, name)
+			}
+		}
+		for _, checklist := range checklists[card.ID] {
+			items := checklist.CheckItems
+			sort.SliceStable(items, func(i, j int) bool { return items[i].Pos < items[j].Pos })
+			for _, item := range items {
+				task.SubTasks = append(task.SubTasks, ArchiveSubTaskDTO{
+					Title:        item.Name,
+					Is_completed: item.State == "complete",
+				})
+			}
+		}
+		archive.Tasks = append(archive.Tasks, task)
+	}
+
+	return archive, warnings
+}
+
+// truncate cuts s to the column width, noting it in warnings when it does.
+func truncate(s string, max int, field string, warnings *[]string) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	*warnings = append(*warnings, fmt.Sprintf("%s %q was truncated to %d characters", field, string(runes[:20])+"...", max))
+	return string(runes[:max])
+}
@@ -0,0 +1,332 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+)
+
+type TaskBulkHandler struct {
+	TaskRepo              repository.Task
+	TaskLinkRepo          repository.TaskLink
+	LabelRepo             repository.Label
+	UserWorkspaceRoleRepo repository.UserWorkspaceRole
+	UserRepo              repository.User
+	WebhookRepo           repository.Webhook
+	WorkflowRepo          repository.Workflow
+	WorkspaceRepo         repository.Workspace
+	CustomFieldRepo       repository.CustomField
+}
+
+func NewTaskBulkHandler(taskRepo repository.Task, taskLinkRepo repository.TaskLink, labelRepo repository.Label, userWorkspaceRoleRepo repository.UserWorkspaceRole, userRepo repository.User, webhookRepo repository.Webhook, workflowRepo repository.Workflow, workspaceRepo repository.Workspace, customFieldRepo repository.CustomField) *TaskBulkHandler {
+	return &TaskBulkHandler{
+		TaskRepo:              taskRepo,
+		TaskLinkRepo:          taskLinkRepo,
+		LabelRepo:             labelRepo,
+		UserWorkspaceRoleRepo: userWorkspaceRoleRepo,
+		UserRepo:              userRepo,
+		WebhookRepo:           webhookRepo,
+		WorkflowRepo:          workflowRepo,
+		WorkspaceRepo:         workspaceRepo,
+		CustomFieldRepo:       customFieldRepo,
+	}
+}
+
+type TaskBulkDTO struct {
+	Task_ids     []uint `json:"task_ids" validate:"required,min=1,max=500"`
+	Operation    string `json:"operation" validate:"required,oneof=set_status set_priority assign add_label remove_label move delete"`
+	Status       *uint  `json:"status"`
+	Priority     *uint  `json:"priority"`
+	Assignee_id  *uint  `json:"assignee_id"`
+	Label_id     *uint  `json:"label_id"`
+	Workspace_id *uint  `json:"workspace_id"`
+}
+
+type TaskBulkResultDTO struct {
+	Task_id uint   `json:"task_id"`
+	Ok      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+}
+
+// operation turns the request into a repository operation, checking that the
+// value the chosen operation needs was given.
+func (t *TaskBulkDTO) operation() (repository.TaskBulkOperation, error) {
+	op := repository.TaskBulkOperation{Op: t.Operation}
+	var value *uint
+	var field string
+
+	switch t.Operation {
+	case repository.TaskBulkSetStatus:
+		value, field = t.Status, "status"
+	case repository.TaskBulkSetPriority:
+		value, field = t.Priority, "priority"
+	case repository.TaskBulkAssign:
+		value, field = t.Assignee_id, "assignee_id"
+	case repository.TaskBulkAddLabel, repository.TaskBulkRemoveLabel:
+		value, field = t.Label_id, "label_id"
+	case repository.TaskBulkMove:
+		value, field = t.Workspace_id, "workspace_id"
+	case repository.TaskBulkDelete:
+		return op, nil
+	}
+	if value == nil {
+		return op, fmt.Errorf("%s is required for %s", field, t.Operation)
+	}
+
+	switch field {
+	case "status":
+		op.Status = *value
+	case "priority":
+		op.Priority = *value
+	case "assignee_id":
+		op.Assignee_id = *value
+	case "label_id":
+		op.Label_id = *value
+	case "workspace_id":
+		op.Workspace_id = *value
+	}
+	return op, nil
+}
+
+// BulkTasks applies one operation to a list of tasks in a single
+// transaction. Ids that aren't tasks of this workspace are reported as
+// failed items; everything else is applied together or not at all.
+func (h *TaskBulkHandler) BulkTasks(c echo.Context) error {
+	authUsername, ok := c.Get("username").(string)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	workspaceId, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	taskBulkDTO := new(TaskBulkDTO)
+	if err := c.Bind(taskBulkDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(taskBulkDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	op, err := taskBulkDTO.operation()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	role, err := workspaceRole(h.UserRepo, h.UserWorkspaceRoleRepo, authUsername, uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if role == nil {
+		return c.JSON(http.StatusForbidden, "Access denied to the workspace")
+	}
+
+	w, err := loadWorkflow(h.WorkflowRepo, uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	// target is the workflow tasks must fit after the operation: the target
+	// workspace's for moves.
+	target := w
+	// Moved tasks keep their custom field values for the target's fields of
+	// the same name, and must have its required ones.
+	var targetFields []*models.CustomField
+	movedValues := make(map[uint][]*models.CustomFieldValue)
+
+	switch op.Op {
+	case repository.TaskBulkSetStatus:
+		if err := w.checkStatus(op.Status); err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+	case repository.TaskBulkSetPriority:
+		if err := w.checkPriority(op.Priority); err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+	case repository.TaskBulkAssign:
+		if op.Assignee_id != 0 {
+			roles, err := h.UserWorkspaceRoleRepo.FindEffectiveByWorkspaceID(uint(workspaceId))
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, err.Error())
+			}
+			isMember := false
+			for _, member := range roles {
+				if member.User_id == op.Assignee_id {
+					isMember = true
+					break
+				}
+			}
+			if !isMember {
+				return c.JSON(http.StatusBadRequest, "Assignee is not a member of the workspace")
+			}
+		}
+	case repository.TaskBulkAddLabel, repository.TaskBulkRemoveLabel:
+		label, err := h.LabelRepo.FindByID(op.Label_id)
+		if err != nil || label.Workspace_id != uint(workspaceId) {
+			return c.JSON(http.StatusBadRequest, "Label does not belong to the workspace")
+		}
+	case repository.TaskBulkMove:
+		targetRole, err := workspaceRole(h.UserRepo, h.UserWorkspaceRoleRepo, authUsername, op.Workspace_id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		if targetRole == nil {
+			return c.JSON(http.StatusForbidden, "Access denied to the target workspace")
+		}
+		// Archived workspaces are read-only, which the route's guard only
+		// checks for the source.
+		targetWorkspace, err := h.WorkspaceRepo.FindByID(op.Workspace_id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		if targetWorkspace.Archived_at != nil {
+			return c.JSON(http.StatusConflict, "Target workspace is archived, unarchive it first")
+		}
+		if target, err = loadWorkflow(h.WorkflowRepo, op.Workspace_id); err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+
+		sourceFields, err := h.CustomFieldRepo.FindByWorkspaceID(uint(workspaceId))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		if targetFields, err = h.CustomFieldRepo.FindByWorkspaceID(op.Workspace_id); err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		values, err := h.CustomFieldRepo.FindValuesByWorkspaceID(uint(workspaceId))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		targetRoles, err := h.UserWorkspaceRoleRepo.FindEffectiveByWorkspaceID(op.Workspace_id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		members := make(map[uint]bool, len(targetRoles))
+		for _, role := range targetRoles {
+			members[role.User_id] = true
+		}
+		byTask := make(map[uint][]*models.CustomFieldValue)
+		for _, value := range values {
+			byTask[value.Task_id] = append(byTask[value.Task_id], value)
+		}
+		for taskId, taskValues := range byTask {
+			movedValues[taskId] = remapCustomFieldValues(taskValues, sourceFields, targetFields, members)
+		}
+	}
+
+	tasks, err := h.TaskRepo.FindByWorkspaceID(uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	inWorkspace := make(map[uint]bool, len(tasks))
+	statuses := make(map[uint]uint, len(tasks))
+	priorities := make(map[uint]uint, len(tasks))
+	for _, task := range tasks {
+		inWorkspace[task.ID] = true
+		statuses[task.ID] = task.Status
+		priorities[task.ID] = task.Priority
+	}
+
+	// Completing a task is refused while a blocker outside this batch is
+	// still open.
+	blockedBy := make(map[uint][]uint)
+	if op.Op == repository.TaskBulkSetStatus && w.isDone(op.Status) {
+		links, err := h.TaskLinkRepo.FindByWorkspaceID(uint(workspaceId))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		for _, link := range links {
+			if link.Type == models.TaskLinkBlocks {
+				blockedBy[link.Linked_task_id] = append(blockedBy[link.Linked_task_id], link.Task_id)
+			}
+		}
+	}
+	requested := make(map[uint]bool, len(taskBulkDTO.Task_ids))
+	for _, id := range taskBulkDTO.Task_ids {
+		requested[id] = true
+	}
+	isBlocked := func(id uint) bool {
+		for _, blocker := range blockedBy[id] {
+			status, ok := statuses[blocker]
+			if ok && !w.isDone(status) && !requested[blocker] {
+				return true
+			}
+		}
+		return false
+	}
+
+	results := make([]TaskBulkResultDTO, 0, len(taskBulkDTO.Task_ids))
+	ids := make([]uint, 0, len(taskBulkDTO.Task_ids))
+	seen := make(map[uint]bool)
+	for _, id := range taskBulkDTO.Task_ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if !inWorkspace[id] {
+			results = append(results, TaskBulkResultDTO{Task_id: id, Error: "task not found in workspace"})
+			continue
+		}
+		if isBlocked(id) {
+			results = append(results, TaskBulkResultDTO{Task_id: id, Error: "task is blocked by open tasks"})
+			continue
+		}
+		if err := fitsWorkflow(op, target, statuses[id], priorities[id]); err != nil {
+			results = append(results, TaskBulkResultDTO{Task_id: id, Error: err.Error()})
+			continue
+		}
+		if op.Op == repository.TaskBulkMove {
+			if field := missingRequiredField(targetFields, movedValues[id]); field != nil {
+				message := fmt.Sprintf("custom field %q is required in the target workspace", field.Name)
+				results = append(results, TaskBulkResultDTO{Task_id: id, Error: message})
+				continue
+			}
+		}
+		ids = append(ids, id)
+		results = append(results, TaskBulkResultDTO{Task_id: id, Ok: true})
+	}
+
+	op.Custom_field_values = movedValues
+	if err := h.TaskRepo.BulkApply(ids, op); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	event := repository.EventTaskUpdated
+	if op.Op == repository.TaskBulkDelete {
+		event = repository.EventTaskDeleted
+	}
+	for _, id := range ids {
+		emit(c, h.WebhookRepo, uint(workspaceId), event, map[string]interface{}{
+			"task_id":   id,
+			"operation": taskBulkDTO,
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"operation": op.Op,
+		"applied":   len(ids),
+		"results":   results,
+	})
+}
+
+// fitsWorkflow checks that a task with the status and priority may undergo
+// the operation: that it may move to the new status, or that its status
+// and priority exist in the workspace it moves to.
+func fitsWorkflow(op repository.TaskBulkOperation, target *workflow, status, priority uint) error {
+	switch op.Op {
+	case repository.TaskBulkSetStatus:
+		return target.checkTransition(status, op.Status)
+	case repository.TaskBulkMove:
+		if err := target.checkStatus(status); err != nil {
+			return err
+		}
+		return target.checkPriority(priority)
+	}
+	return nil
+}
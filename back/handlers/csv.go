@@ -0,0 +1,586 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+)
+
+// taskCSVColumns lists the columns of the tasks CSV in their default order.
+// The workspace's custom fields follow them, each in a column named after
+// the field.
+var taskCSVColumns = []string{
+	"id", "title", "description", "status", "priority", "estimated_time", "actual_time",
+	"due_date", "assignee", "assignee_id", "image_url", "labels", "created_at", "updated_at",
+}
+
+type TaskCSVHandler struct {
+	TaskRepo              repository.Task
+	LabelRepo             repository.Label
+	UserWorkspaceRoleRepo repository.UserWorkspaceRole
+	UserRepo              repository.User
+	WebhookRepo           repository.Webhook
+	WorkflowRepo          repository.Workflow
+	CustomFieldRepo       repository.CustomField
+}
+
+func NewTaskCSVHandler(taskRepo repository.Task, labelRepo repository.Label, userWorkspaceRoleRepo repository.UserWorkspaceRole, userRepo repository.User, webhookRepo repository.Webhook, workflowRepo repository.Workflow, customFieldRepo repository.CustomField) *TaskCSVHandler {
+	return &TaskCSVHandler{
+		TaskRepo:              taskRepo,
+		LabelRepo:             labelRepo,
+		UserWorkspaceRoleRepo: userWorkspaceRoleRepo,
+		UserRepo:              userRepo,
+		WebhookRepo:           webhookRepo,
+		WorkflowRepo:          workflowRepo,
+		CustomFieldRepo:       customFieldRepo,
+	}
+}
+
+type CSVRowErrorDTO struct {
+	Row    int      `json:"row"`
+	Errors []string `json:"errors"`
+}
+
+type CSVImportReportDTO struct {
+	DryRun         bool             `json:"dry_run"`
+	Rows           int              `json:"rows"`
+	Created        int              `json:"created"`
+	Failed         int              `json:"failed"`
+	IgnoredColumns []string         `json:"ignored_columns"`
+	Errors         []CSVRowErrorDTO `json:"errors"`
+}
+
+// members maps the usernames of everyone with access to the workspace to
+// their ids.
+func (h *TaskCSVHandler) members(workspaceId uint) (map[string]uint, error) {
+	roles, err := h.UserWorkspaceRoleRepo.FindEffectiveByWorkspaceID(workspaceId)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make(map[string]uint)
+	for _, role := range roles {
+		user, err := h.UserRepo.FindByID(role.User_id)
+		if err != nil {
+			return nil, err
+		}
+		members[user.Username] = user.ID
+	}
+	return members, nil
+}
+
+func (h *TaskCSVHandler) ExportTasks(c echo.Context) error {
+	authUsername, ok := c.Get("username").(string)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	workspaceId, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	role, err := workspaceRole(h.UserRepo, h.UserWorkspaceRoleRepo, authUsername, uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if role == nil {
+		return c.JSON(http.StatusForbidden, "Access denied to the workspace")
+	}
+
+	fields, err := h.CustomFieldRepo.FindByWorkspaceID(uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	fieldColumns := customFieldCSVColumns(fields)
+
+	columns := append([]string{}, taskCSVColumns...)
+	for _, field := range fields {
+		if fieldColumns[strings.ToLower(field.Name)] == field {
+			columns = append(columns, field.Name)
+		}
+	}
+	if param := c.QueryParam("columns"); param != "" {
+		columns = strings.Split(param, ",")
+		for i, column := range columns {
+			columns[i] = strings.TrimSpace(column)
+			if !isTaskCSVColumn(columns[i]) && fieldColumns[strings.ToLower(columns[i])] == nil {
+				return c.JSON(http.StatusBadRequest, fmt.Sprintf("unknown column %q", column))
+			}
+		}
+	}
+
+	filter, err := parseTaskFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err := parseCustomFieldFilter(c, fields, &filter); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	tasks, err := h.TaskRepo.FindByFilter(uint(workspaceId), filter)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	workspaceValues, err := h.CustomFieldRepo.FindValuesByWorkspaceID(uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	values := make(map[uint]map[uint]string)
+	for _, value := range workspaceValues {
+		if values[value.Task_id] == nil {
+			values[value.Task_id] = make(map[uint]string)
+		}
+		values[value.Task_id][value.Custom_field_id] = value.Value
+	}
+
+	members, err := h.members(uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	usernames := make(map[uint]string, len(members))
+	for username, id := range members {
+		usernames[id] = username
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	c.Response().Header().Set(echo.HeaderContentDisposition,
+		fmt.Sprintf("attachment; filename=\"workspace-%d-tasks.csv\"", workspaceId))
+	c.Response().WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(c.Response())
+	if err := writer.Write(columns); err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		record := make([]string, len(columns))
+		for i, column := range columns {
+			if isTaskCSVColumn(column) {
+				record[i] = escapeCSVFormula(taskCSVValue(task, column, usernames))
+				continue
+			}
+			field := fieldColumns[strings.ToLower(column)]
+			record[i] = escapeCSVFormula(customFieldCSVValue(field, values[task.ID][field.ID], usernames))
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// ImportTasks bulk-creates tasks from a CSV sent either as the "file" form
+// field or as the raw request body. Headers are matched to columns, or to
+// custom fields, by name unless remapped with mapping=Header:column,...;
+// with dry_run=true the rows are only validated.
+func (h *TaskCSVHandler) ImportTasks(c echo.Context) error {
+	authUsername, ok := c.Get("username").(string)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	workspaceId, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	role, err := workspaceRole(h.UserRepo, h.UserWorkspaceRoleRepo, authUsername, uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if role == nil {
+		return c.JSON(http.StatusForbidden, "Access denied to the workspace")
+	}
+
+	dryRun, _ := strconv.ParseBool(c.QueryParam("dry_run"))
+
+	fields, err := h.CustomFieldRepo.FindByWorkspaceID(uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	fieldColumns := customFieldCSVColumns(fields)
+
+	mapping := make(map[string]string)
+	if param := c.QueryParam("mapping"); param != "" {
+		for _, pair := range strings.Split(param, ",") {
+			header, column, found := strings.Cut(pair, ":")
+			column = strings.TrimSpace(column)
+			if !found || (!isTaskCSVColumn(column) && fieldColumns[strings.ToLower(column)] == nil) {
+				return c.JSON(http.StatusBadRequest, fmt.Sprintf("invalid mapping %q", pair))
+			}
+			mapping[strings.ToLower(strings.TrimSpace(header))] = column
+		}
+	}
+
+	// The limit applies to a form upload as well as a raw body.
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxImportSize)
+	var tooLarge *http.MaxBytesError
+
+	var source io.Reader = c.Request().Body
+	file, err := c.FormFile("file")
+	if errors.As(err, &tooLarge) {
+		return c.JSON(http.StatusRequestEntityTooLarge, err.Error())
+	}
+	if err == nil {
+		opened, err := file.Open()
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		defer opened.Close()
+		source = opened
+	}
+
+	reader := csv.NewReader(source)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.As(err, &tooLarge) {
+		return c.JSON(http.StatusRequestEntityTooLarge, err.Error())
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, fmt.Sprintf("cannot read CSV header: %s", err.Error()))
+	}
+
+	report := &CSVImportReportDTO{DryRun: dryRun, IgnoredColumns: []string{}, Errors: []CSVRowErrorDTO{}}
+	columns := make([]string, len(header))
+	columnFields := make([]*models.CustomField, len(header))
+	hasTitle := false
+	for i, name := range header {
+		key := strings.ToLower(strings.TrimSpace(name))
+		column, ok := mapping[key]
+		if !ok {
+			column = key
+		}
+		if field := fieldColumns[strings.ToLower(column)]; field != nil && !isTaskCSVColumn(column) {
+			columnFields[i] = field
+			continue
+		}
+		if !isTaskCSVColumn(column) {
+			column = ""
+		}
+		switch column {
+		case "", "id", "created_at", "updated_at":
+			report.IgnoredColumns = append(report.IgnoredColumns, name)
+			continue
+		case "title":
+			hasTitle = true
+		}
+		columns[i] = column
+	}
+	if !hasTitle {
+		return c.JSON(http.StatusBadRequest, "CSV must have a title column")
+	}
+
+	members, err := h.members(uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	workspaceLabels, err := h.LabelRepo.FindByWorkspaceID(uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	labels := make(map[string]models.Label, len(workspaceLabels))
+	for _, label := range workspaceLabels {
+		labels[strings.ToLower(label.Name)] = *label
+	}
+
+	w, err := loadWorkflow(h.WorkflowRepo, uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	tasks := make([]*models.Task, 0)
+	taskValues := make([][]*models.CustomFieldValue, 0)
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.As(err, &tooLarge) {
+			return c.JSON(http.StatusRequestEntityTooLarge, err.Error())
+		}
+		report.Rows++
+		if err != nil {
+			report.Errors = append(report.Errors, CSVRowErrorDTO{Row: row, Errors: []string{err.Error()}})
+			continue
+		}
+
+		task := &models.Task{Workspace_id: uint(workspaceId)}
+		values := make([]*models.CustomFieldValue, 0)
+		var rowErrors []string
+		for i, value := range record {
+			if i >= len(columns) {
+				continue
+			}
+			value = unescapeCSVFormula(strings.TrimSpace(value))
+			if field := columnFields[i]; field != nil {
+				if value == "" {
+					continue
+				}
+				encoded, err := parseCustomFieldCSVValue(field, value, members)
+				if err != nil {
+					rowErrors = append(rowErrors, err.Error())
+					continue
+				}
+				values = append(values, &models.CustomFieldValue{Custom_field_id: field.ID, Value: encoded})
+				continue
+			}
+			if columns[i] == "" {
+				continue
+			}
+			if err := setTaskCSVValue(task, columns[i], value, members, labels); err != nil {
+				rowErrors = append(rowErrors, err.Error())
+			}
+		}
+		if task.Title == "" {
+			rowErrors = append(rowErrors, "title cannot be empty")
+		}
+		if field := missingRequiredField(fields, values); field != nil {
+			rowErrors = append(rowErrors, fmt.Sprintf("custom field %q is required", field.Name))
+		}
+		task.Status = w.initialStatus(task.Status)
+		if err := w.checkStatus(task.Status); err != nil {
+			rowErrors = append(rowErrors, err.Error())
+		}
+		if err := w.checkPriority(task.Priority); err != nil {
+			rowErrors = append(rowErrors, err.Error())
+		}
+
+		if len(rowErrors) > 0 {
+			report.Errors = append(report.Errors, CSVRowErrorDTO{Row: row, Errors: rowErrors})
+			continue
+		}
+		tasks = append(tasks, task)
+		taskValues = append(taskValues, values)
+	}
+	report.Failed = len(report.Errors)
+
+	if !dryRun {
+		if err := h.TaskRepo.CreateBatchWithCustomFields(tasks, taskValues); err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		report.Created = len(tasks)
+		for _, task := range tasks {
+			emit(c, h.WebhookRepo, task.Workspace_id, repository.EventTaskCreated, task)
+		}
+	}
+
+	return c.JSON(http.StatusOK, report)
+}
+
+// csvFormulaPrefixes are the characters that make spreadsheets read a cell
+// as a formula.
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// escapeCSVFormula prefixes a value that a spreadsheet would run as a
+// formula with a quote, which makes it plain text.
+func escapeCSVFormula(value string) string {
+	if value != "" && strings.ContainsRune(csvFormulaPrefixes, rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// unescapeCSVFormula undoes escapeCSVFormula, so exported files import
+// unchanged.
+func unescapeCSVFormula(value string) string {
+	if len(value) > 1 && value[0] == '\'' && strings.ContainsRune(csvFormulaPrefixes, rune(value[1])) {
+		return value[1:]
+	}
+	return value
+}
+
+func isTaskCSVColumn(name string) bool {
+	for _, column := range taskCSVColumns {
+		if column == name {
+			return true
+		}
+	}
+	return false
+}
+
+func taskCSVValue(task *models.Task, column string, usernames map[uint]string) string {
+	switch column {
+	case "id":
+		return strconv.FormatUint(uint64(task.ID), 10)
+	case "title":
+		return task.Title
+	case "description":
+		return task.Description
+	case "status":
+		return strconv.FormatUint(uint64(task.Status), 10)
+	case "priority":
+		return strconv.FormatUint(uint64(task.Priority), 10)
+	case "estimated_time":
+		return task.Estimated_time
+	case "actual_time":
+		return task.Actual_time
+	case "due_date":
+		return task.Due_date
+	case "assignee":
+		return usernames[task.Assignee_id]
+	case "assignee_id":
+		if task.Assignee_id == 0 {
+			return ""
+		}
+		return strconv.FormatUint(uint64(task.Assignee_id), 10)
+	case "image_url":
+		return task.Image_url
+	case "labels":
+		names := make([]string, len(task.Labels))
+		for i, label := range task.Labels {
+			names[i] = label.Name
+		}
+		return strings.Join(names, ";")
+	case "created_at":
+		return task.CreatedAt.UTC().Format("2006-01-02T15:04:05Z")
+	case "updated_at":
+		return task.UpdatedAt.UTC().Format("2006-01-02T15:04:05Z")
+	}
+	return ""
+}
+
+// customFieldCSVColumns maps the lowercased names of the fields to them.
+func customFieldCSVColumns(fields []*models.CustomField) map[string]*models.CustomField {
+	columns := make(map[string]*models.CustomField, len(fields))
+	for _, field := range fields {
+		if !isTaskCSVColumn(strings.ToLower(field.Name)) {
+			columns[strings.ToLower(field.Name)] = field
+		}
+	}
+	return columns
+}
+
+// customFieldCSVValue writes a stored custom field value the way
+// parseCustomFieldCSVValue reads it: users by username and multi-select
+// options separated by semicolons, like labels.
+func customFieldCSVValue(field *models.CustomField, value string, usernames map[uint]string) string {
+	if value == "" {
+		return ""
+	}
+	switch field.Type {
+	case models.CustomFieldTypeNumber:
+		return value
+	case models.CustomFieldTypeUser:
+		id, _ := strconv.ParseUint(value, 10, 64)
+		return usernames[uint(id)]
+	case models.CustomFieldTypeMultiSelect:
+		var options []string
+		json.Unmarshal([]byte(value), &options)
+		return strings.Join(options, ";")
+	}
+	var s string
+	json.Unmarshal([]byte(value), &s)
+	return s
+}
+
+// parseCustomFieldCSVValue checks a CSV cell for the field and returns the
+// value that is stored.
+func parseCustomFieldCSVValue(field *models.CustomField, value string, members map[string]uint) (string, error) {
+	var raw json.RawMessage
+	switch field.Type {
+	case models.CustomFieldTypeNumber:
+		raw = json.RawMessage(value)
+	case models.CustomFieldTypeUser:
+		id, ok := members[value]
+		if !ok {
+			return "", fmt.Errorf("custom field %q: %q is not a member of the workspace", field.Name, value)
+		}
+		raw, _ = json.Marshal(id)
+	case models.CustomFieldTypeMultiSelect:
+		options := make([]string, 0)
+		for _, option := range strings.Split(value, ";") {
+			if option = strings.TrimSpace(option); option != "" {
+				options = append(options, option)
+			}
+		}
+		raw, _ = json.Marshal(options)
+	default:
+		raw, _ = json.Marshal(value)
+	}
+	return customFieldValue(field, raw)
+}
+
+func setTaskCSVValue(task *models.Task, column, value string, members map[string]uint, labels map[string]models.Label) error {
+	if len([]rune(value)) > 100 && column != "labels" {
+		return fmt.Errorf("%s is longer than 100 characters", column)
+	}
+
+	switch column {
+	case "title":
+		task.Title = value
+	case "description":
+		task.Description = value
+	case "status", "priority":
+		if value == "" {
+			return nil
+		}
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q", column, value)
+		}
+		if column == "status" {
+			task.Status = uint(parsed)
+		} else {
+			task.Priority = uint(parsed)
+		}
+	case "estimated_time":
+		task.Estimated_time = value
+	case "actual_time":
+		task.Actual_time = value
+	case "due_date":
+		task.Due_date = value
+	case "assignee":
+		if value == "" {
+			return nil
+		}
+		id, ok := members[value]
+		if !ok {
+			return fmt.Errorf("assignee %q is not a member of the workspace", value)
+		}
+		task.Assignee_id = id
+	case "assignee_id":
+		if value == "" {
+			return nil
+		}
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid assignee_id %q", value)
+		}
+		for _, id := range members {
+			if id == uint(parsed) {
+				task.Assignee_id = id
+				return nil
+			}
+		}
+		return fmt.Errorf("assignee_id %d is not a member of the workspace", parsed)
+	case "image_url":
+		task.Image_url = value
+	case "labels":
+		for _, name := range strings.Split(value, ";") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			label, ok := labels[strings.ToLower(name)]
+			if !ok {
+				return fmt.Errorf("unknown label %q", name)
+			}
+			task.Labels = append(task.Labels, label)
+		}
+	}
+	return nil
+}
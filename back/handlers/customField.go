@@ -0,0 +1,471 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+	"gorm.io/gorm"
+)
+
+// customFieldQueryPrefix prefixes the task listing query parameters that
+// filter on a custom field, as in cf_3=high.
+const customFieldQueryPrefix = "cf_"
+
+const maxCustomFieldText = 255
+
+type CustomFieldHandler struct {
+	CustomFieldRepo       repository.CustomField
+	UserWorkspaceRoleRepo repository.UserWorkspaceRole
+	UserRepo              repository.User
+}
+
+func NewCustomFieldHandler(customFieldRepo repository.CustomField, userWorkspaceRoleRepo repository.UserWorkspaceRole, userRepo repository.User) *CustomFieldHandler {
+	return &CustomFieldHandler{
+		CustomFieldRepo:       customFieldRepo,
+		UserWorkspaceRoleRepo: userWorkspaceRoleRepo,
+		UserRepo:              userRepo,
+	}
+}
+
+// CustomFieldCreateDTO defines a custom field. Options are required for
+// select and multi-select fields and not allowed for the others. Type is
+// ignored on update.
+type CustomFieldCreateDTO struct {
+	Name     string   `json:"name" validate:"required,max=100"`
+	Type     string   `json:"type" validate:"omitempty,oneof=text number date select multi_select user"`
+	Options  []string `json:"options" validate:"max=100,dive,required,max=100"`
+	Required bool     `json:"required"`
+}
+
+// customFieldChanges are the custom field values a task request sets and
+// the fields it clears.
+type customFieldChanges struct {
+	Values  []*models.CustomFieldValue
+	Cleared []uint
+}
+
+func hasOptions(fieldType string) bool {
+	return fieldType == models.CustomFieldTypeSelect || fieldType == models.CustomFieldTypeMultiSelect
+}
+
+func isOption(field *models.CustomField, option string) bool {
+	for _, o := range field.Options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// customFieldValue checks a value for the field and returns it as the JSON
+// that is stored. Whether a user value is a member of the workspace is
+// left to the caller.
+func customFieldValue(field *models.CustomField, raw json.RawMessage) (string, error) {
+	invalid := fmt.Errorf("invalid value for custom field %q of type %s", field.Name, field.Type)
+	var value interface{}
+	switch field.Type {
+	case models.CustomFieldTypeText, models.CustomFieldTypeDate, models.CustomFieldTypeSelect:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return "", invalid
+		}
+		switch field.Type {
+		case models.CustomFieldTypeText:
+			if len(s) > maxCustomFieldText {
+				return "", fmt.Errorf("custom field %q is longer than %d characters", field.Name, maxCustomFieldText)
+			}
+		case models.CustomFieldTypeDate:
+			if _, err := time.Parse(time.DateOnly, s); err != nil {
+				return "", fmt.Errorf("custom field %q must be a date like 2006-01-02", field.Name)
+			}
+		case models.CustomFieldTypeSelect:
+			if !isOption(field, s) {
+				return "", fmt.Errorf("%q is not an option of custom field %q", s, field.Name)
+			}
+		}
+		value = s
+	case models.CustomFieldTypeNumber:
+		var f float64
+		if err := json.Unmarshal(raw, &f); err != nil {
+			return "", invalid
+		}
+		value = f
+	case models.CustomFieldTypeUser:
+		var id uint
+		if err := json.Unmarshal(raw, &id); err != nil || id == 0 {
+			return "", invalid
+		}
+		value = id
+	case models.CustomFieldTypeMultiSelect:
+		var options []string
+		if err := json.Unmarshal(raw, &options); err != nil {
+			return "", invalid
+		}
+		chosen := make([]string, 0, len(options))
+		seen := make(map[string]bool, len(options))
+		for _, option := range options {
+			if !isOption(field, option) {
+				return "", fmt.Errorf("%q is not an option of custom field %q", option, field.Name)
+			}
+			if !seen[option] {
+				seen[option] = true
+				chosen = append(chosen, option)
+			}
+		}
+		value = chosen
+	default:
+		return "", invalid
+	}
+
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
+
+// customFieldValues checks the custom field values of a task request for
+// the workspace. A null value clears the field; required fields can't be
+// cleared, and must be set when create is true. On failure the response
+// has already been written and the returned changes are nil.
+func customFieldValues(c echo.Context, customFieldRepo repository.CustomField, userWorkspaceRoleRepo repository.UserWorkspaceRole, workspaceId uint, input map[uint]json.RawMessage, create bool) (*customFieldChanges, error) {
+	fields, err := customFieldRepo.FindByWorkspaceID(workspaceId)
+	if err != nil {
+		return nil, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	byID := make(map[uint]*models.CustomField, len(fields))
+	for _, field := range fields {
+		byID[field.ID] = field
+		if create && field.Required {
+			if raw, ok := input[field.ID]; !ok || string(raw) == "null" {
+				return nil, c.JSON(http.StatusBadRequest, fmt.Sprintf("custom field %q is required", field.Name))
+			}
+		}
+	}
+
+	changes := &customFieldChanges{}
+	for id, raw := range input {
+		field, ok := byID[id]
+		if !ok {
+			return nil, c.JSON(http.StatusBadRequest, fmt.Sprintf("unknown custom field %d", id))
+		}
+
+		if string(raw) == "null" {
+			if field.Required {
+				return nil, c.JSON(http.StatusBadRequest, fmt.Sprintf("custom field %q is required", field.Name))
+			}
+			changes.Cleared = append(changes.Cleared, id)
+			continue
+		}
+
+		value, err := customFieldValue(field, raw)
+		if err != nil {
+			return nil, c.JSON(http.StatusBadRequest, err.Error())
+		}
+
+		if field.Type == models.CustomFieldTypeUser {
+			userId, _ := strconv.ParseUint(value, 10, 64)
+			roles, err := userWorkspaceRoleRepo.FindEffectiveByUserID(uint(userId))
+			if err != nil {
+				return nil, c.JSON(http.StatusInternalServerError, err.Error())
+			}
+			member := false
+			for _, role := range roles {
+				if role.Workspace_id == workspaceId {
+					member = true
+					break
+				}
+			}
+			if !member {
+				return nil, c.JSON(http.StatusBadRequest, fmt.Sprintf("custom field %q must be a member of the workspace", field.Name))
+			}
+		}
+
+		changes.Values = append(changes.Values, &models.CustomFieldValue{Custom_field_id: id, Value: value})
+	}
+	return changes, nil
+}
+
+// parseCustomFieldFilter reads the cf_<id> filters and the sort parameter,
+// cf_<id> or -cf_<id> for descending, of the task listing for a workspace
+// with the given fields.
+func parseCustomFieldFilter(c echo.Context, fields []*models.CustomField, filter *repository.TaskFilter) error {
+	field := func(param string) (*models.CustomField, error) {
+		id, err := strconv.ParseUint(strings.TrimPrefix(param, customFieldQueryPrefix), 10, 64)
+		if err == nil {
+			for _, field := range fields {
+				if field.ID == uint(id) {
+					return field, nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("unknown custom field %s", param)
+	}
+
+	for name, values := range c.QueryParams() {
+		if !strings.HasPrefix(name, customFieldQueryPrefix) {
+			continue
+		}
+		field, err := field(name)
+		if err != nil {
+			return err
+		}
+		for _, value := range values {
+			var raw json.RawMessage
+			switch field.Type {
+			case models.CustomFieldTypeNumber, models.CustomFieldTypeUser:
+				raw = json.RawMessage(value)
+			case models.CustomFieldTypeMultiSelect:
+				raw, _ = json.Marshal([]string{value})
+			default:
+				raw, _ = json.Marshal(value)
+			}
+			encoded, err := customFieldValue(field, raw)
+			if err != nil {
+				return err
+			}
+			filter.Custom_fields = append(filter.Custom_fields, repository.CustomFieldFilter{Field_id: field.ID, Value: encoded})
+		}
+	}
+
+	if sort := c.QueryParam("sort"); sort != "" {
+		name := strings.TrimPrefix(sort, "-")
+		if !strings.HasPrefix(name, customFieldQueryPrefix) {
+			return fmt.Errorf("invalid sort: %s", sort)
+		}
+		field, err := field(name)
+		if err != nil {
+			return err
+		}
+		filter.Sort_field = &field.ID
+		filter.Sort_desc = name != sort
+	}
+	return nil
+}
+
+// remapCustomFieldValues carries a task's values for the from fields over
+// to the to fields of the same name and type, as when the task moves to
+// another workspace. Values the new field doesn't accept, such as a
+// missing option or a user who isn't in members, are dropped.
+func remapCustomFieldValues(values []*models.CustomFieldValue, from, to []*models.CustomField, members map[uint]bool) []*models.CustomFieldValue {
+	byID := make(map[uint]*models.CustomField, len(from))
+	for _, field := range from {
+		byID[field.ID] = field
+	}
+	byName := make(map[string]*models.CustomField, len(to))
+	for _, field := range to {
+		byName[strings.ToLower(field.Name)] = field
+	}
+
+	remapped := make([]*models.CustomFieldValue, 0, len(values))
+	for _, value := range values {
+		source, ok := byID[value.Custom_field_id]
+		if !ok {
+			continue
+		}
+		field, ok := byName[strings.ToLower(source.Name)]
+		if !ok || field.Type != source.Type {
+			continue
+		}
+		encoded, err := customFieldValue(field, json.RawMessage(value.Value))
+		if err != nil {
+			continue
+		}
+		if field.Type == models.CustomFieldTypeUser {
+			userId, _ := strconv.ParseUint(encoded, 10, 64)
+			if !members[uint(userId)] {
+				continue
+			}
+		}
+		remapped = append(remapped, &models.CustomFieldValue{Task_id: value.Task_id, Custom_field_id: field.ID, Value: encoded})
+	}
+	return remapped
+}
+
+// missingRequiredField returns the first required field the values don't
+// set, or nil if they set them all.
+func missingRequiredField(fields []*models.CustomField, values []*models.CustomFieldValue) *models.CustomField {
+	set := make(map[uint]bool, len(values))
+	for _, value := range values {
+		set[value.Custom_field_id] = true
+	}
+	for _, field := range fields {
+		if field.Required && !set[field.ID] {
+			return field
+		}
+	}
+	return nil
+}
+
+// workspace checks the caller's access to the workspace in the path, which
+// must be owner access if owner is set, and returns its id. On failure the
+// response has already been written and the id is 0.
+func (h *CustomFieldHandler) workspace(c echo.Context, owner bool) (uint, error) {
+	authUsername, ok := c.Get("username").(string)
+	if !ok {
+		return 0, c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	workspaceId, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
+	if err != nil {
+		return 0, c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	role, err := workspaceRole(h.UserRepo, h.UserWorkspaceRoleRepo, authUsername, uint(workspaceId))
+	if err != nil {
+		return 0, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if role == nil {
+		return 0, c.JSON(http.StatusForbidden, "Access denied to the workspace")
+	}
+	if owner && role.Role != 1 {
+		return 0, c.JSON(http.StatusForbidden, "Only workspace owners can manage custom fields")
+	}
+	return uint(workspaceId), nil
+}
+
+// field loads the custom field in the path after checking that the caller
+// owns its workspace. On failure the response has already been written and
+// the returned field is nil.
+func (h *CustomFieldHandler) field(c echo.Context) (*models.CustomField, error) {
+	workspaceId, err := h.workspace(c, true)
+	if workspaceId == 0 {
+		return nil, err
+	}
+
+	fieldId, err := strconv.ParseUint(c.Param("fieldId"), 10, 64)
+	if err != nil {
+		return nil, c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	field, err := h.CustomFieldRepo.FindByID(uint(fieldId))
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && field.Workspace_id != workspaceId) {
+		return nil, c.JSON(http.StatusNotFound, "Custom field not found")
+	}
+	if err != nil {
+		return nil, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	return field, nil
+}
+
+// define binds and checks a field definition for the field's workspace and
+// applies it to the field. On failure the response has already been
+// written and false is returned.
+func (h *CustomFieldHandler) define(c echo.Context, field *models.CustomField) (bool, error) {
+	customFieldCreateDTO := new(CustomFieldCreateDTO)
+	if err := c.Bind(customFieldCreateDTO); err != nil {
+		return false, c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(customFieldCreateDTO); err != nil {
+		return false, c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if field.ID == 0 {
+		if customFieldCreateDTO.Type == "" {
+			return false, c.JSON(http.StatusBadRequest, "type is required")
+		}
+		field.Type = customFieldCreateDTO.Type
+	}
+
+	options := customFieldCreateDTO.Options
+	if hasOptions(field.Type) && len(options) == 0 {
+		return false, c.JSON(http.StatusBadRequest, "select fields need at least one option")
+	}
+	if !hasOptions(field.Type) && len(options) > 0 {
+		return false, c.JSON(http.StatusBadRequest, "only select fields have options")
+	}
+	seen := make(map[string]bool, len(options))
+	for _, option := range options {
+		if seen[option] {
+			return false, c.JSON(http.StatusBadRequest, fmt.Sprintf("option %q is listed twice", option))
+		}
+		seen[option] = true
+	}
+
+	fields, err := h.CustomFieldRepo.FindByWorkspaceID(field.Workspace_id)
+	if err != nil {
+		return false, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	for _, other := range fields {
+		if other.ID != field.ID && strings.EqualFold(other.Name, customFieldCreateDTO.Name) {
+			return false, c.JSON(http.StatusConflict, "The workspace already has a custom field with this name")
+		}
+	}
+
+	field.Name = customFieldCreateDTO.Name
+	field.Options = options
+	field.Required = customFieldCreateDTO.Required
+	return true, nil
+}
+
+func (h *CustomFieldHandler) GetCustomFields(c echo.Context) error {
+	workspaceId, err := h.workspace(c, false)
+	if workspaceId == 0 {
+		return err
+	}
+
+	fields, err := h.CustomFieldRepo.FindByWorkspaceID(workspaceId)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, fields)
+}
+
+func (h *CustomFieldHandler) CreateCustomField(c echo.Context) error {
+	workspaceId, err := h.workspace(c, true)
+	if workspaceId == 0 {
+		return err
+	}
+
+	field := &models.CustomField{Workspace_id: workspaceId}
+	if ok, err := h.define(c, field); !ok {
+		return err
+	}
+
+	if err := h.CustomFieldRepo.Create(field); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusCreated, field)
+}
+
+// UpdateCustomField renames the field and changes its options and whether
+// it is required. Its type can't change. Tasks keep values that use a
+// removed option until they are set again.
+func (h *CustomFieldHandler) UpdateCustomField(c echo.Context) error {
+	field, err := h.field(c)
+	if field == nil {
+		return err
+	}
+
+	if ok, err := h.define(c, field); !ok {
+		return err
+	}
+
+	if err := h.CustomFieldRepo.Update(field); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, field)
+}
+
+// DeleteCustomField deletes the field and every task's value for it.
+func (h *CustomFieldHandler) DeleteCustomField(c echo.Context) error {
+	field, err := h.field(c)
+	if field == nil {
+		return err
+	}
+
+	if err := h.CustomFieldRepo.Delete(field.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
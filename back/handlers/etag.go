@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+func etag(version uint) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+func setETag(c echo.Context, version uint) {
+	c.Response().Header().Set("ETag", etag(version))
+}
+
+// ifMatch reports whether the request's If-Match header allows a write to a
+// row at the given version. A request without If-Match is always allowed.
+func ifMatch(c echo.Context, version uint) bool {
+	header := c.Request().Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag(version) {
+			return true
+		}
+	}
+	return false
+}
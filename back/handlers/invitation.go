@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+	"github.com/raeinsoltani/gorello/back/utils"
+	"gorm.io/gorm"
+)
+
+const defaultInvitationDays = 7
+
+type InvitationHandler struct {
+	InvitationRepo        repository.Invitation
+	WorkspaceRepo         repository.Workspace
+	UserWorkspaceRoleRepo repository.UserWorkspaceRole
+	UserRepo              repository.User
+	WebhookRepo           repository.Webhook
+	// PasswordLogin is false on deployments that only allow SSO, where
+	// invitees sign in with SSO and then accept.
+	PasswordLogin bool
+}
+
+func NewInvitationHandler(invitationRepo repository.Invitation, workspaceRepo repository.Workspace, userWorkspaceRoleRepo repository.UserWorkspaceRole, userRepo repository.User, webhookRepo repository.Webhook, passwordLogin bool) *InvitationHandler {
+	return &InvitationHandler{
+		InvitationRepo:        invitationRepo,
+		WorkspaceRepo:         workspaceRepo,
+		UserWorkspaceRoleRepo: userWorkspaceRoleRepo,
+		UserRepo:              userRepo,
+		WebhookRepo:           webhookRepo,
+		PasswordLogin:         passwordLogin,
+	}
+}
+
+// InvitationCreateDTO creates an invitation for email, or a shareable join
+// link if email is empty. Max_uses only applies to links.
+type InvitationCreateDTO struct {
+	Email           string `json:"email" validate:"omitempty,email,max=255"`
+	Role            uint   `json:"role" validate:"oneof=0 1"`
+	Expires_in_days uint   `json:"expires_in_days" validate:"max=30"`
+	Max_uses        uint   `json:"max_uses" validate:"max=10000"`
+}
+
+// InvitationCreatedDTO is returned on creation, the only time the token
+// itself is shown.
+type InvitationCreatedDTO struct {
+	*models.Invitation
+	Token string `json:"token"`
+	Url   string `json:"url"`
+}
+
+// InvitationPreviewDTO lets an invitee see what they are accepting.
+type InvitationPreviewDTO struct {
+	Workspace_name string        `json:"workspace_name"`
+	Inviter        PublicUserDTO `json:"inviter"`
+	Email          string        `json:"email"`
+	Role           uint          `json:"role"`
+	Expires_at     time.Time     `json:"expires_at"`
+}
+
+// owner checks that the caller owns the workspace in the path and returns
+// its id. On failure the response has already been written and the id is 0.
+func (h *InvitationHandler) owner(c echo.Context) (uint, error) {
+	authUsername, ok := c.Get("username").(string)
+	if !ok {
+		return 0, c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	workspaceId, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
+	if err != nil {
+		return 0, c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	role, err := workspaceRole(h.UserRepo, h.UserWorkspaceRoleRepo, authUsername, uint(workspaceId))
+	if err != nil {
+		return 0, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if role == nil || role.Role != 1 {
+		return 0, c.JSON(http.StatusForbidden, "Only workspace owners can manage invitations")
+	}
+	return uint(workspaceId), nil
+}
+
+// invitation loads the invitation for the token in the path, if it can
+// still be accepted, which it can't while its workspace is archived. On
+// failure the response has already been written and the returned
+// invitation is nil.
+func (h *InvitationHandler) invitation(c echo.Context) (*models.Invitation, error) {
+	invitation, err := h.InvitationRepo.FindByHash(utils.HashAccessToken(c.Param("token")))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, c.JSON(http.StatusNotFound, "Invitation not found")
+	}
+	if err != nil {
+		return nil, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	if invitation.Revoked_at != nil || !time.Now().Before(invitation.Expires_at) ||
+		(invitation.Max_uses != 0 && invitation.Uses >= invitation.Max_uses) {
+		return nil, c.JSON(http.StatusGone, repository.ErrInvitationUnusable.Error())
+	}
+
+	workspace, err := h.WorkspaceRepo.FindByID(invitation.Workspace_id)
+	if err != nil {
+		return nil, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if workspace.Archived_at != nil {
+		return nil, c.JSON(http.StatusConflict, "Workspace is archived, unarchive it first")
+	}
+	return invitation, nil
+}
+
+// joined writes the membership an invitation was accepted with, or the
+// error accepting it.
+func (h *InvitationHandler) joined(c echo.Context, role *models.UserWorkspaceRole, err error) error {
+	if errors.Is(err, repository.ErrInvitationUnusable) {
+		return c.JSON(http.StatusGone, err.Error())
+	}
+	if errors.Is(err, repository.ErrAlreadyMember) {
+		return c.JSON(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	emit(c, h.WebhookRepo, role.Workspace_id, repository.EventMemberAdded, role)
+
+	return c.JSON(http.StatusCreated, role)
+}
+
+func (h *InvitationHandler) CreateInvitation(c echo.Context) error {
+	workspaceId, err := h.owner(c)
+	if workspaceId == 0 {
+		return err
+	}
+
+	authUsername, _ := c.Get("username").(string)
+	inviter, err := h.UserRepo.FindByUsername(authUsername)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	invitationCreateDTO := new(InvitationCreateDTO)
+	if err := c.Bind(invitationCreateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(invitationCreateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	days := invitationCreateDTO.Expires_in_days
+	if days == 0 {
+		days = defaultInvitationDays
+	}
+	maxUses := invitationCreateDTO.Max_uses
+	if invitationCreateDTO.Email != "" {
+		maxUses = 1
+	}
+
+	token, hash, err := utils.GenerateInvitationToken()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	invitation := models.Invitation{
+		Workspace_id: workspaceId,
+		Email:        strings.ToLower(invitationCreateDTO.Email),
+		Role:         invitationCreateDTO.Role,
+		Inviter_id:   inviter.ID,
+		Token_hash:   hash,
+		Expires_at:   time.Now().AddDate(0, 0, int(days)),
+		Max_uses:     maxUses,
+	}
+	if err := h.InvitationRepo.Create(&invitation); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusCreated, InvitationCreatedDTO{
+		Invitation: &invitation,
+		Token:      token,
+		Url:        "/invitations/" + token,
+	})
+}
+
+// GetInvitations lists the workspace's invitations that can still be
+// accepted.
+func (h *InvitationHandler) GetInvitations(c echo.Context) error {
+	workspaceId, err := h.owner(c)
+	if workspaceId == 0 {
+		return err
+	}
+
+	invitations, err := h.InvitationRepo.FindPendingByWorkspaceID(workspaceId)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, invitations)
+}
+
+func (h *InvitationHandler) RevokeInvitation(c echo.Context) error {
+	workspaceId, err := h.owner(c)
+	if workspaceId == 0 {
+		return err
+	}
+
+	invitationId, err := strconv.ParseUint(c.Param("invitationId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	invitation, err := h.InvitationRepo.FindByID(uint(invitationId))
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && invitation.Workspace_id != workspaceId) {
+		return c.JSON(http.StatusNotFound, "Invitation not found")
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	if err := h.InvitationRepo.Revoke(invitation.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetInvitation shows an invitation to anyone holding its token.
+func (h *InvitationHandler) GetInvitation(c echo.Context) error {
+	invitation, err := h.invitation(c)
+	if invitation == nil {
+		return err
+	}
+
+	workspace, err := h.WorkspaceRepo.FindByID(invitation.Workspace_id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	inviter, err := h.UserRepo.FindByID(invitation.Inviter_id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, InvitationPreviewDTO{
+		Workspace_name: workspace.Name,
+		Inviter:        newPublicUserDTO(inviter),
+		Email:          invitation.Email,
+		Role:           invitation.Role,
+		Expires_at:     invitation.Expires_at,
+	})
+}
+
+// AcceptInvitation joins the logged-in caller to the workspace. An
+// invitation sent to an email can only be accepted by the account with
+// that email.
+func (h *InvitationHandler) AcceptInvitation(c echo.Context) error {
+	authUsername, ok := c.Get("username").(string)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	invitation, err := h.invitation(c)
+	if invitation == nil {
+		return err
+	}
+
+	user, err := h.UserRepo.FindByUsername(authUsername)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if invitation.Email != "" && !strings.EqualFold(user.Email, invitation.Email) {
+		return c.JSON(http.StatusForbidden, "This invitation was sent to another email address")
+	}
+
+	role, err := h.InvitationRepo.Accept(invitation.ID, user.ID)
+	return h.joined(c, role, err)
+}
+
+// RegisterAndAccept creates an account, taking the same body as
+// /auth/signup, and joins it to the workspace. The account is only created
+// if the invitation can be accepted.
+func (h *InvitationHandler) RegisterAndAccept(c echo.Context) error {
+	if !h.PasswordLogin {
+		return c.JSON(http.StatusForbidden, "Password accounts are disabled, sign in with SSO and accept the invitation")
+	}
+
+	invitation, err := h.invitation(c)
+	if invitation == nil {
+		return err
+	}
+
+	user, err := newUser(c, h.UserRepo, invitation.Email)
+	if user == nil {
+		return err
+	}
+
+	role, err := h.InvitationRepo.AcceptNewUser(invitation.ID, user)
+	return h.joined(c, role, err)
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/middleware"
+	"github.com/raeinsoltani/gorello/back/repository"
+)
+
+// LoginGuard throttles login attempts per account and locks an account out
+// after repeated failures, whichever address they come from.
+type LoginGuard struct {
+	RateLimitRepo repository.RateLimit
+	AccountLimit  repository.RateLimitPolicy
+	Lockout       repository.LockoutPolicy
+}
+
+func NewLoginGuard(rateLimitRepo repository.RateLimit, accountLimit repository.RateLimitPolicy, lockout repository.LockoutPolicy) *LoginGuard {
+	return &LoginGuard{
+		RateLimitRepo: rateLimitRepo,
+		AccountLimit:  accountLimit,
+		Lockout:       lockout,
+	}
+}
+
+// allow reports whether a login attempt for the account may go ahead. When
+// it may not, the 429 response has already been written. Store errors let
+// the attempt through.
+func (g *LoginGuard) allow(c echo.Context, username string) (bool, error) {
+	locked, err := g.RateLimitRepo.LockedFor("lockout:" + username)
+	if err != nil {
+		log.Printf("error checking lockout: %s", err.Error())
+	}
+	if locked > 0 {
+		middleware.SetRetryAfter(c, locked)
+		return false, c.JSON(http.StatusTooManyRequests, "Too many failed logins, try again later")
+	}
+
+	wait, err := g.RateLimitRepo.Take("login:account:"+username, g.AccountLimit)
+	if err != nil {
+		log.Printf("error checking rate limit: %s", err.Error())
+	}
+	if wait > 0 {
+		middleware.SetRetryAfter(c, wait)
+		return false, c.JSON(http.StatusTooManyRequests, "Too many login attempts, try again later")
+	}
+	return true, nil
+}
+
+// failed counts a failed password or code for the account.
+func (g *LoginGuard) failed(username string) {
+	if _, err := g.RateLimitRepo.RecordFailure("lockout:"+username, g.Lockout); err != nil {
+		log.Printf("error recording failed login: %s", err.Error())
+	}
+}
+
+// succeeded clears the account's failures after a complete login.
+func (g *LoginGuard) succeeded(username string) {
+	if err := g.RateLimitRepo.ResetFailures("lockout:" + username); err != nil {
+		log.Printf("error resetting failed logins: %s", err.Error())
+	}
+}
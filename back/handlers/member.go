@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+	"gorm.io/gorm"
+)
+
+type MemberHandler struct {
+	UserWorkspaceRoleRepo repository.UserWorkspaceRole
+	UserRepo              repository.User
+	WorkspaceRepo         repository.Workspace
+	WebhookRepo           repository.Webhook
+}
+
+func NewMemberHandler(userWorkspaceRoleRepo repository.UserWorkspaceRole, userRepo repository.User, workspaceRepo repository.Workspace, webhookRepo repository.Webhook) *MemberHandler {
+	return &MemberHandler{
+		UserWorkspaceRoleRepo: userWorkspaceRoleRepo,
+		UserRepo:              userRepo,
+		WorkspaceRepo:         workspaceRepo,
+		WebhookRepo:           webhookRepo,
+	}
+}
+
+type MemberDTO struct {
+	PublicUserDTO
+	Role uint `json:"role"`
+}
+
+type MemberUpdateDTO struct {
+	Role *uint `json:"role" validate:"required,oneof=0 1"`
+}
+
+// OwnershipTransferDTO confirms a transfer by repeating the workspace's
+// name, so that it isn't handed over by accident.
+type OwnershipTransferDTO struct {
+	To             string `json:"to" validate:"required"`
+	Confirm        string `json:"confirm" validate:"required"`
+	Keep_ownership bool   `json:"keep_ownership"`
+}
+
+// role loads the caller's membership in the workspace from the path. On
+// failure the response has already been written and the returned role is
+// nil.
+func (h *MemberHandler) role(c echo.Context) (*models.UserWorkspaceRole, error) {
+	authUsername, ok := c.Get("username").(string)
+	if !ok {
+		return nil, c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	workspaceId, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
+	if err != nil {
+		return nil, c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	role, err := workspaceRole(h.UserRepo, h.UserWorkspaceRoleRepo, authUsername, uint(workspaceId))
+	if err != nil {
+		return nil, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if role == nil {
+		return nil, c.JSON(http.StatusForbidden, "Access denied to the workspace")
+	}
+	return role, nil
+}
+
+// member loads the membership of the user named in the path. On failure
+// the response has already been written and the returned role is nil.
+func (h *MemberHandler) member(c echo.Context, workspaceId uint) (*models.UserWorkspaceRole, error) {
+	role, err := workspaceRole(h.UserRepo, h.UserWorkspaceRoleRepo, c.Param("username"), workspaceId)
+	if err != nil {
+		return nil, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if role == nil {
+		return nil, c.JSON(http.StatusNotFound, "Member not found")
+	}
+	return role, nil
+}
+
+// isDirect reports whether the user is a member of the workspace in their
+// own right rather than only through a team or the organization.
+func (h *MemberHandler) isDirect(workspaceId uint, userId uint) (bool, error) {
+	roles, err := h.UserWorkspaceRoleRepo.FindByWorkspaceID(workspaceId)
+	if err != nil {
+		return false, err
+	}
+	for _, role := range roles {
+		if role.User_id == userId {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// memberError writes the response for a failed membership change.
+func memberError(c echo.Context, err error) error {
+	if errors.Is(err, repository.ErrLastOwner) {
+		return c.JSON(http.StatusConflict, "The workspace must keep at least one owner, make another member an owner first")
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, "Member not found")
+	}
+	return c.JSON(http.StatusInternalServerError, err.Error())
+}
+
+func (h *MemberHandler) GetMembers(c echo.Context) error {
+	role, err := h.role(c)
+	if role == nil {
+		return err
+	}
+
+	roles, err := h.UserWorkspaceRoleRepo.FindByWorkspaceID(role.Workspace_id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	members := make([]MemberDTO, 0, len(roles))
+	for _, member := range roles {
+		user, err := h.UserRepo.FindByID(member.User_id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		members = append(members, MemberDTO{PublicUserDTO: newPublicUserDTO(user), Role: member.Role})
+	}
+
+	return c.JSON(http.StatusOK, members)
+}
+
+// UpdateMember changes a member's role. Owners may promote other members
+// to owner, so a workspace can have several. A role that comes from a team
+// or the organization can only be raised here, which makes the user a
+// member in their own right.
+func (h *MemberHandler) UpdateMember(c echo.Context) error {
+	role, err := h.role(c)
+	if role == nil {
+		return err
+	}
+	if role.Role != 1 {
+		return c.JSON(http.StatusForbidden, "Only workspace owners can change roles")
+	}
+
+	memberUpdateDTO := new(MemberUpdateDTO)
+	if err := c.Bind(memberUpdateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(memberUpdateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	member, err := h.member(c, role.Workspace_id)
+	if member == nil {
+		return err
+	}
+
+	if *memberUpdateDTO.Role != 1 {
+		direct, err := h.isDirect(member.Workspace_id, member.User_id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		if !direct {
+			return c.JSON(http.StatusConflict, "This member's role comes from a team or the organization, change it there")
+		}
+	}
+
+	if err := h.UserWorkspaceRoleRepo.UpdateRole(member.Workspace_id, member.User_id, *memberUpdateDTO.Role); err != nil {
+		return memberError(c, err)
+	}
+	member.Role = *memberUpdateDTO.Role
+
+	emit(c, h.WebhookRepo, member.Workspace_id, repository.EventMemberUpdated, member)
+
+	return c.JSON(http.StatusOK, member)
+}
+
+// RemoveMember removes a member. Owners may remove anyone; other members
+// may only leave.
+func (h *MemberHandler) RemoveMember(c echo.Context) error {
+	role, err := h.role(c)
+	if role == nil {
+		return err
+	}
+
+	member, err := h.member(c, role.Workspace_id)
+	if member == nil {
+		return err
+	}
+	if role.Role != 1 && member.User_id != role.User_id {
+		return c.JSON(http.StatusForbidden, "Only workspace owners can remove other members")
+	}
+
+	if err := h.UserWorkspaceRoleRepo.Delete(member.Workspace_id, member.User_id); err != nil {
+		return memberError(c, err)
+	}
+
+	emit(c, h.WebhookRepo, member.Workspace_id, repository.EventMemberRemoved, member)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// TransferOwnership hands the workspace over to another member, who
+// becomes an owner in their own right. The caller steps down to a regular
+// member unless keep_ownership is set, which is required when their
+// ownership comes from a team or the organization.
+func (h *MemberHandler) TransferOwnership(c echo.Context) error {
+	role, err := h.role(c)
+	if role == nil {
+		return err
+	}
+	if role.Role != 1 {
+		return c.JSON(http.StatusForbidden, "Only workspace owners can transfer ownership")
+	}
+
+	ownershipTransferDTO := new(OwnershipTransferDTO)
+	if err := c.Bind(ownershipTransferDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(ownershipTransferDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	workspace, err := h.WorkspaceRepo.FindByID(role.Workspace_id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if ownershipTransferDTO.Confirm != workspace.Name {
+		return c.JSON(http.StatusBadRequest, "Confirm the transfer by sending the workspace's name as confirm")
+	}
+
+	target, err := workspaceRole(h.UserRepo, h.UserWorkspaceRoleRepo, ownershipTransferDTO.To, role.Workspace_id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if target == nil {
+		return c.JSON(http.StatusBadRequest, "Ownership can only be transferred to a member of the workspace")
+	}
+	if target.User_id == role.User_id {
+		return c.JSON(http.StatusBadRequest, "You already own the workspace")
+	}
+	if !ownershipTransferDTO.Keep_ownership {
+		direct, err := h.isDirect(role.Workspace_id, role.User_id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		if !direct {
+			return c.JSON(http.StatusConflict, "Your ownership comes from a team or the organization, set keep_ownership or change it there")
+		}
+	}
+
+	err = h.UserWorkspaceRoleRepo.TransferOwnership(role.Workspace_id, role.User_id, target.User_id, ownershipTransferDTO.Keep_ownership)
+	if err != nil {
+		return memberError(c, err)
+	}
+
+	target.Role = 1
+	emit(c, h.WebhookRepo, role.Workspace_id, repository.EventMemberUpdated, target)
+	if !ownershipTransferDTO.Keep_ownership {
+		role.Role = 0
+		emit(c, h.WebhookRepo, role.Workspace_id, repository.EventMemberUpdated, role)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/oidc"
+	"github.com/raeinsoltani/gorello/back/repository"
+	"github.com/raeinsoltani/gorello/back/utils"
+)
+
+const oidcStateCookie = "gorello_oidc"
+
+type OIDCHandler struct {
+	UserRepo    repository.User
+	SessionRepo repository.Session
+	Provider    *oidc.Provider
+}
+
+func NewOIDCHandler(userRepo repository.User, sessionRepo repository.Session, provider *oidc.Provider) *OIDCHandler {
+	return &OIDCHandler{
+		UserRepo:    userRepo,
+		SessionRepo: sessionRepo,
+		Provider:    provider,
+	}
+}
+
+// Login redirects to the identity provider. The state, nonce and PKCE
+// verifier travel in a signed cookie to the callback.
+func (h *OIDCHandler) Login(c echo.Context) error {
+	redirect, err := h.start(c, 0)
+	if redirect == "" {
+		return err
+	}
+	return c.Redirect(http.StatusFound, redirect)
+}
+
+// Link starts a login that links the identity to the signed-in user's
+// account, which is how existing accounts get SSO: they are never linked
+// by email alone, since nothing proves that a local account owns its
+// email. It returns the identity provider URL to send the browser to.
+func (h *OIDCHandler) Link(c echo.Context) error {
+	username := c.Param("username")
+	authUsername := c.Get("username")
+	if authUsername != username {
+		return c.JSON(http.StatusForbidden, "Access denied")
+	}
+	if c.Get("token_id") != nil {
+		return c.JSON(http.StatusForbidden, "Accounts cannot be changed with an access token")
+	}
+
+	user, err := h.UserRepo.FindByUsername(username)
+	if err != nil {
+		log.Printf("error finding user: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if user == nil {
+		return c.JSON(http.StatusNotFound, "User not found")
+	}
+
+	redirect, err := h.start(c, user.ID)
+	if redirect == "" {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]string{
+		"url": redirect,
+	})
+}
+
+// start sets the state cookie of a login, or of linking the account with
+// id linkUserId, and returns the identity provider URL to redirect to. On
+// failure the response has already been written and the URL is empty.
+func (h *OIDCHandler) start(c echo.Context, linkUserId uint) (string, error) {
+	state := utils.OIDCState{Link_user_id: linkUserId}
+	var err error
+	for _, value := range []*string{&state.State, &state.Nonce, &state.Verifier} {
+		if *value, err = oidc.RandomString(); err != nil {
+			return "", c.JSON(http.StatusInternalServerError, err.Error())
+		}
+	}
+
+	redirect, err := h.Provider.AuthCodeURL(state.State, state.Nonce, state.Verifier)
+	if err != nil {
+		log.Printf("error starting OIDC login: %s", err.Error())
+		return "", c.JSON(http.StatusBadGateway, "Identity provider is unavailable")
+	}
+
+	cookie, err := utils.GenerateOIDCStateJWT(state)
+	if err != nil {
+		return "", c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    cookie,
+		Path:     "/auth/oidc",
+		MaxAge:   600,
+		HttpOnly: true,
+		Secure:   c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return redirect, nil
+}
+
+// Callback completes the login: it checks the state, exchanges the code,
+// verifies the ID token and returns a JWT for the linked or newly
+// provisioned user, or for the user who asked to link the identity.
+func (h *OIDCHandler) Callback(c echo.Context) error {
+	if errorCode := c.QueryParam("error"); errorCode != "" {
+		return c.JSON(http.StatusUnauthorized, fmt.Sprintf("%s: %s", errorCode, c.QueryParam("error_description")))
+	}
+
+	cookie, err := c.Cookie(oidcStateCookie)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "Missing login state, start again at /auth/oidc/login")
+	}
+	c.SetCookie(&http.Cookie{Name: oidcStateCookie, Path: "/auth/oidc", MaxAge: -1})
+
+	state, err := utils.ParseOIDCStateJWT(cookie.Value)
+	if err != nil || state.State != c.QueryParam("state") {
+		return c.JSON(http.StatusBadRequest, "Invalid or expired login state")
+	}
+
+	idToken, err := h.Provider.Exchange(c.QueryParam("code"), state.Verifier)
+	if err != nil {
+		log.Printf("error exchanging OIDC code: %s", err.Error())
+		return c.JSON(http.StatusUnauthorized, "Could not complete login with the identity provider")
+	}
+
+	claims, err := h.Provider.Verify(idToken, state.Nonce)
+	if err != nil {
+		log.Printf("error verifying OIDC ID token: %s", err.Error())
+		return c.JSON(http.StatusUnauthorized, "Invalid ID token")
+	}
+
+	var user *models.User
+	var status int
+	if state.Link_user_id != 0 {
+		user, status, err = h.link(state.Link_user_id, claims)
+	} else {
+		user, status, err = h.user(claims)
+	}
+	if err != nil {
+		return c.JSON(status, err.Error())
+	}
+
+	token, err := issueToken(c, h.SessionRepo, user, "")
+	if err != nil {
+		log.Printf("failed to generate token: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"token": token,
+	})
+}
+
+// user finds the account linked to the ID token's subject, or creates one
+// for a new verified email. An existing account with the email is only
+// linked if it has neither a password nor another identity: local emails
+// aren't verified, so anyone could have registered with it.
+func (h *OIDCHandler) user(claims *oidc.Claims) (*models.User, int, error) {
+	user, err := h.UserRepo.FindByOidcSubject(claims.Subject)
+	if err != nil {
+		log.Printf("error finding user: %s", err.Error())
+		return nil, http.StatusInternalServerError, err
+	}
+	if user != nil {
+		return user, 0, nil
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, http.StatusForbidden, fmt.Errorf("the identity provider did not supply a verified email")
+	}
+
+	user, err = h.UserRepo.FindByEmail(claims.Email)
+	if err != nil {
+		log.Printf("error finding user: %s", err.Error())
+		return nil, http.StatusInternalServerError, err
+	}
+	if user != nil {
+		if user.Oidc_subject != nil || user.Password != "" {
+			return nil, http.StatusConflict, fmt.Errorf("an account with this email already exists, sign in to it and link the identity from there")
+		}
+		return h.setSubject(user, claims)
+	}
+
+	username, err := h.username(claims)
+	if err != nil {
+		log.Printf("error finding user: %s", err.Error())
+		return nil, http.StatusInternalServerError, err
+	}
+	user = &models.User{
+		Username:     username,
+		Email:        claims.Email,
+		Oidc_subject: &claims.Subject,
+	}
+	if err := h.UserRepo.Create(user); err != nil {
+		log.Printf("error creating user: %s", err.Error())
+		return nil, http.StatusInternalServerError, err
+	}
+	return user, 0, nil
+}
+
+// link links the identity to the account with the id, unless either is
+// already linked elsewhere.
+func (h *OIDCHandler) link(user_id uint, claims *oidc.Claims) (*models.User, int, error) {
+	linked, err := h.UserRepo.FindByOidcSubject(claims.Subject)
+	if err != nil {
+		log.Printf("error finding user: %s", err.Error())
+		return nil, http.StatusInternalServerError, err
+	}
+	if linked != nil && linked.ID != user_id {
+		return nil, http.StatusConflict, fmt.Errorf("this identity is linked to another account")
+	}
+
+	user, err := h.UserRepo.FindByID(user_id)
+	if err != nil {
+		log.Printf("error finding user: %s", err.Error())
+		return nil, http.StatusInternalServerError, err
+	}
+	if user.Oidc_subject != nil {
+		if *user.Oidc_subject != claims.Subject {
+			return nil, http.StatusConflict, fmt.Errorf("the account is linked to another identity")
+		}
+		return user, 0, nil
+	}
+	return h.setSubject(user, claims)
+}
+
+func (h *OIDCHandler) setSubject(user *models.User, claims *oidc.Claims) (*models.User, int, error) {
+	user.Oidc_subject = &claims.Subject
+	if err := h.UserRepo.Update(user); err != nil {
+		log.Printf("error linking user: %s", err.Error())
+		return nil, http.StatusInternalServerError, err
+	}
+	return user, 0, nil
+}
+
+// username derives a free username that passes registration's validation
+// from the preferred username or the email's local part.
+func (h *OIDCHandler) username(claims *oidc.Claims) (string, error) {
+	source := claims.PreferredUsername
+	if source == "" {
+		source, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	var base strings.Builder
+	for _, r := range source {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			base.WriteRune(r)
+		}
+	}
+	name := base.String()
+	if len(name) > 20 {
+		name = name[:20]
+	}
+	for len(name) < 3 {
+		name += "0"
+	}
+
+	candidate := name
+	for i := 1; ; i++ {
+		existing, err := h.UserRepo.FindByUsername(candidate)
+		if err != nil {
+			return "", err
+		}
+		if existing == nil {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s%d", name, i)
+	}
+}
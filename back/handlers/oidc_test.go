@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/oidc"
+	"github.com/raeinsoltani/gorello/back/oidc/oidctest"
+	"github.com/raeinsoltani/gorello/back/repository"
+	"github.com/raeinsoltani/gorello/back/utils"
+)
+
+const testRedirectURL = "http://gorello.test/auth/oidc/callback"
+
+// memoryUsers keeps users in memory for the methods the login uses.
+type memoryUsers struct {
+	repository.User
+	users []*models.User
+}
+
+func (r *memoryUsers) find(match func(*models.User) bool) (*models.User, error) {
+	for _, user := range r.users {
+		if match(user) {
+			return user, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *memoryUsers) Create(user *models.User) error {
+	user.ID = uint(len(r.users) + 1)
+	r.users = append(r.users, user)
+	return nil
+}
+
+func (r *memoryUsers) FindByID(id uint) (*models.User, error) {
+	return r.find(func(user *models.User) bool { return user.ID == id })
+}
+
+func (r *memoryUsers) FindByUsername(username string) (*models.User, error) {
+	return r.find(func(user *models.User) bool { return user.Username == username })
+}
+
+func (r *memoryUsers) FindByEmail(email string) (*models.User, error) {
+	return r.find(func(user *models.User) bool { return strings.EqualFold(user.Email, email) })
+}
+
+func (r *memoryUsers) FindByOidcSubject(subject string) (*models.User, error) {
+	return r.find(func(user *models.User) bool { return user.Oidc_subject != nil && *user.Oidc_subject == subject })
+}
+
+func (r *memoryUsers) Update(user *models.User) error {
+	user.Version++
+	return nil
+}
+
+// memorySessions records the sessions logins create.
+type memorySessions struct {
+	repository.Session
+	sessions []*models.Session
+}
+
+func (r *memorySessions) Create(session *models.Session) error {
+	session.ID = uint(len(r.sessions) + 1)
+	r.sessions = append(r.sessions, session)
+	return nil
+}
+
+// startLogin runs Login and follows the redirect through the issuer. It
+// returns the state cookie and the callback URL the issuer sent back to.
+func startLogin(t *testing.T, h *OIDCHandler) (*http.Cookie, *url.URL) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	if err := h.Login(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil), rec)); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login: got status %d, want %d", rec.Code, http.StatusFound)
+	}
+	return authorize(t, rec, rec.Header().Get(echo.HeaderLocation))
+}
+
+// startLink runs Link for the signed-in user like startLogin.
+func startLink(t *testing.T, h *OIDCHandler, username string) (*http.Cookie, *url.URL) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/users/"+username+"/oidc/link", nil), rec)
+	c.SetParamNames("username")
+	c.SetParamValues(username)
+	c.Set("username", username)
+	if err := h.Link(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("link: got status %d (%s), want %d", rec.Code, rec.Body.String(), http.StatusOK)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	return authorize(t, rec, body["url"])
+}
+
+// authorize takes the state cookie from the response that started a login
+// and sends the browser to the issuer.
+func authorize(t *testing.T, rec *httptest.ResponseRecorder, location string) (*http.Cookie, *url.URL) {
+	t.Helper()
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != oidcStateCookie {
+		t.Fatalf("got cookies %v, want the state cookie", cookies)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: got status %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cookies[0], callback
+}
+
+// tokenUser returns the user a successful callback logged in.
+func tokenUser(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("callback: got status %d (%s), want %d", rec.Code, rec.Body.String(), http.StatusOK)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	username, _, err := utils.ParseJWT(body["token"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return username
+}
+
+func finishLogin(t *testing.T, h *OIDCHandler, cookie *http.Cookie, callback *url.URL) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	if err := h.Callback(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func newTestOIDCHandler(t *testing.T) (*OIDCHandler, *oidctest.Issuer, *memoryUsers) {
+	t.Helper()
+
+	issuer, err := oidctest.NewIssuer("gorello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(issuer.Close)
+
+	users := &memoryUsers{}
+	provider := oidc.NewProvider(oidc.Config{
+		Issuer:      issuer.URL,
+		ClientID:    "gorello",
+		RedirectURL: testRedirectURL,
+		Scopes:      []string{"openid", "email", "profile"},
+	})
+	return NewOIDCHandler(users, &memorySessions{}, provider), issuer, users
+}
+
+func TestOIDCLoginProvisionsUser(t *testing.T) {
+	h, issuer, users := newTestOIDCHandler(t)
+	issuer.Claims = jwt.MapClaims{"sub": "subject-1", "email": "ada.lovelace@example.com", "email_verified": true}
+
+	cookie, callbackURL := startLogin(t, h)
+	if username := tokenUser(t, finishLogin(t, h, cookie, callbackURL)); username != "adalovelace" {
+		t.Errorf("token is for %q, want %q", username, "adalovelace")
+	}
+	if len(users.users) != 1 || users.users[0].Email != "ada.lovelace@example.com" {
+		t.Fatalf("got users %v, want one for the email", users.users)
+	}
+
+	// The next login finds the account by its subject.
+	cookie, callbackURL = startLogin(t, h)
+	if username := tokenUser(t, finishLogin(t, h, cookie, callbackURL)); username != "adalovelace" {
+		t.Errorf("token is for %q, want %q", username, "adalovelace")
+	}
+	if len(users.users) != 1 {
+		t.Errorf("got %d users, want the provisioned account only", len(users.users))
+	}
+}
+
+func TestOIDCLoginDoesNotLinkPasswordAccountByEmail(t *testing.T) {
+	h, issuer, users := newTestOIDCHandler(t)
+	// Anyone can register a password account with someone else's email.
+	users.Create(&models.User{Username: "mallory", Email: "ada@example.com", Password: "hashed-password"})
+	issuer.Claims = jwt.MapClaims{"sub": "subject-1", "email": "Ada@example.com", "email_verified": true}
+
+	cookie, callbackURL := startLogin(t, h)
+	if rec := finishLogin(t, h, cookie, callbackURL); rec.Code != http.StatusConflict {
+		t.Fatalf("callback: got status %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if users.users[0].Oidc_subject != nil {
+		t.Errorf("password account was linked by email")
+	}
+}
+
+func TestOIDCLinkLinksSignedInAccount(t *testing.T) {
+	h, issuer, users := newTestOIDCHandler(t)
+	users.Create(&models.User{Username: "ada", Email: "ada@example.com", Password: "hashed-password"})
+	issuer.Claims = jwt.MapClaims{"sub": "subject-1", "email": "ada@corp.example.com", "email_verified": true}
+
+	cookie, callbackURL := startLink(t, h, "ada")
+	if username := tokenUser(t, finishLogin(t, h, cookie, callbackURL)); username != "ada" {
+		t.Errorf("token is for %q, want %q", username, "ada")
+	}
+	if subject := users.users[0].Oidc_subject; subject == nil || *subject != "subject-1" {
+		t.Fatalf("account was not linked to the subject")
+	}
+
+	// Logins with the identity now reach the account.
+	cookie, callbackURL = startLogin(t, h)
+	if username := tokenUser(t, finishLogin(t, h, cookie, callbackURL)); username != "ada" {
+		t.Errorf("token is for %q, want %q", username, "ada")
+	}
+}
+
+func TestOIDCLinkRejectsIdentityOfAnotherAccount(t *testing.T) {
+	h, issuer, users := newTestOIDCHandler(t)
+	subject := "subject-1"
+	users.Create(&models.User{Username: "ada", Email: "ada@example.com", Oidc_subject: &subject})
+	users.Create(&models.User{Username: "mallory", Email: "mallory@example.com", Password: "hashed-password"})
+	issuer.Claims = jwt.MapClaims{"sub": subject, "email": "ada@example.com", "email_verified": true}
+
+	cookie, callbackURL := startLink(t, h, "mallory")
+	if rec := finishLogin(t, h, cookie, callbackURL); rec.Code != http.StatusConflict {
+		t.Fatalf("callback: got status %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if users.users[1].Oidc_subject != nil {
+		t.Errorf("identity was linked to a second account")
+	}
+}
+
+func TestOIDCLoginRejectsUnverifiedEmail(t *testing.T) {
+	h, issuer, users := newTestOIDCHandler(t)
+	users.Create(&models.User{Username: "ada", Email: "ada@example.com"})
+	issuer.Claims = jwt.MapClaims{"sub": "subject-1", "email": "ada@example.com", "email_verified": false}
+
+	cookie, callbackURL := startLogin(t, h)
+	if rec := finishLogin(t, h, cookie, callbackURL); rec.Code != http.StatusForbidden {
+		t.Fatalf("callback: got status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if users.users[0].Oidc_subject != nil {
+		t.Errorf("account was linked without a verified email")
+	}
+}
+
+func TestOIDCLoginChecksPKCEVerifier(t *testing.T) {
+	h, issuer, _ := newTestOIDCHandler(t)
+	issuer.Claims = jwt.MapClaims{"sub": "subject-1", "email": "ada@example.com", "email_verified": true}
+
+	// A state cookie for the same login, but with another verifier, as an
+	// attacker holding a stolen code would have.
+	_, callbackURL := startLogin(t, h)
+	forged, err := utils.GenerateOIDCStateJWT(utils.OIDCState{
+		State:    callbackURL.Query().Get("state"),
+		Nonce:    "nonce",
+		Verifier: "another-verifier",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie := &http.Cookie{Name: oidcStateCookie, Value: forged}
+	if rec := finishLogin(t, h, cookie, callbackURL); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("callback: got status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestOIDCLoginChecksState(t *testing.T) {
+	h, issuer, _ := newTestOIDCHandler(t)
+	issuer.Claims = jwt.MapClaims{"sub": "subject-1", "email": "ada@example.com", "email_verified": true}
+
+	cookie, callbackURL := startLogin(t, h)
+	query := callbackURL.Query()
+	query.Set("state", "another-state")
+	callbackURL.RawQuery = query.Encode()
+	if rec := finishLogin(t, h, cookie, callbackURL); rec.Code != http.StatusBadRequest {
+		t.Fatalf("callback: got status %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+	"gorm.io/gorm"
+)
+
+type OrganizationHandler struct {
+	OrganizationRepo      repository.Organization
+	WorkspaceRepo         repository.Workspace
+	UserWorkspaceRoleRepo repository.UserWorkspaceRole
+	UserRepo              repository.User
+}
+
+func NewOrganizationHandler(organizationRepo repository.Organization, workspaceRepo repository.Workspace, userWorkspaceRoleRepo repository.UserWorkspaceRole, userRepo repository.User) *OrganizationHandler {
+	return &OrganizationHandler{
+		OrganizationRepo:      organizationRepo,
+		WorkspaceRepo:         workspaceRepo,
+		UserWorkspaceRoleRepo: userWorkspaceRoleRepo,
+		UserRepo:              userRepo,
+	}
+}
+
+type OrganizationCreateDTO struct {
+	Name       string `json:"name" validate:"required,max=100"`
+	Seat_limit uint   `json:"seat_limit"`
+}
+
+type OrganizationMemberDTO struct {
+	PublicUserDTO
+	Role uint `json:"role"`
+}
+
+type OrganizationMemberUpdateDTO struct {
+	Role *uint `json:"role" validate:"required,oneof=0 1"`
+}
+
+// organizationMember loads the organization in the path and the caller's
+// membership in it, which must be an admin one if admin is set. On failure
+// the response has already been written and the returned member is nil.
+func organizationMember(c echo.Context, organizationRepo repository.Organization, userRepo repository.User, admin bool) (*models.Organization, *models.OrganizationMember, error) {
+	authUsername, ok := c.Get("username").(string)
+	if !ok {
+		return nil, nil, c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	organizationId, err := strconv.ParseUint(c.Param("organizationId"), 10, 64)
+	if err != nil {
+		return nil, nil, c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	user, err := userRepo.FindByUsername(authUsername)
+	if err != nil {
+		return nil, nil, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	organization, err := organizationRepo.FindByID(uint(organizationId))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, c.JSON(http.StatusNotFound, "Organization not found")
+	}
+	if err != nil {
+		return nil, nil, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	member, err := organizationRepo.FindMember(organization.ID, user.ID)
+	if err != nil {
+		return nil, nil, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if member == nil {
+		return nil, nil, c.JSON(http.StatusNotFound, "Organization not found")
+	}
+	if admin && member.Role != 1 {
+		return nil, nil, c.JSON(http.StatusForbidden, "Only organization admins can do this")
+	}
+	return organization, member, nil
+}
+
+// organizationError writes the response for a failed membership change.
+func organizationError(c echo.Context, err error) error {
+	if errors.Is(err, repository.ErrLastAdmin) {
+		return c.JSON(http.StatusConflict, "The organization must keep at least one admin, make another member an admin first")
+	}
+	if errors.Is(err, repository.ErrSeatLimit) {
+		return c.JSON(http.StatusConflict, err.Error())
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, "Member not found")
+	}
+	return c.JSON(http.StatusInternalServerError, err.Error())
+}
+
+func (h *OrganizationHandler) CreateOrganization(c echo.Context) error {
+	authUsername, ok := c.Get("username").(string)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	organizationCreateDTO := new(OrganizationCreateDTO)
+	if err := c.Bind(organizationCreateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(organizationCreateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	user, err := h.UserRepo.FindByUsername(authUsername)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	organization := models.Organization{
+		Name:       organizationCreateDTO.Name,
+		Seat_limit: organizationCreateDTO.Seat_limit,
+	}
+	if err := h.OrganizationRepo.Create(&organization, user.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusCreated, organization)
+}
+
+func (h *OrganizationHandler) GetOrganizations(c echo.Context) error {
+	authUsername, ok := c.Get("username").(string)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	user, err := h.UserRepo.FindByUsername(authUsername)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	organizations, err := h.OrganizationRepo.FindByUserID(user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, organizations)
+}
+
+func (h *OrganizationHandler) GetOrganization(c echo.Context) error {
+	organization, member, err := organizationMember(c, h.OrganizationRepo, h.UserRepo, false)
+	if member == nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, organization)
+}
+
+func (h *OrganizationHandler) UpdateOrganization(c echo.Context) error {
+	organization, member, err := organizationMember(c, h.OrganizationRepo, h.UserRepo, true)
+	if member == nil {
+		return err
+	}
+
+	organizationUpdateDTO := new(OrganizationCreateDTO)
+	if err := c.Bind(organizationUpdateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(organizationUpdateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	organization.Name = organizationUpdateDTO.Name
+	organization.Seat_limit = organizationUpdateDTO.Seat_limit
+	err = h.OrganizationRepo.Update(organization)
+	if errors.Is(err, repository.ErrSeatLimit) {
+		return c.JSON(http.StatusConflict, "The seat limit is below the number of members")
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, organization)
+}
+
+// DeleteOrganization removes the organization. Its workspaces are kept
+// and stay with their own members.
+func (h *OrganizationHandler) DeleteOrganization(c echo.Context) error {
+	organization, member, err := organizationMember(c, h.OrganizationRepo, h.UserRepo, true)
+	if member == nil {
+		return err
+	}
+
+	if err := h.OrganizationRepo.Delete(organization.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *OrganizationHandler) GetMembers(c echo.Context) error {
+	organization, member, err := organizationMember(c, h.OrganizationRepo, h.UserRepo, false)
+	if member == nil {
+		return err
+	}
+
+	members, err := h.OrganizationRepo.FindMembers(organization.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	memberDTOs := make([]OrganizationMemberDTO, 0, len(members))
+	for _, member := range members {
+		user, err := h.UserRepo.FindByID(member.User_id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		memberDTOs = append(memberDTOs, OrganizationMemberDTO{PublicUserDTO: newPublicUserDTO(user), Role: member.Role})
+	}
+
+	return c.JSON(http.StatusOK, memberDTOs)
+}
+
+// SetMember adds the user named in the path to the organization, or
+// changes their role, as long as a seat is free.
+func (h *OrganizationHandler) SetMember(c echo.Context) error {
+	organization, member, err := organizationMember(c, h.OrganizationRepo, h.UserRepo, true)
+	if member == nil {
+		return err
+	}
+
+	organizationMemberUpdateDTO := new(OrganizationMemberUpdateDTO)
+	if err := c.Bind(organizationMemberUpdateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(organizationMemberUpdateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	user, err := h.UserRepo.FindByUsername(c.Param("username"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if user == nil {
+		return c.JSON(http.StatusNotFound, "User not found")
+	}
+
+	if err := h.OrganizationRepo.SetMember(organization.ID, user.ID, *organizationMemberUpdateDTO.Role); err != nil {
+		return organizationError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, OrganizationMemberDTO{PublicUserDTO: newPublicUserDTO(user), Role: *organizationMemberUpdateDTO.Role})
+}
+
+// RemoveMember removes a member. Admins may remove anyone; other members
+// may only leave.
+func (h *OrganizationHandler) RemoveMember(c echo.Context) error {
+	organization, member, err := organizationMember(c, h.OrganizationRepo, h.UserRepo, false)
+	if member == nil {
+		return err
+	}
+
+	user, err := h.UserRepo.FindByUsername(c.Param("username"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if user == nil {
+		return c.JSON(http.StatusNotFound, "Member not found")
+	}
+	if member.Role != 1 && user.ID != member.User_id {
+		return c.JSON(http.StatusForbidden, "Only organization admins can remove other members")
+	}
+
+	if err := h.OrganizationRepo.RemoveMember(organization.ID, user.ID); err != nil {
+		return organizationError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetWorkspaces lists the organization's workspaces that the caller can
+// access, which for admins is all of them.
+func (h *OrganizationHandler) GetWorkspaces(c echo.Context) error {
+	organization, member, err := organizationMember(c, h.OrganizationRepo, h.UserRepo, false)
+	if member == nil {
+		return err
+	}
+
+	workspaces, err := h.OrganizationRepo.FindWorkspaces(organization.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	roles, err := h.UserWorkspaceRoleRepo.FindEffectiveByUserID(member.User_id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	accessible := make(map[uint]bool)
+	for _, role := range roles {
+		accessible[role.Workspace_id] = true
+	}
+
+	visible := make([]*models.Workspace, 0, len(workspaces))
+	for _, workspace := range workspaces {
+		if accessible[workspace.ID] {
+			visible = append(visible, workspace)
+		}
+	}
+
+	return c.JSON(http.StatusOK, visible)
+}
+
+// workspace loads the workspace in the path, which the caller must own;
+// admins own every workspace of their organization. On failure the
+// response has already been written and the returned workspace is nil.
+func (h *OrganizationHandler) workspace(c echo.Context) (*models.Workspace, error) {
+	workspaceId, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
+	if err != nil {
+		return nil, c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	workspace, err := h.WorkspaceRepo.FindByID(uint(workspaceId))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, c.JSON(http.StatusNotFound, "Workspace not found")
+	}
+	if err != nil {
+		return nil, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	authUsername, _ := c.Get("username").(string)
+	role, err := workspaceRole(h.UserRepo, h.UserWorkspaceRoleRepo, authUsername, workspace.ID)
+	if err != nil {
+		return nil, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if role == nil || role.Role != 1 {
+		return nil, c.JSON(http.StatusForbidden, "Only owners of the workspace can move it")
+	}
+	return workspace, nil
+}
+
+// AddWorkspace moves a workspace the caller owns into the organization.
+func (h *OrganizationHandler) AddWorkspace(c echo.Context) error {
+	organization, member, err := organizationMember(c, h.OrganizationRepo, h.UserRepo, true)
+	if member == nil {
+		return err
+	}
+
+	workspace, err := h.workspace(c)
+	if workspace == nil {
+		return err
+	}
+	if workspace.Organization_id != nil {
+		return c.JSON(http.StatusConflict, "Workspace already belongs to an organization")
+	}
+
+	workspace.Organization_id = &organization.ID
+	err = h.WorkspaceRepo.Update(workspace)
+	if errors.Is(err, repository.ErrStaleVersion) {
+		return c.JSON(http.StatusPreconditionFailed, "Workspace was modified by another request")
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	setETag(c, workspace.Version)
+	return c.JSON(http.StatusOK, workspace)
+}
+
+// RemoveWorkspace takes a workspace out of the organization. Roles its
+// teams had there stop applying.
+func (h *OrganizationHandler) RemoveWorkspace(c echo.Context) error {
+	organization, member, err := organizationMember(c, h.OrganizationRepo, h.UserRepo, true)
+	if member == nil {
+		return err
+	}
+
+	workspace, err := h.workspace(c)
+	if workspace == nil {
+		return err
+	}
+	if workspace.Organization_id == nil || *workspace.Organization_id != organization.ID {
+		return c.JSON(http.StatusNotFound, "Workspace not found in the organization")
+	}
+
+	workspace.Organization_id = nil
+	if workspace.Visibility == models.WorkspaceVisibilityOrganization {
+		workspace.Visibility = models.WorkspaceVisibilityPrivate
+	}
+	err = h.WorkspaceRepo.Update(workspace)
+	if errors.Is(err, repository.ErrStaleVersion) {
+		return c.JSON(http.StatusPreconditionFailed, "Workspace was modified by another request")
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,319 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+	"gorm.io/gorm"
+)
+
+const maxAvatarSize = 1 << 20
+
+var avatarTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// UserProfileDTO is a user's own view of their account.
+type UserProfileDTO struct {
+	ID           uint                   `json:"id"`
+	Username     string                 `json:"username"`
+	Email        string                 `json:"email"`
+	Display_name string                 `json:"display_name"`
+	Avatar_url   string                 `json:"avatar_url"`
+	Timezone     string                 `json:"timezone"`
+	Locale       string                 `json:"locale"`
+	Bio          string                 `json:"bio"`
+	Preferences  models.UserPreferences `json:"preferences"`
+	Totp_enabled bool                   `json:"totp_enabled"`
+	Created_at   time.Time              `json:"created_at"`
+}
+
+// PublicUserDTO is what other users may see of a user.
+type PublicUserDTO struct {
+	ID           uint   `json:"id"`
+	Username     string `json:"username"`
+	Display_name string `json:"display_name"`
+	Avatar_url   string `json:"avatar_url"`
+	Bio          string `json:"bio"`
+}
+
+func newUserProfileDTO(user *models.User) UserProfileDTO {
+	return UserProfileDTO{
+		ID:           user.ID,
+		Username:     user.Username,
+		Email:        user.Email,
+		Display_name: user.Display_name,
+		Avatar_url:   user.Avatar_url,
+		Timezone:     user.Timezone,
+		Locale:       user.Locale,
+		Bio:          user.Bio,
+		Preferences:  user.Preferences,
+		Totp_enabled: user.Totp_enabled,
+		Created_at:   user.CreatedAt,
+	}
+}
+
+func newPublicUserDTO(user *models.User) PublicUserDTO {
+	return PublicUserDTO{
+		ID:           user.ID,
+		Username:     user.Username,
+		Display_name: user.Display_name,
+		Avatar_url:   user.Avatar_url,
+		Bio:          user.Bio,
+	}
+}
+
+type ProfileUpdateDTO struct {
+	Display_name string `json:"display_name" validate:"max=100"`
+	Timezone     string `json:"timezone" validate:"omitempty,timezone"`
+	Locale       string `json:"locale" validate:"omitempty,bcp47_language_tag"`
+	Bio          string `json:"bio" validate:"max=500"`
+}
+
+type PreferencesUpdateDTO struct {
+	Theme                string                         `json:"theme" validate:"omitempty,oneof=light dark system"`
+	Default_workspace_id uint                           `json:"default_workspace_id"`
+	Notifications        models.NotificationPreferences `json:"notifications"`
+}
+
+type ProfileHandler struct {
+	UserRepo              repository.User
+	AvatarRepo            repository.Avatar
+	UserWorkspaceRoleRepo repository.UserWorkspaceRole
+}
+
+func NewProfileHandler(userRepo repository.User, avatarRepo repository.Avatar, userWorkspaceRoleRepo repository.UserWorkspaceRole) *ProfileHandler {
+	return &ProfileHandler{
+		UserRepo:              userRepo,
+		AvatarRepo:            avatarRepo,
+		UserWorkspaceRoleRepo: userWorkspaceRoleRepo,
+	}
+}
+
+// self loads the user named in the path after checking that it is the
+// caller. Only reads are allowed with an access token. On failure the
+// response has already been written and the returned user is nil.
+func (h *ProfileHandler) self(c echo.Context) (*models.User, error) {
+	username := c.Param("username")
+	authUsername := c.Get("username")
+	if authUsername != username {
+		return nil, c.JSON(http.StatusForbidden, "Access denied")
+	}
+	if c.Request().Method != http.MethodGet && c.Get("token_id") != nil {
+		return nil, c.JSON(http.StatusForbidden, "Profiles cannot be changed with an access token")
+	}
+
+	user, err := h.UserRepo.FindByUsername(username)
+	if err != nil {
+		log.Printf("error finding user: %s", err.Error())
+		return nil, c.NoContent(http.StatusInternalServerError)
+	}
+	if user == nil {
+		return nil, c.JSON(http.StatusNotFound, "User not found")
+	}
+	return user, nil
+}
+
+// update saves the user and writes the profile, or the error.
+func (h *ProfileHandler) update(c echo.Context, user *models.User) error {
+	err := h.UserRepo.Update(user)
+	if errors.Is(err, repository.ErrStaleVersion) {
+		return c.JSON(http.StatusPreconditionFailed, "User was modified by another request")
+	}
+	if err != nil {
+		log.Printf("error updating user: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	setETag(c, user.Version)
+	return c.JSON(http.StatusOK, newUserProfileDTO(user))
+}
+
+// GetProfile returns the public profile of any user.
+func (h *ProfileHandler) GetProfile(c echo.Context) error {
+	user, err := h.UserRepo.FindByUsername(c.Param("username"))
+	if err != nil {
+		log.Printf("error finding user: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if user == nil {
+		return c.JSON(http.StatusNotFound, "User not found")
+	}
+
+	return c.JSON(http.StatusOK, newPublicUserDTO(user))
+}
+
+func (h *ProfileHandler) UpdateProfile(c echo.Context) error {
+	user, err := h.self(c)
+	if user == nil {
+		return err
+	}
+
+	profileUpdateDTO := new(ProfileUpdateDTO)
+	if err := c.Bind(profileUpdateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(profileUpdateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if !ifMatch(c, user.Version) {
+		return c.JSON(http.StatusPreconditionFailed, "User was modified by another request")
+	}
+
+	user.Display_name = profileUpdateDTO.Display_name
+	user.Bio = profileUpdateDTO.Bio
+	if profileUpdateDTO.Timezone != "" {
+		user.Timezone = profileUpdateDTO.Timezone
+	}
+	if profileUpdateDTO.Locale != "" {
+		user.Locale = profileUpdateDTO.Locale
+	}
+
+	return h.update(c, user)
+}
+
+func (h *ProfileHandler) GetPreferences(c echo.Context) error {
+	user, err := h.self(c)
+	if user == nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, user.Preferences)
+}
+
+func (h *ProfileHandler) UpdatePreferences(c echo.Context) error {
+	user, err := h.self(c)
+	if user == nil {
+		return err
+	}
+
+	preferencesUpdateDTO := new(PreferencesUpdateDTO)
+	if err := c.Bind(preferencesUpdateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(preferencesUpdateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if id := preferencesUpdateDTO.Default_workspace_id; id != 0 {
+		role, err := workspaceRole(h.UserRepo, h.UserWorkspaceRoleRepo, user.Username, id)
+		if err != nil {
+			log.Printf("error finding workspace role: %s", err.Error())
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		if role == nil {
+			return c.JSON(http.StatusBadRequest, "Default workspace must be one of your workspaces")
+		}
+	}
+
+	if !ifMatch(c, user.Version) {
+		return c.JSON(http.StatusPreconditionFailed, "User was modified by another request")
+	}
+
+	user.Preferences = models.UserPreferences{
+		Theme:                preferencesUpdateDTO.Theme,
+		Default_workspace_id: preferencesUpdateDTO.Default_workspace_id,
+		Notifications:        preferencesUpdateDTO.Notifications,
+	}
+
+	return h.update(c, user)
+}
+
+// UploadAvatar takes a PNG, JPEG, GIF or WebP image of up to 1 MB as the
+// "avatar" form field.
+func (h *ProfileHandler) UploadAvatar(c echo.Context) error {
+	user, err := h.self(c)
+	if user == nil {
+		return err
+	}
+
+	file, err := c.FormFile("avatar")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, "avatar file is required")
+	}
+	if file.Size > maxAvatarSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, "Avatar must be at most 1 MB")
+	}
+
+	opened, err := file.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	defer opened.Close()
+
+	data, err := io.ReadAll(io.LimitReader(opened, maxAvatarSize+1))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if len(data) > maxAvatarSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, "Avatar must be at most 1 MB")
+	}
+
+	contentType := http.DetectContentType(data)
+	if !avatarTypes[contentType] {
+		return c.JSON(http.StatusUnsupportedMediaType, "Avatar must be a PNG, JPEG, GIF or WebP image")
+	}
+
+	now := time.Now()
+	avatar := models.Avatar{User_id: user.ID, Content_type: contentType, Data: data, Updated_at: now}
+	if err := h.AvatarRepo.Save(&avatar); err != nil {
+		log.Printf("error saving avatar: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	// The timestamp changes the URL with every upload, so the avatar can be
+	// cached for a long time.
+	user.Avatar_url = fmt.Sprintf("/avatars/%d?v=%d", user.ID, now.Unix())
+	return h.update(c, user)
+}
+
+func (h *ProfileHandler) DeleteAvatar(c echo.Context) error {
+	user, err := h.self(c)
+	if user == nil {
+		return err
+	}
+
+	if err := h.AvatarRepo.Delete(user.ID); err != nil {
+		log.Printf("error deleting avatar: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	user.Avatar_url = ""
+	return h.update(c, user)
+}
+
+// GetAvatar serves an avatar image. It needs no authentication so that it
+// works in <img> tags.
+func (h *ProfileHandler) GetAvatar(c echo.Context) error {
+	userId, err := strconv.ParseUint(c.Param("userId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	avatar, err := h.AvatarRepo.FindByUserID(uint(userId))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.NoContent(http.StatusNotFound)
+	}
+	if err != nil {
+		log.Printf("error fetching avatar: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	c.Response().Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	c.Response().Header().Set("X-Content-Type-Options", "nosniff")
+	return c.Blob(http.StatusOK, avatar.Content_type, avatar.Data)
+}
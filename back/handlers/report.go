@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/repository"
+)
+
+type ReportHandler struct {
+	ReportRepo            repository.Report
+	UserWorkspaceRoleRepo repository.UserWorkspaceRole
+	UserRepo              repository.User
+}
+
+func NewReportHandler(reportRepo repository.Report, userWorkspaceRoleRepo repository.UserWorkspaceRole, userRepo repository.User) *ReportHandler {
+	return &ReportHandler{
+		ReportRepo:            reportRepo,
+		UserWorkspaceRoleRepo: userWorkspaceRoleRepo,
+		UserRepo:              userRepo,
+	}
+}
+
+// workspace parses the workspace id and checks that the caller is a member.
+// On failure the response has already been written and ok is false.
+func (h *ReportHandler) workspace(c echo.Context) (workspaceId uint, ok bool, err error) {
+	authUsername, isString := c.Get("username").(string)
+	if !isString {
+		return 0, false, c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	id, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
+	if err != nil {
+		return 0, false, c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	role, err := workspaceRole(h.UserRepo, h.UserWorkspaceRoleRepo, authUsername, uint(id))
+	if err != nil {
+		return 0, false, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if role == nil {
+		return 0, false, c.JSON(http.StatusForbidden, "Access denied to the workspace")
+	}
+	return uint(id), true, nil
+}
+
+// since reads the "weeks" query parameter (default 12) as a start time.
+func since(c echo.Context) time.Time {
+	weeks, err := strconv.Atoi(c.QueryParam("weeks"))
+	if err != nil || weeks <= 0 {
+		weeks = 12
+	}
+	return time.Now().AddDate(0, 0, -7*weeks)
+}
+
+func (h *ReportHandler) GetTaskCounts(c echo.Context) error {
+	workspaceId, ok, err := h.workspace(c)
+	if !ok {
+		return err
+	}
+
+	counts, err := h.ReportRepo.TaskCounts(workspaceId)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, counts)
+}
+
+func (h *ReportHandler) GetThroughput(c echo.Context) error {
+	workspaceId, ok, err := h.workspace(c)
+	if !ok {
+		return err
+	}
+
+	throughput, err := h.ReportRepo.Throughput(workspaceId, since(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, throughput)
+}
+
+func (h *ReportHandler) GetCycleTime(c echo.Context) error {
+	workspaceId, ok, err := h.workspace(c)
+	if !ok {
+		return err
+	}
+
+	cycleTime, err := h.ReportRepo.CycleTime(workspaceId, since(c))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, cycleTime)
+}
+
+func (h *ReportHandler) GetTimeTracking(c echo.Context) error {
+	workspaceId, ok, err := h.workspace(c)
+	if !ok {
+		return err
+	}
+
+	timeTracking, err := h.ReportRepo.TimeTracking(workspaceId)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, timeTracking)
+}
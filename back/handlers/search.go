@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/repository"
+)
+
+type SearchHandler struct {
+	SearchRepo repository.Search
+	UserRepo   repository.User
+}
+
+func NewSearchHandler(searchRepo repository.Search, userRepo repository.User) *SearchHandler {
+	return &SearchHandler{
+		SearchRepo: searchRepo,
+		UserRepo:   userRepo,
+	}
+}
+
+// Search runs a full-text search over the tasks, subtasks and workspaces the
+// caller can access. Besides free text, q understands the filters
+// assignee:<username|none>, status:<name|category|n>, priority:<n>,
+// workspace:<id>, type:<task|subtask|workspace> and due:<date, due:>date or
+// due:date.
+func (h *SearchHandler) Search(c echo.Context) error {
+	authUsername, ok := c.Get("username").(string)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	q := strings.TrimSpace(c.QueryParam("q"))
+	if q == "" {
+		return c.JSON(http.StatusBadRequest, "q is required")
+	}
+
+	user, err := h.UserRepo.FindByUsername(authUsername)
+	if err != nil {
+		log.Printf("error finding user: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	limit, err := strconv.Atoi(c.QueryParam("limit"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 50
+	}
+
+	query := repository.SearchQuery{User_id: user.ID, Limit: limit}
+	var words []string
+	for _, token := range strings.Fields(q) {
+		key, value, found := strings.Cut(token, ":")
+		if !found || value == "" {
+			words = append(words, token)
+			continue
+		}
+
+		switch strings.ToLower(key) {
+		case "assignee":
+			var id uint
+			if value != "none" {
+				assignee, err := h.UserRepo.FindByUsername(value)
+				if err != nil {
+					log.Printf("error finding user: %s", err.Error())
+					return c.NoContent(http.StatusInternalServerError)
+				}
+				if assignee == nil {
+					return c.JSON(http.StatusOK, []*repository.SearchResultDTO{})
+				}
+				id = assignee.ID
+			}
+			query.Assignee_id = &id
+		case "status", "priority", "workspace":
+			parsed, err := strconv.ParseUint(value, 10, 64)
+			if err != nil && strings.ToLower(key) == "status" {
+				// Statuses are named per workspace, so names are resolved
+				// against each task's workflow.
+				query.Status_name = value
+				continue
+			}
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, fmt.Sprintf("invalid %s filter %q", key, value))
+			}
+			v := uint(parsed)
+			switch strings.ToLower(key) {
+			case "status":
+				query.Status = &v
+			case "priority":
+				query.Priority = &v
+			case "workspace":
+				query.Workspace_id = &v
+			}
+		case "type":
+			if value != "task" && value != "subtask" && value != "workspace" {
+				return c.JSON(http.StatusBadRequest, fmt.Sprintf("invalid type filter %q", value))
+			}
+			query.Types = append(query.Types, value)
+		case "due":
+			switch {
+			case strings.HasPrefix(value, "<"):
+				query.DueBefore = value[1:]
+			case strings.HasPrefix(value, ">"):
+				query.DueAfter = value[1:]
+			default:
+				query.DueOn = value
+			}
+		default:
+			words = append(words, token)
+		}
+	}
+	query.Text = strings.Join(words, " ")
+
+	results, err := h.SearchRepo.Search(query)
+	if err != nil {
+		log.Printf("error searching: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if results == nil {
+		results = []*repository.SearchResultDTO{}
+	}
+
+	return c.JSON(http.StatusOK, results)
+}
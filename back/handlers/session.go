@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+	"github.com/raeinsoltani/gorello/back/utils"
+	"gorm.io/gorm"
+)
+
+// issueToken starts a session for the user and returns its JWT. The device
+// is the name the client gave, if any, or a guess from its user agent.
+func issueToken(c echo.Context, sessionRepo repository.Session, user *models.User, device string) (string, error) {
+	userAgent := c.Request().UserAgent()
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
+	if device == "" {
+		device = deviceName(userAgent)
+	}
+	if len(device) > 100 {
+		device = device[:100]
+	}
+
+	now := time.Now()
+	session := models.Session{
+		User_id:      user.ID,
+		Device:       device,
+		User_agent:   userAgent,
+		Ip:           c.RealIP(),
+		Last_seen_at: now,
+		Expires_at:   now.Add(utils.JWTLifetime),
+	}
+	if err := sessionRepo.Create(&session); err != nil {
+		return "", err
+	}
+
+	return utils.GenerateJWT(user.Username, session.ID)
+}
+
+// deviceName makes a rough "Browser on OS" label from a user agent.
+func deviceName(userAgent string) string {
+	browser := "Unknown client"
+	for _, candidate := range []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+		{"Go-http-client", "Go client"},
+		{"python-requests", "Python client"},
+	} {
+		if strings.Contains(userAgent, candidate.token) {
+			browser = candidate.name
+			break
+		}
+	}
+
+	for _, candidate := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(userAgent, candidate.token) {
+			return browser + " on " + candidate.name
+		}
+	}
+	return browser
+}
+
+type SessionHandler struct {
+	SessionRepo repository.Session
+	UserRepo    repository.User
+}
+
+func NewSessionHandler(sessionRepo repository.Session, userRepo repository.User) *SessionHandler {
+	return &SessionHandler{
+		SessionRepo: sessionRepo,
+		UserRepo:    userRepo,
+	}
+}
+
+type SessionDTO struct {
+	*models.Session
+	Current bool `json:"current"`
+}
+
+// user loads the user named in the path after checking that it is the
+// caller, logged in rather than using an access token. On failure the
+// response has already been written and the returned user is nil.
+func (h *SessionHandler) user(c echo.Context) (*models.User, error) {
+	username := c.Param("username")
+	authUsername := c.Get("username")
+	if authUsername != username {
+		return nil, c.JSON(http.StatusForbidden, "Access denied")
+	}
+	if c.Get("token_id") != nil {
+		return nil, c.JSON(http.StatusForbidden, "Sessions cannot be managed with an access token")
+	}
+
+	user, err := h.UserRepo.FindByUsername(username)
+	if err != nil {
+		log.Printf("error finding user: %s", err.Error())
+		return nil, c.NoContent(http.StatusInternalServerError)
+	}
+	if user == nil {
+		return nil, c.JSON(http.StatusNotFound, "User not found")
+	}
+	return user, nil
+}
+
+func (h *SessionHandler) GetSessions(c echo.Context) error {
+	user, err := h.user(c)
+	if user == nil {
+		return err
+	}
+
+	sessions, err := h.SessionRepo.FindActiveByUserID(user.ID)
+	if err != nil {
+		log.Printf("error fetching sessions: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	current, _ := c.Get("session_id").(uint)
+	sessionDTOs := make([]SessionDTO, len(sessions))
+	for i, session := range sessions {
+		sessionDTOs[i] = SessionDTO{Session: session, Current: session.ID == current}
+	}
+
+	return c.JSON(http.StatusOK, sessionDTOs)
+}
+
+func (h *SessionHandler) RevokeSession(c echo.Context) error {
+	user, err := h.user(c)
+	if user == nil {
+		return err
+	}
+
+	sessionId, err := strconv.ParseUint(c.Param("sessionId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	session, err := h.SessionRepo.FindByID(uint(sessionId))
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && session.User_id != user.ID) {
+		return c.JSON(http.StatusNotFound, "Session not found")
+	}
+	if err != nil {
+		log.Printf("error fetching session: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if err := h.SessionRepo.Revoke(session.ID); err != nil {
+		log.Printf("error revoking session: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// RevokeOtherSessions logs the user out everywhere but the current session.
+func (h *SessionHandler) RevokeOtherSessions(c echo.Context) error {
+	user, err := h.user(c)
+	if user == nil {
+		return err
+	}
+
+	current, _ := c.Get("session_id").(uint)
+	if err := h.SessionRepo.RevokeAllExcept(user.ID, current); err != nil {
+		log.Printf("error revoking sessions: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
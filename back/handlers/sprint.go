@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+	"gorm.io/gorm"
+)
+
+type SprintHandler struct {
+	SprintRepo            repository.Sprint
+	TaskRepo              repository.Task
+	UserWorkspaceRoleRepo repository.UserWorkspaceRole
+	UserRepo              repository.User
+}
+
+func NewSprintHandler(sprintRepo repository.Sprint, taskRepo repository.Task, userWorkspaceRoleRepo repository.UserWorkspaceRole, userRepo repository.User) *SprintHandler {
+	return &SprintHandler{
+		SprintRepo:            sprintRepo,
+		TaskRepo:              taskRepo,
+		UserWorkspaceRoleRepo: userWorkspaceRoleRepo,
+		UserRepo:              userRepo,
+	}
+}
+
+type SprintCreateDTO struct {
+	Name       string `json:"name" validate:"required,max=100"`
+	Goal       string `json:"goal" validate:"max=255"`
+	Start_date string `json:"start_date" validate:"required,datetime=2006-01-02"`
+	End_date   string `json:"end_date" validate:"required,datetime=2006-01-02"`
+}
+
+type SprintCompleteDTO struct {
+	Rollover_sprint_id uint `json:"rollover_sprint_id"`
+}
+
+type SprintTasksDTO struct {
+	Task_ids []uint `json:"task_ids" validate:"required,min=1"`
+}
+
+type SprintDetailDTO struct {
+	*models.Sprint
+	Tasks []*models.Task `json:"tasks"`
+}
+
+func (s *SprintCreateDTO) dates() (time.Time, time.Time, error) {
+	start, err := time.Parse("2006-01-02", s.Start_date)
+	if err != nil {
+		return start, start, err
+	}
+	end, err := time.Parse("2006-01-02", s.End_date)
+	if err != nil {
+		return start, end, err
+	}
+	if end.Before(start) {
+		return start, end, errors.New("end_date must not be before start_date")
+	}
+	return start, end, nil
+}
+
+// sprint loads the sprint named in the path after checking that the caller
+// is a member of its workspace. On failure the response has already been
+// written and the returned sprint is nil.
+func (h *SprintHandler) sprint(c echo.Context) (*models.Sprint, error) {
+	authUsername, ok := c.Get("username").(string)
+	if !ok {
+		return nil, c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	workspaceId, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
+	if err != nil {
+		return nil, c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	role, err := workspaceRole(h.UserRepo, h.UserWorkspaceRoleRepo, authUsername, uint(workspaceId))
+	if err != nil {
+		return nil, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if role == nil {
+		return nil, c.JSON(http.StatusForbidden, "Access denied to the workspace")
+	}
+
+	if c.Param("sprintId") == "" {
+		return &models.Sprint{Workspace_id: uint(workspaceId)}, nil
+	}
+
+	sprintId, err := strconv.ParseUint(c.Param("sprintId"), 10, 64)
+	if err != nil {
+		return nil, c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	sprint, err := h.SprintRepo.FindByID(uint(sprintId))
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && sprint.Workspace_id != uint(workspaceId)) {
+		return nil, c.JSON(http.StatusNotFound, "Sprint not found")
+	}
+	if err != nil {
+		return nil, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	return sprint, nil
+}
+
+func (h *SprintHandler) GetSprints(c echo.Context) error {
+	workspace, err := h.sprint(c)
+	if workspace == nil {
+		return err
+	}
+
+	sprints, err := h.SprintRepo.FindByWorkspaceID(workspace.Workspace_id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, sprints)
+}
+
+func (h *SprintHandler) CreateSprint(c echo.Context) error {
+	sprint, err := h.sprint(c)
+	if sprint == nil {
+		return err
+	}
+
+	sprintCreateDTO := new(SprintCreateDTO)
+	if err := c.Bind(sprintCreateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(sprintCreateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	start, end, err := sprintCreateDTO.dates()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	sprint.Name = sprintCreateDTO.Name
+	sprint.Goal = sprintCreateDTO.Goal
+	sprint.Start_date = start
+	sprint.End_date = end
+	sprint.State = models.SprintPlanned
+
+	if err := h.SprintRepo.Create(sprint); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusCreated, sprint)
+}
+
+func (h *SprintHandler) GetSprint(c echo.Context) error {
+	sprint, err := h.sprint(c)
+	if sprint == nil {
+		return err
+	}
+
+	tasks, err := h.TaskRepo.FindByFilter(sprint.Workspace_id, repository.TaskFilter{Sprint_id: &sprint.ID})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, SprintDetailDTO{Sprint: sprint, Tasks: tasks})
+}
+
+func (h *SprintHandler) UpdateSprint(c echo.Context) error {
+	sprint, err := h.sprint(c)
+	if sprint == nil {
+		return err
+	}
+
+	if sprint.State == models.SprintCompleted {
+		return c.JSON(http.StatusConflict, "Completed sprints cannot be changed")
+	}
+
+	sprintUpdateDTO := new(SprintCreateDTO)
+	if err := c.Bind(sprintUpdateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(sprintUpdateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	start, end, err := sprintUpdateDTO.dates()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	sprint.Name = sprintUpdateDTO.Name
+	sprint.Goal = sprintUpdateDTO.Goal
+	sprint.Start_date = start
+	sprint.End_date = end
+
+	if err := h.SprintRepo.Update(sprint); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, sprint)
+}
+
+func (h *SprintHandler) StartSprint(c echo.Context) error {
+	sprint, err := h.sprint(c)
+	if sprint == nil {
+		return err
+	}
+
+	if sprint.State != models.SprintPlanned {
+		return c.JSON(http.StatusConflict, "Only planned sprints can be started")
+	}
+
+	sprints, err := h.SprintRepo.FindByWorkspaceID(sprint.Workspace_id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	for _, other := range sprints {
+		if other.State == models.SprintActive {
+			return c.JSON(http.StatusConflict, "Another sprint is already active in this workspace")
+		}
+	}
+
+	now := time.Now()
+	sprint.State = models.SprintActive
+	sprint.Started_at = &now
+
+	if err := h.SprintRepo.Update(sprint); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, sprint)
+}
+
+// CompleteSprint closes an active sprint. Unfinished tasks roll over to the
+// planned sprint given as rollover_sprint_id, or back to the backlog.
+func (h *SprintHandler) CompleteSprint(c echo.Context) error {
+	sprint, err := h.sprint(c)
+	if sprint == nil {
+		return err
+	}
+
+	if sprint.State != models.SprintActive {
+		return c.JSON(http.StatusConflict, "Only active sprints can be completed")
+	}
+
+	sprintCompleteDTO := new(SprintCompleteDTO)
+	if err := c.Bind(sprintCompleteDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if id := sprintCompleteDTO.Rollover_sprint_id; id != 0 {
+		rollover, err := h.SprintRepo.FindByID(id)
+		if err != nil || rollover.Workspace_id != sprint.Workspace_id || rollover.State != models.SprintPlanned {
+			return c.JSON(http.StatusBadRequest, "Rollover sprint must be a planned sprint of this workspace")
+		}
+	}
+
+	if err := h.SprintRepo.Complete(sprint, sprintCompleteDTO.Rollover_sprint_id); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, sprint)
+}
+
+func (h *SprintHandler) AddSprintTasks(c echo.Context) error {
+	sprint, err := h.sprint(c)
+	if sprint == nil {
+		return err
+	}
+
+	if sprint.State == models.SprintCompleted {
+		return c.JSON(http.StatusConflict, "Tasks cannot be added to a completed sprint")
+	}
+
+	sprintTasksDTO := new(SprintTasksDTO)
+	if err := c.Bind(sprintTasksDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(sprintTasksDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	tasks, err := h.TaskRepo.FindByWorkspaceID(sprint.Workspace_id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	inWorkspace := make(map[uint]bool, len(tasks))
+	for _, task := range tasks {
+		inWorkspace[task.ID] = true
+	}
+	for _, id := range sprintTasksDTO.Task_ids {
+		if !inWorkspace[id] {
+			return c.JSON(http.StatusBadRequest, "All tasks must belong to the workspace")
+		}
+	}
+
+	op := repository.TaskBulkOperation{Op: repository.TaskBulkSetSprint, Sprint_id: sprint.ID}
+	if err := h.TaskRepo.BulkApply(sprintTasksDTO.Task_ids, op); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *SprintHandler) RemoveSprintTask(c echo.Context) error {
+	sprint, err := h.sprint(c)
+	if sprint == nil {
+		return err
+	}
+
+	taskId, err := strconv.ParseUint(c.Param("taskId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	task, err := h.TaskRepo.FindByID(uint(taskId))
+	if err != nil || task.Sprint_id != sprint.ID {
+		return c.JSON(http.StatusNotFound, "Task not found in sprint")
+	}
+
+	op := repository.TaskBulkOperation{Op: repository.TaskBulkSetSprint, Sprint_id: 0}
+	if err := h.TaskRepo.BulkApply([]uint{task.ID}, op); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *SprintHandler) GetBurndown(c echo.Context) error {
+	sprint, err := h.sprint(c)
+	if sprint == nil {
+		return err
+	}
+
+	points, err := h.SprintRepo.Burndown(sprint)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, points)
+}
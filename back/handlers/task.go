@@ -1,27 +1,39 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/raeinsoltani/gorello/back/models"
 	"github.com/raeinsoltani/gorello/back/repository"
+	"gorm.io/gorm"
 )
 
 type TaskHandler struct {
 	TaskRepo              repository.Task
+	TaskLinkRepo          repository.TaskLink
 	UserWorkspaceRoleRepo repository.UserWorkspaceRole
 	UserRepo              repository.User
+	WebhookRepo           repository.Webhook
+	CustomFieldRepo       repository.CustomField
+	WorkflowRepo          repository.Workflow
 }
 
-func NewTaskHandler(taskRepo repository.Task, userWorkspaceRoleRepo repository.UserWorkspaceRole, userRepo repository.User) *TaskHandler {
+func NewTaskHandler(taskRepo repository.Task, taskLinkRepo repository.TaskLink, userWorkspaceRoleRepo repository.UserWorkspaceRole, userRepo repository.User, webhookRepo repository.Webhook, customFieldRepo repository.CustomField, workflowRepo repository.Workflow) *TaskHandler {
 	return &TaskHandler{
 		TaskRepo:              taskRepo,
+		TaskLinkRepo:          taskLinkRepo,
 		UserWorkspaceRoleRepo: userWorkspaceRoleRepo,
 		UserRepo:              userRepo,
+		WebhookRepo:           webhookRepo,
+		CustomFieldRepo:       customFieldRepo,
+		WorkflowRepo:          workflowRepo,
 	}
 }
 
@@ -36,6 +48,9 @@ type TaskCreateDTO struct {
 	Assignee_id    uint   `json:"assignee_id"`
 	Workspace_id   uint   `json:"workspace_id"`
 	Image_url      string `json:"image_url"`
+	Story_points   uint   `json:"story_points"`
+	// Custom_fields sets custom field values by field id; null clears one.
+	Custom_fields map[uint]json.RawMessage `json:"custom_fields"`
 }
 
 func (t *TaskCreateDTO) Validate() error {
@@ -81,6 +96,7 @@ func (h *TaskHandler) CreateTask(c echo.Context) error {
 		Assignee_id:    taskCreateDTO.Assignee_id,
 		Workspace_id:   taskCreateDTO.Workspace_id,
 		Image_url:      taskCreateDTO.Image_url,
+		Story_points:   taskCreateDTO.Story_points,
 	}
 
 	workspace_id, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
@@ -95,7 +111,7 @@ func (h *TaskHandler) CreateTask(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
 
-	workspaces, err := h.UserWorkspaceRoleRepo.FindByUserID(user.ID)
+	workspaces, err := h.UserWorkspaceRoleRepo.FindEffectiveByUserID(user.ID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
@@ -112,10 +128,29 @@ func (h *TaskHandler) CreateTask(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, "Access denied to this workspace")
 	}
 
-	err = h.TaskRepo.Create(task)
+	w, err := loadWorkflow(h.WorkflowRepo, task.Workspace_id)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
+	task.Status = w.initialStatus(task.Status)
+	if err := w.checkStatus(task.Status); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err := w.checkPriority(task.Priority); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	customFields, err := customFieldValues(c, h.CustomFieldRepo, h.UserWorkspaceRoleRepo, task.Workspace_id, taskCreateDTO.Custom_fields, true)
+	if customFields == nil {
+		return err
+	}
+
+	err = h.TaskRepo.CreateWithCustomFields(task, customFields.Values)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	emit(c, h.WebhookRepo, task.Workspace_id, repository.EventTaskCreated, task)
 
 	return c.JSON(http.StatusCreated, task)
 }
@@ -126,7 +161,24 @@ func (h *TaskHandler) GetTasks(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, err.Error())
 	}
 
-	tasks, err := h.TaskRepo.FindByWorkspaceID(uint(workspace_id))
+	filter, err := parseTaskFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if filter.Archived == nil {
+		archived := false
+		filter.Archived = &archived
+	}
+
+	fields, err := h.CustomFieldRepo.FindByWorkspaceID(uint(workspace_id))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if err := parseCustomFieldFilter(c, fields, &filter); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	tasks, err := h.TaskRepo.FindByFilter(uint(workspace_id), filter)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
@@ -134,6 +186,38 @@ func (h *TaskHandler) GetTasks(c echo.Context) error {
 	return c.JSON(http.StatusOK, tasks)
 }
 
+// parseTaskFilter reads the status, priority, assignee_id, sprint_id and
+// archived query parameters shared by the task listing endpoints.
+func parseTaskFilter(c echo.Context) (repository.TaskFilter, error) {
+	var filter repository.TaskFilter
+	params := map[string]**uint{
+		"status":      &filter.Status,
+		"priority":    &filter.Priority,
+		"assignee_id": &filter.Assignee_id,
+		"sprint_id":   &filter.Sprint_id,
+	}
+	for name, field := range params {
+		value := c.QueryParam(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return filter, fmt.Errorf("invalid %s: %s", name, value)
+		}
+		v := uint(parsed)
+		*field = &v
+	}
+	if value := c.QueryParam("archived"); value != "" {
+		archived, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid archived: %s", value)
+		}
+		filter.Archived = &archived
+	}
+	return filter, nil
+}
+
 func (h *TaskHandler) GetTask(c echo.Context) error {
 	task_id, err := strconv.ParseUint(c.Param("taskId"), 10, 64)
 	if err != nil {
@@ -145,7 +229,22 @@ func (h *TaskHandler) GetTask(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
 
-	return c.JSON(http.StatusOK, task)
+	links, openBlockers, err := h.taskLinks(task)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	values, err := h.CustomFieldRepo.FindValues(task.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	customFields := make(map[uint]json.RawMessage, len(values))
+	for _, value := range values {
+		customFields[value.Custom_field_id] = json.RawMessage(value.Value)
+	}
+
+	setETag(c, task.Version)
+	return c.JSON(http.StatusOK, TaskDetailDTO{Task: task, Links: links, BlockedBy: openBlockers, Custom_fields: customFields})
 }
 
 func (h *TaskHandler) UpdateTask(c echo.Context) error {
@@ -168,6 +267,46 @@ func (h *TaskHandler) UpdateTask(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
 
+	if !ifMatch(c, task.Version) {
+		return c.JSON(http.StatusPreconditionFailed, "Task was modified by another request")
+	}
+
+	customFields, err := customFieldValues(c, h.CustomFieldRepo, h.UserWorkspaceRoleRepo, task.Workspace_id, taskUpdateDTO.Custom_fields, false)
+	if customFields == nil {
+		return err
+	}
+
+	w, err := loadWorkflow(h.WorkflowRepo, task.Workspace_id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if err := w.checkStatus(taskUpdateDTO.Status); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err := w.checkPriority(taskUpdateDTO.Priority); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	if err := w.checkTransition(task.Status, taskUpdateDTO.Status); err != nil {
+		return c.JSON(http.StatusConflict, err.Error())
+	}
+
+	// Moving to done while blockers are open is refused unless forced.
+	if w.isDone(taskUpdateDTO.Status) && !w.isDone(task.Status) {
+		_, openBlockers, err := h.taskLinks(task)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		if len(openBlockers) > 0 {
+			if force, _ := strconv.ParseBool(c.QueryParam("force")); !force {
+				return c.JSON(http.StatusConflict, map[string]interface{}{
+					"error":      "Task is blocked by open tasks",
+					"blocked_by": openBlockers,
+				})
+			}
+			c.Response().Header().Set("Warning", `299 - "task was completed with open blockers"`)
+		}
+	}
+
 	task.Title = taskUpdateDTO.Title
 	task.Description = taskUpdateDTO.Description
 	task.Status = taskUpdateDTO.Status
@@ -175,12 +314,19 @@ func (h *TaskHandler) UpdateTask(c echo.Context) error {
 	task.Actual_time = taskUpdateDTO.Actual_time
 	task.Priority = taskUpdateDTO.Priority
 	task.Image_url = taskUpdateDTO.Image_url
+	task.Story_points = taskUpdateDTO.Story_points
 
-	err = h.TaskRepo.Update(task)
+	err = h.TaskRepo.UpdateWithCustomFields(task, customFields.Values, customFields.Cleared)
+	if errors.Is(err, repository.ErrStaleVersion) {
+		return c.JSON(http.StatusPreconditionFailed, "Task was modified by another request")
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
 
+	emit(c, h.WebhookRepo, task.Workspace_id, repository.EventTaskUpdated, task)
+
+	setETag(c, task.Version)
 	return c.JSON(http.StatusOK, task)
 }
 
@@ -190,10 +336,102 @@ func (h *TaskHandler) DeleteTask(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, err.Error())
 	}
 
-	err = h.TaskRepo.Delete(uint(taskId))
+	task, err := h.TaskRepo.FindByID(uint(taskId))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, "Task not found")
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
 
+	if !ifMatch(c, task.Version) {
+		return c.JSON(http.StatusPreconditionFailed, "Task was modified by another request")
+	}
+
+	err = h.TaskRepo.Delete(task.ID, task.Version)
+	if errors.Is(err, repository.ErrStaleVersion) {
+		return c.JSON(http.StatusPreconditionFailed, "Task was modified by another request")
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	emit(c, h.WebhookRepo, task.Workspace_id, repository.EventTaskDeleted, task)
+
 	return c.JSON(http.StatusNoContent, fmt.Sprintf("Task with id %d deleted", taskId))
 }
+
+// ArchiveTask hides the task from the default task listing. It can still
+// be fetched, searched and exported.
+func (h *TaskHandler) ArchiveTask(c echo.Context) error {
+	return h.setArchived(c, true)
+}
+
+func (h *TaskHandler) UnarchiveTask(c echo.Context) error {
+	return h.setArchived(c, false)
+}
+
+func (h *TaskHandler) setArchived(c echo.Context, archived bool) error {
+	authUsername, ok := c.Get("username").(string)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	workspaceId, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	taskId, err := strconv.ParseUint(c.Param("taskId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	role, err := workspaceRole(h.UserRepo, h.UserWorkspaceRoleRepo, authUsername, uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if role == nil {
+		return c.JSON(http.StatusForbidden, "Access denied to the workspace")
+	}
+
+	task, err := h.TaskRepo.FindByID(uint(taskId))
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && task.Workspace_id != uint(workspaceId)) {
+		return c.JSON(http.StatusNotFound, "Task not found")
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	if !ifMatch(c, task.Version) {
+		return c.JSON(http.StatusPreconditionFailed, "Task was modified by another request")
+	}
+
+	event := repository.EventTaskUnarchived
+	if archived {
+		if task.Archived_at != nil {
+			return c.JSON(http.StatusConflict, "Task is already archived")
+		}
+		now := time.Now()
+		task.Archived_at = &now
+		event = repository.EventTaskArchived
+	} else {
+		if task.Archived_at == nil {
+			return c.JSON(http.StatusConflict, "Task is not archived")
+		}
+		task.Archived_at = nil
+	}
+
+	err = h.TaskRepo.Update(task)
+	if errors.Is(err, repository.ErrStaleVersion) {
+		return c.JSON(http.StatusPreconditionFailed, "Task was modified by another request")
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	emit(c, h.WebhookRepo, task.Workspace_id, event, task)
+
+	setETag(c, task.Version)
+	return c.JSON(http.StatusOK, task)
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/models"
+	"gorm.io/gorm"
+)
+
+type TaskLinkCreateDTO struct {
+	Type    string `json:"type" validate:"required,oneof=blocks blocked_by relates_to duplicates"`
+	Task_id uint   `json:"task_id" validate:"required"`
+}
+
+// TaskLinkDTO describes a link from the point of view of one of its tasks,
+// so a "blocks" link shows up as "blocked_by" on the blocked task.
+type TaskLinkDTO struct {
+	ID      uint   `json:"id"`
+	Type    string `json:"type"`
+	Task_id uint   `json:"task_id"`
+	Title   string `json:"title"`
+	Status  uint   `json:"status"`
+}
+
+type TaskDetailDTO struct {
+	*models.Task
+	Links     []TaskLinkDTO `json:"links"`
+	BlockedBy []TaskLinkDTO `json:"blocked_by"`
+	// Custom_fields holds the task's custom field values by field id.
+	Custom_fields map[uint]json.RawMessage `json:"custom_fields"`
+}
+
+// taskLinks returns the task's links as seen from the task, and the subset
+// of blockers that aren't done yet.
+func (h *TaskHandler) taskLinks(task *models.Task) ([]TaskLinkDTO, []TaskLinkDTO, error) {
+	links, err := h.TaskLinkRepo.FindByTaskID(task.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w, err := loadWorkflow(h.WorkflowRepo, task.Workspace_id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	views := make([]TaskLinkDTO, 0, len(links))
+	openBlockers := make([]TaskLinkDTO, 0)
+	for _, link := range links {
+		view := TaskLinkDTO{ID: link.ID, Type: link.Type, Task_id: link.Linked_task_id}
+		if link.Linked_task_id == task.ID {
+			view.Task_id = link.Task_id
+			switch link.Type {
+			case models.TaskLinkBlocks:
+				view.Type = "blocked_by"
+			case models.TaskLinkDuplicates:
+				view.Type = "duplicated_by"
+			}
+		}
+
+		other, err := h.TaskRepo.FindByID(view.Task_id)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		view.Title = other.Title
+		view.Status = other.Status
+
+		views = append(views, view)
+		if view.Type == "blocked_by" && !w.isDone(other.Status) {
+			openBlockers = append(openBlockers, view)
+		}
+	}
+	return views, openBlockers, nil
+}
+
+// createsCycle reports whether adding "blocker blocks blocked" would close a
+// loop, i.e. whether blocked already blocks blocker, directly or not.
+func createsCycle(links []*models.TaskLink, blocker, blocked uint) bool {
+	blocks := make(map[uint][]uint)
+	for _, link := range links {
+		if link.Type == models.TaskLinkBlocks {
+			blocks[link.Task_id] = append(blocks[link.Task_id], link.Linked_task_id)
+		}
+	}
+
+	visited := map[uint]bool{blocked: true}
+	queue := []uint{blocked}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if current == blocker {
+			return true
+		}
+		for _, next := range blocks[current] {
+			if !visited[next] {
+				visited[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	return false
+}
+
+func (h *TaskHandler) CreateTaskLink(c echo.Context) error {
+	authUsername, ok := c.Get("username").(string)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	workspaceId, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	taskId, err := strconv.ParseUint(c.Param("taskId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	taskLinkCreateDTO := new(TaskLinkCreateDTO)
+	if err := c.Bind(taskLinkCreateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(taskLinkCreateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	role, err := workspaceRole(h.UserRepo, h.UserWorkspaceRoleRepo, authUsername, uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if role == nil {
+		return c.JSON(http.StatusForbidden, "Access denied to the workspace")
+	}
+
+	if uint(taskId) == taskLinkCreateDTO.Task_id {
+		return c.JSON(http.StatusBadRequest, "A task cannot be linked to itself")
+	}
+
+	for _, id := range []uint{uint(taskId), taskLinkCreateDTO.Task_id} {
+		task, err := h.TaskRepo.FindByID(id)
+		if err != nil || task.Workspace_id != uint(workspaceId) {
+			return c.JSON(http.StatusNotFound, "Both tasks must belong to the workspace")
+		}
+	}
+
+	link := &models.TaskLink{
+		Task_id:        uint(taskId),
+		Linked_task_id: taskLinkCreateDTO.Task_id,
+		Type:           taskLinkCreateDTO.Type,
+		Workspace_id:   uint(workspaceId),
+	}
+	if link.Type == "blocked_by" {
+		link.Type = models.TaskLinkBlocks
+		link.Task_id, link.Linked_task_id = link.Linked_task_id, link.Task_id
+	}
+
+	if link.Type == models.TaskLinkBlocks {
+		links, err := h.TaskLinkRepo.FindByWorkspaceID(uint(workspaceId))
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		if createsCycle(links, link.Task_id, link.Linked_task_id) {
+			return c.JSON(http.StatusConflict, "Link would create a circular blocker")
+		}
+	}
+
+	if err := h.TaskLinkRepo.Create(link); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return c.JSON(http.StatusConflict, "Tasks are already linked")
+		}
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusCreated, link)
+}
+
+func (h *TaskHandler) DeleteTaskLink(c echo.Context) error {
+	authUsername, ok := c.Get("username").(string)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	workspaceId, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	taskId, err := strconv.ParseUint(c.Param("taskId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	linkId, err := strconv.ParseUint(c.Param("linkId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	role, err := workspaceRole(h.UserRepo, h.UserWorkspaceRoleRepo, authUsername, uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if role == nil {
+		return c.JSON(http.StatusForbidden, "Access denied to the workspace")
+	}
+
+	link, err := h.TaskLinkRepo.FindByID(uint(linkId))
+	if err != nil || link.Workspace_id != uint(workspaceId) ||
+		(link.Task_id != uint(taskId) && link.Linked_task_id != uint(taskId)) {
+		return c.JSON(http.StatusNotFound, "Link not found")
+	}
+
+	if err := h.TaskLinkRepo.Delete(link.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
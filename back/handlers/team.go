@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+	"gorm.io/gorm"
+)
+
+type TeamHandler struct {
+	TeamRepo         repository.Team
+	OrganizationRepo repository.Organization
+	WorkspaceRepo    repository.Workspace
+	UserRepo         repository.User
+}
+
+func NewTeamHandler(teamRepo repository.Team, organizationRepo repository.Organization, workspaceRepo repository.Workspace, userRepo repository.User) *TeamHandler {
+	return &TeamHandler{
+		TeamRepo:         teamRepo,
+		OrganizationRepo: organizationRepo,
+		WorkspaceRepo:    workspaceRepo,
+		UserRepo:         userRepo,
+	}
+}
+
+type TeamCreateDTO struct {
+	Name        string `json:"name" validate:"required,max=100"`
+	Description string `json:"description" validate:"max=255"`
+}
+
+type TeamWorkspaceRoleDTO struct {
+	Role *uint `json:"role" validate:"required,oneof=0 1"`
+}
+
+// team loads the team in the path after checking that the caller is a
+// member, or an admin if admin is set, of the team's organization. On
+// failure the response has already been written and the returned team is
+// nil.
+func (h *TeamHandler) team(c echo.Context, admin bool) (*models.Team, error) {
+	organization, member, err := organizationMember(c, h.OrganizationRepo, h.UserRepo, admin)
+	if member == nil {
+		return nil, err
+	}
+
+	if c.Param("teamId") == "" {
+		return &models.Team{Organization_id: organization.ID}, nil
+	}
+
+	teamId, err := strconv.ParseUint(c.Param("teamId"), 10, 64)
+	if err != nil {
+		return nil, c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	team, err := h.TeamRepo.FindByID(uint(teamId))
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && team.Organization_id != organization.ID) {
+		return nil, c.JSON(http.StatusNotFound, "Team not found")
+	}
+	if err != nil {
+		return nil, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	return team, nil
+}
+
+func (h *TeamHandler) GetTeams(c echo.Context) error {
+	team, err := h.team(c, false)
+	if team == nil {
+		return err
+	}
+
+	teams, err := h.TeamRepo.FindByOrganizationID(team.Organization_id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, teams)
+}
+
+func (h *TeamHandler) CreateTeam(c echo.Context) error {
+	team, err := h.team(c, true)
+	if team == nil {
+		return err
+	}
+
+	teamCreateDTO := new(TeamCreateDTO)
+	if err := c.Bind(teamCreateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(teamCreateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	team.Name = teamCreateDTO.Name
+	team.Description = teamCreateDTO.Description
+	if err := h.TeamRepo.Create(team); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusCreated, team)
+}
+
+func (h *TeamHandler) UpdateTeam(c echo.Context) error {
+	team, err := h.team(c, true)
+	if team == nil {
+		return err
+	}
+
+	teamUpdateDTO := new(TeamCreateDTO)
+	if err := c.Bind(teamUpdateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(teamUpdateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	team.Name = teamUpdateDTO.Name
+	team.Description = teamUpdateDTO.Description
+	if err := h.TeamRepo.Update(team); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, team)
+}
+
+func (h *TeamHandler) DeleteTeam(c echo.Context) error {
+	team, err := h.team(c, true)
+	if team == nil {
+		return err
+	}
+
+	if err := h.TeamRepo.Delete(team.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *TeamHandler) GetTeamMembers(c echo.Context) error {
+	team, err := h.team(c, false)
+	if team == nil {
+		return err
+	}
+
+	members, err := h.TeamRepo.FindMembers(team.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	users := make([]PublicUserDTO, 0, len(members))
+	for _, member := range members {
+		user, err := h.UserRepo.FindByID(member.User_id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		users = append(users, newPublicUserDTO(user))
+	}
+
+	return c.JSON(http.StatusOK, users)
+}
+
+// AddTeamMember adds a member of the organization to the team.
+func (h *TeamHandler) AddTeamMember(c echo.Context) error {
+	team, err := h.team(c, true)
+	if team == nil {
+		return err
+	}
+
+	user, err := h.UserRepo.FindByUsername(c.Param("username"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if user == nil {
+		return c.JSON(http.StatusNotFound, "User not found")
+	}
+
+	member, err := h.OrganizationRepo.FindMember(team.Organization_id, user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if member == nil {
+		return c.JSON(http.StatusBadRequest, "Only members of the organization can join its teams")
+	}
+
+	if err := h.TeamRepo.AddMember(team.ID, user.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, newPublicUserDTO(user))
+}
+
+func (h *TeamHandler) RemoveTeamMember(c echo.Context) error {
+	team, err := h.team(c, true)
+	if team == nil {
+		return err
+	}
+
+	user, err := h.UserRepo.FindByUsername(c.Param("username"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if user == nil {
+		return c.JSON(http.StatusNotFound, "Member not found")
+	}
+
+	err = h.TeamRepo.RemoveMember(team.ID, user.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, "Member not found")
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *TeamHandler) GetTeamWorkspaces(c echo.Context) error {
+	team, err := h.team(c, false)
+	if team == nil {
+		return err
+	}
+
+	roles, err := h.TeamRepo.FindWorkspaceRoles(team.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, roles)
+}
+
+// SetTeamWorkspaceRole gives every member of the team, present and future,
+// the role in a workspace of the organization.
+func (h *TeamHandler) SetTeamWorkspaceRole(c echo.Context) error {
+	team, err := h.team(c, true)
+	if team == nil {
+		return err
+	}
+
+	teamWorkspaceRoleDTO := new(TeamWorkspaceRoleDTO)
+	if err := c.Bind(teamWorkspaceRoleDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(teamWorkspaceRoleDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	workspaceId, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	workspace, err := h.WorkspaceRepo.FindByID(uint(workspaceId))
+	if errors.Is(err, gorm.ErrRecordNotFound) ||
+		(err == nil && (workspace.Organization_id == nil || *workspace.Organization_id != team.Organization_id)) {
+		return c.JSON(http.StatusNotFound, "Workspace not found in the organization")
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	role := models.TeamWorkspaceRole{
+		Team_id:      team.ID,
+		Workspace_id: workspace.ID,
+		Role:         *teamWorkspaceRoleDTO.Role,
+	}
+	if err := h.TeamRepo.SetWorkspaceRole(&role); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, role)
+}
+
+func (h *TeamHandler) RemoveTeamWorkspaceRole(c echo.Context) error {
+	team, err := h.team(c, true)
+	if team == nil {
+		return err
+	}
+
+	workspaceId, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	err = h.TeamRepo.RemoveWorkspaceRole(team.ID, uint(workspaceId))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, "Team has no role in the workspace")
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,483 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+	"gorm.io/gorm"
+)
+
+type TemplateHandler struct {
+	TemplateRepo          repository.WorkspaceTemplate
+	WorkspaceRepo         repository.Workspace
+	TaskRepo              repository.Task
+	LabelRepo             repository.Label
+	UserWorkspaceRoleRepo repository.UserWorkspaceRole
+	UserRepo              repository.User
+	WorkflowRepo          repository.Workflow
+	CustomFieldRepo       repository.CustomField
+}
+
+func NewTemplateHandler(templateRepo repository.WorkspaceTemplate, workspaceRepo repository.Workspace, taskRepo repository.Task, labelRepo repository.Label, userWorkspaceRoleRepo repository.UserWorkspaceRole, userRepo repository.User, workflowRepo repository.Workflow, customFieldRepo repository.CustomField) *TemplateHandler {
+	return &TemplateHandler{
+		TemplateRepo:          templateRepo,
+		WorkspaceRepo:         workspaceRepo,
+		TaskRepo:              taskRepo,
+		LabelRepo:             labelRepo,
+		UserWorkspaceRoleRepo: userWorkspaceRoleRepo,
+		UserRepo:              userRepo,
+		WorkflowRepo:          workflowRepo,
+		CustomFieldRepo:       customFieldRepo,
+	}
+}
+
+type TemplateSaveDTO struct {
+	Name             string `json:"name" validate:"required,max=100"`
+	Description      string `json:"description" validate:"max=255"`
+	Include_tasks    bool   `json:"include_tasks"`
+	Include_subtasks bool   `json:"include_subtasks"`
+}
+
+type WorkspaceCloneDTO struct {
+	// Name defaults to the source's name followed by " (copy)".
+	Name             string `json:"name" validate:"max=100"`
+	Include_tasks    bool   `json:"include_tasks"`
+	Include_subtasks bool   `json:"include_subtasks"`
+	Include_labels   bool   `json:"include_labels"`
+	Include_members  bool   `json:"include_members"`
+}
+
+// findTemplate loads a template the user may use, or returns nil if there
+// is none.
+func findTemplate(templateRepo repository.WorkspaceTemplate, id uint, user *models.User) (*models.WorkspaceTemplate, error) {
+	template, err := templateRepo.FindByID(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !template.Built_in && template.User_id != user.ID) {
+		return nil, nil
+	}
+	return template, err
+}
+
+// templateWorkspace turns a template's content into the labels, workflow
+// and tasks of a new workspace, ready for WorkspaceRepo.Import.
+func templateWorkspace(content models.TemplateContent) repository.WorkspaceContent {
+	labels := make([]*models.Label, 0, len(content.Labels))
+	for _, label := range content.Labels {
+		labels = append(labels, &models.Label{Name: label.Name, Color: label.Color})
+	}
+
+	tasks := make([]*repository.ImportTask, 0, len(content.Tasks))
+	for _, templateTask := range content.Tasks {
+		task := &models.Task{
+			Title:          templateTask.Title,
+			Description:    templateTask.Description,
+			Status:         templateTask.Status,
+			Priority:       templateTask.Priority,
+			Estimated_time: templateTask.Estimated_time,
+			Story_points:   templateTask.Story_points,
+		}
+		for _, name := range templateTask.Labels {
+			task.Labels = append(task.Labels, models.Label{Name: name})
+		}
+		for _, title := range templateTask.SubTasks {
+			task.SubTasks = append(task.SubTasks, models.SubTask{Title: title})
+		}
+		tasks = append(tasks, &repository.ImportTask{Task: task})
+	}
+
+	statuses, priorities := templateWorkflow(content.Statuses, content.Priorities)
+	return repository.WorkspaceContent{
+		Labels:     labels,
+		Statuses:   statuses,
+		Priorities: priorities,
+		Tasks:      tasks,
+	}
+}
+
+// templateWorkflow turns the statuses and priorities of a template, or of
+// an archive, into those of a new workspace.
+func templateWorkflow(templateStatuses []models.TemplateStatus, templatePriorities []models.TemplatePriority) ([]*models.WorkflowStatus, []*models.WorkflowPriority) {
+	statuses := make([]*models.WorkflowStatus, 0, len(templateStatuses))
+	for _, status := range templateStatuses {
+		statuses = append(statuses, &models.WorkflowStatus{
+			Value:        status.Value,
+			Name:         status.Name,
+			Category:     status.Category,
+			Color:        status.Color,
+			Allowed_from: status.Allowed_from,
+		})
+	}
+
+	priorities := make([]*models.WorkflowPriority, 0, len(templatePriorities))
+	for _, priority := range templatePriorities {
+		priorities = append(priorities, &models.WorkflowPriority{
+			Value: priority.Value,
+			Name:  priority.Name,
+			Color: priority.Color,
+		})
+	}
+	return statuses, priorities
+}
+
+// workflowTemplate turns a workspace's statuses and priorities into those
+// of a template or an archive.
+func workflowTemplate(statuses []*models.WorkflowStatus, priorities []*models.WorkflowPriority) ([]models.TemplateStatus, []models.TemplatePriority) {
+	var templateStatuses []models.TemplateStatus
+	for _, status := range statuses {
+		templateStatuses = append(templateStatuses, models.TemplateStatus{
+			Value:        status.Value,
+			Name:         status.Name,
+			Category:     status.Category,
+			Color:        status.Color,
+			Allowed_from: status.Allowed_from,
+		})
+	}
+
+	var templatePriorities []models.TemplatePriority
+	for _, priority := range priorities {
+		templatePriorities = append(templatePriorities, models.TemplatePriority{
+			Value: priority.Value,
+			Name:  priority.Name,
+			Color: priority.Color,
+		})
+	}
+	return templateStatuses, templatePriorities
+}
+
+// source loads the workspace in the path after checking that the caller
+// can access it. On failure the response has already been written and the
+// returned workspace is nil.
+func (h *TemplateHandler) source(c echo.Context) (*models.Workspace, *models.User, error) {
+	authUsername, ok := c.Get("username").(string)
+	if !ok {
+		return nil, nil, c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	workspaceId, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
+	if err != nil {
+		return nil, nil, c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	role, err := workspaceRole(h.UserRepo, h.UserWorkspaceRoleRepo, authUsername, uint(workspaceId))
+	if err != nil {
+		return nil, nil, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if role == nil {
+		return nil, nil, c.JSON(http.StatusForbidden, "Access denied to the workspace")
+	}
+
+	user, err := h.UserRepo.FindByUsername(authUsername)
+	if err != nil {
+		return nil, nil, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	workspace, err := h.WorkspaceRepo.FindByID(uint(workspaceId))
+	if err != nil {
+		return nil, nil, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	return workspace, user, nil
+}
+
+// SaveTemplate saves the workspace's labels and workflow, and optionally its
+// tasks and subtasks, as a template for the caller.
+func (h *TemplateHandler) SaveTemplate(c echo.Context) error {
+	workspace, user, err := h.source(c)
+	if workspace == nil {
+		return err
+	}
+
+	templateSaveDTO := new(TemplateSaveDTO)
+	if err := c.Bind(templateSaveDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(templateSaveDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	labels, err := h.LabelRepo.FindByWorkspaceID(workspace.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	content := models.TemplateContent{
+		Labels: make([]models.TemplateLabel, 0, len(labels)),
+		Tasks:  []models.TemplateTask{},
+	}
+	for _, label := range labels {
+		content.Labels = append(content.Labels, models.TemplateLabel{Name: label.Name, Color: label.Color})
+	}
+
+	statuses, err := h.WorkflowRepo.FindStatuses(workspace.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	priorities, err := h.WorkflowRepo.FindPriorities(workspace.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	content.Statuses, content.Priorities = workflowTemplate(statuses, priorities)
+
+	if templateSaveDTO.Include_tasks {
+		tasks, err := h.TaskRepo.FindByWorkspaceIDWithDetails(workspace.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		for _, task := range tasks {
+			templateTask := models.TemplateTask{
+				Title:          task.Title,
+				Description:    task.Description,
+				Status:         task.Status,
+				Priority:       task.Priority,
+				Estimated_time: task.Estimated_time,
+				Story_points:   task.Story_points,
+			}
+			for _, label := range task.Labels {
+				templateTask.Labels = append(templateTask.Labels, label.Name)
+			}
+			if templateSaveDTO.Include_subtasks {
+				for _, subTask := range task.SubTasks {
+					templateTask.SubTasks = append(templateTask.SubTasks, subTask.Title)
+				}
+			}
+			content.Tasks = append(content.Tasks, templateTask)
+		}
+	}
+
+	template := models.WorkspaceTemplate{
+		Name:        templateSaveDTO.Name,
+		Description: templateSaveDTO.Description,
+		User_id:     user.ID,
+		Content:     content,
+	}
+	if err := h.TemplateRepo.Create(&template); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusCreated, template)
+}
+
+func (h *TemplateHandler) GetTemplates(c echo.Context) error {
+	authUsername, ok := c.Get("username").(string)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	user, err := h.UserRepo.FindByUsername(authUsername)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	templates, err := h.TemplateRepo.FindAvailable(user.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, templates)
+}
+
+// template loads the template in the path if the caller may use it. On
+// failure the response has already been written and the returned template
+// is nil.
+func (h *TemplateHandler) template(c echo.Context) (*models.WorkspaceTemplate, *models.User, error) {
+	authUsername, ok := c.Get("username").(string)
+	if !ok {
+		return nil, nil, c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	templateId, err := strconv.ParseUint(c.Param("templateId"), 10, 64)
+	if err != nil {
+		return nil, nil, c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	user, err := h.UserRepo.FindByUsername(authUsername)
+	if err != nil {
+		return nil, nil, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	template, err := findTemplate(h.TemplateRepo, uint(templateId), user)
+	if err != nil {
+		return nil, nil, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if template == nil {
+		return nil, nil, c.JSON(http.StatusNotFound, "Template not found")
+	}
+	return template, user, nil
+}
+
+func (h *TemplateHandler) GetTemplate(c echo.Context) error {
+	template, _, err := h.template(c)
+	if template == nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, template)
+}
+
+func (h *TemplateHandler) DeleteTemplate(c echo.Context) error {
+	template, _, err := h.template(c)
+	if template == nil {
+		return err
+	}
+
+	if template.Built_in {
+		return c.JSON(http.StatusForbidden, "Built-in templates cannot be deleted")
+	}
+
+	if err := h.TemplateRepo.Delete(template.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// CloneWorkspace copies the workspace into a new one owned by the caller.
+// The workflow and custom fields are always copied; labels, tasks,
+// subtasks and members are each copied only when asked for. Assignees and
+// user field values are kept only if they are members of the copy.
+func (h *TemplateHandler) CloneWorkspace(c echo.Context) error {
+	workspace, user, err := h.source(c)
+	if workspace == nil {
+		return err
+	}
+
+	workspaceCloneDTO := new(WorkspaceCloneDTO)
+	if err := c.Bind(workspaceCloneDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(workspaceCloneDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	clone := &models.Workspace{
+		Name:        workspaceCloneDTO.Name,
+		Description: workspace.Description,
+	}
+	if clone.Name == "" {
+		clone.Name = workspace.Name + " (copy)"
+	}
+
+	members := map[uint]bool{user.ID: true}
+	roles := []*models.UserWorkspaceRole{{User_id: user.ID, Role: 1}}
+	if workspaceCloneDTO.Include_members {
+		sourceRoles, err := h.UserWorkspaceRoleRepo.FindByWorkspaceID(workspace.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		for _, role := range sourceRoles {
+			if members[role.User_id] {
+				continue
+			}
+			members[role.User_id] = true
+			roles = append(roles, &models.UserWorkspaceRole{User_id: role.User_id, Role: role.Role})
+		}
+	}
+	assignee := func(id uint) uint {
+		if members[id] {
+			return id
+		}
+		return 0
+	}
+
+	labels := make([]*models.Label, 0)
+	if workspaceCloneDTO.Include_labels {
+		sourceLabels, err := h.LabelRepo.FindByWorkspaceID(workspace.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		for _, label := range sourceLabels {
+			labels = append(labels, &models.Label{Name: label.Name, Color: label.Color})
+		}
+	}
+
+	sourceFields, err := h.CustomFieldRepo.FindByWorkspaceID(workspace.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	fields := make([]*models.CustomField, 0, len(sourceFields))
+	fieldCopies := make(map[uint]*models.CustomField, len(sourceFields))
+	for _, field := range sourceFields {
+		fieldCopy := &models.CustomField{Name: field.Name, Type: field.Type, Options: field.Options, Required: field.Required}
+		fields = append(fields, fieldCopy)
+		fieldCopies[field.ID] = fieldCopy
+	}
+
+	tasks := make([]*repository.ImportTask, 0)
+	if workspaceCloneDTO.Include_tasks {
+		sourceTasks, err := h.TaskRepo.FindByWorkspaceIDWithDetails(workspace.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		values, err := h.CustomFieldRepo.FindValuesByWorkspaceID(workspace.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		byTask := make(map[uint][]*models.CustomFieldValue)
+		for _, value := range values {
+			byTask[value.Task_id] = append(byTask[value.Task_id], value)
+		}
+		for _, sourceTask := range sourceTasks {
+			task := &models.Task{
+				Title:          sourceTask.Title,
+				Description:    sourceTask.Description,
+				Status:         sourceTask.Status,
+				Estimated_time: sourceTask.Estimated_time,
+				Actual_time:    sourceTask.Actual_time,
+				Due_date:       sourceTask.Due_date,
+				Priority:       sourceTask.Priority,
+				Assignee_id:    assignee(sourceTask.Assignee_id),
+				Image_url:      sourceTask.Image_url,
+				Story_points:   sourceTask.Story_points,
+			}
+			// Import attaches labels by name to the labels it creates, so
+			// these are dropped unless the labels are copied too.
+			for _, label := range sourceTask.Labels {
+				task.Labels = append(task.Labels, models.Label{Name: label.Name})
+			}
+			if workspaceCloneDTO.Include_subtasks {
+				for _, subTask := range sourceTask.SubTasks {
+					task.SubTasks = append(task.SubTasks, models.SubTask{
+						Title:        subTask.Title,
+						Is_completed: subTask.Is_completed,
+						Assignee_id:  assignee(subTask.Assignee_id),
+					})
+				}
+			}
+			importTask := &repository.ImportTask{Task: task}
+			for _, value := range remapCustomFieldValues(byTask[sourceTask.ID], sourceFields, sourceFields, members) {
+				importTask.Custom_field_values = append(importTask.Custom_field_values,
+					repository.ImportCustomFieldValue{Field: fieldCopies[value.Custom_field_id], Value: value.Value})
+			}
+			tasks = append(tasks, importTask)
+		}
+	}
+
+	statuses, err := h.WorkflowRepo.FindStatuses(workspace.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	priorities, err := h.WorkflowRepo.FindPriorities(workspace.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	// The clone keeps the source's workflow, which its tasks' statuses and
+	// priorities come from.
+	err = h.WorkspaceRepo.Import(clone, repository.WorkspaceContent{
+		Roles:         roles,
+		Labels:        labels,
+		Statuses:      statuses,
+		Priorities:    priorities,
+		Custom_fields: fields,
+		Tasks:         tasks,
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusCreated, clone)
+}
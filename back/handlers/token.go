@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+	"github.com/raeinsoltani/gorello/back/utils"
+	"gorm.io/gorm"
+)
+
+type TokenHandler struct {
+	TokenRepo repository.PersonalAccessToken
+	UserRepo  repository.User
+}
+
+func NewTokenHandler(tokenRepo repository.PersonalAccessToken, userRepo repository.User) *TokenHandler {
+	return &TokenHandler{
+		TokenRepo: tokenRepo,
+		UserRepo:  userRepo,
+	}
+}
+
+type TokenCreateDTO struct {
+	Name            string `json:"name" validate:"required,max=100"`
+	Scope           string `json:"scope" validate:"required,oneof=read tasks:write workspace:admin"`
+	Expires_in_days uint   `json:"expires_in_days" validate:"max=3650"`
+}
+
+// TokenCreatedDTO is returned on creation, the only time the token itself
+// is shown.
+type TokenCreatedDTO struct {
+	*models.PersonalAccessToken
+	Token string `json:"token"`
+}
+
+// user loads the user named in the path after checking that it is the
+// caller. Tokens can't be managed with a token, only after a login. On
+// failure the response has already been written and the returned user is
+// nil.
+func (h *TokenHandler) user(c echo.Context) (*models.User, error) {
+	username := c.Param("username")
+	authUsername := c.Get("username")
+	if authUsername != username {
+		return nil, c.JSON(http.StatusForbidden, "Access denied")
+	}
+	if c.Get("token_id") != nil {
+		return nil, c.JSON(http.StatusForbidden, "Access tokens cannot be managed with an access token")
+	}
+
+	user, err := h.UserRepo.FindByUsername(username)
+	if err != nil {
+		log.Printf("error finding user: %s", err.Error())
+		return nil, c.NoContent(http.StatusInternalServerError)
+	}
+	if user == nil {
+		return nil, c.JSON(http.StatusNotFound, "User not found")
+	}
+	return user, nil
+}
+
+func (h *TokenHandler) GetTokens(c echo.Context) error {
+	user, err := h.user(c)
+	if user == nil {
+		return err
+	}
+
+	tokens, err := h.TokenRepo.FindByUserID(user.ID)
+	if err != nil {
+		log.Printf("error fetching access tokens: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.JSON(http.StatusOK, tokens)
+}
+
+func (h *TokenHandler) CreateToken(c echo.Context) error {
+	user, err := h.user(c)
+	if user == nil {
+		return err
+	}
+
+	tokenCreateDTO := new(TokenCreateDTO)
+	if err := c.Bind(tokenCreateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(tokenCreateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	raw, hash, err := utils.GenerateAccessToken()
+	if err != nil {
+		log.Printf("error generating access token: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	token := models.PersonalAccessToken{
+		User_id:    user.ID,
+		Name:       tokenCreateDTO.Name,
+		Token_hash: hash,
+		Prefix:     raw[:len(utils.AccessTokenPrefix)+4],
+		Scope:      tokenCreateDTO.Scope,
+	}
+	if days := tokenCreateDTO.Expires_in_days; days > 0 {
+		expiresAt := time.Now().AddDate(0, 0, int(days))
+		token.Expires_at = &expiresAt
+	}
+
+	if err := h.TokenRepo.Create(&token); err != nil {
+		log.Printf("error creating access token: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.JSON(http.StatusCreated, TokenCreatedDTO{PersonalAccessToken: &token, Token: raw})
+}
+
+func (h *TokenHandler) RevokeToken(c echo.Context) error {
+	user, err := h.user(c)
+	if user == nil {
+		return err
+	}
+
+	tokenId, err := strconv.ParseUint(c.Param("tokenId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	token, err := h.TokenRepo.FindByID(uint(tokenId))
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && token.User_id != user.ID) {
+		return c.JSON(http.StatusNotFound, "Access token not found")
+	}
+	if err != nil {
+		log.Printf("error fetching access token: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	if err := h.TokenRepo.Revoke(token.ID); err != nil {
+		log.Printf("error revoking access token: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
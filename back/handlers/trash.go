@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+)
+
+type TrashHandler struct {
+	TaskRepo              repository.Task
+	WorkspaceRepo         repository.Workspace
+	UserWorkspaceRoleRepo repository.UserWorkspaceRole
+	UserRepo              repository.User
+	WebhookRepo           repository.Webhook
+}
+
+func NewTrashHandler(taskRepo repository.Task, workspaceRepo repository.Workspace, userWorkspaceRoleRepo repository.UserWorkspaceRole, userRepo repository.User, webhookRepo repository.Webhook) *TrashHandler {
+	return &TrashHandler{
+		TaskRepo:              taskRepo,
+		WorkspaceRepo:         workspaceRepo,
+		UserWorkspaceRoleRepo: userWorkspaceRoleRepo,
+		UserRepo:              userRepo,
+		WebhookRepo:           webhookRepo,
+	}
+}
+
+type TrashResponseDTO struct {
+	Workspace *models.Workspace `json:"workspace,omitempty"`
+	Tasks     []*models.Task    `json:"tasks"`
+}
+
+// memberRole looks up the caller's effective role in the workspace, which
+// may be in the trash.
+func (h *TrashHandler) memberRole(c echo.Context, workspaceId uint) (*models.UserWorkspaceRole, error) {
+	authUsername, _ := c.Get("username").(string)
+	user, err := h.UserRepo.FindByUsername(authUsername)
+	if err != nil || user == nil {
+		return nil, err
+	}
+
+	roles, err := h.UserWorkspaceRoleRepo.FindEffectiveByWorkspaceIDUnscoped(workspaceId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, role := range roles {
+		if role.User_id == user.ID {
+			return role, nil
+		}
+	}
+	return nil, nil
+}
+
+func (h *TrashHandler) GetTrash(c echo.Context) error {
+	workspaceId, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	role, err := h.memberRole(c, uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if role == nil {
+		return c.JSON(http.StatusForbidden, "Access denied to the workspace")
+	}
+
+	workspace, err := h.WorkspaceRepo.FindDeletedByID(uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	tasks, err := h.TaskRepo.FindDeletedByWorkspaceID(uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, TrashResponseDTO{Workspace: workspace, Tasks: tasks})
+}
+
+func (h *TrashHandler) RestoreTask(c echo.Context) error {
+	workspaceId, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	taskId, err := strconv.ParseUint(c.Param("taskId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	role, err := h.memberRole(c, uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if role == nil {
+		return c.JSON(http.StatusForbidden, "Access denied to the workspace")
+	}
+
+	workspace, err := h.WorkspaceRepo.FindDeletedByID(uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if workspace != nil {
+		return c.JSON(http.StatusConflict, "Workspace is in the trash, restore it first")
+	}
+
+	tasks, err := h.TaskRepo.FindDeletedByWorkspaceID(uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	found := false
+	for _, task := range tasks {
+		if task.ID == uint(taskId) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return c.JSON(http.StatusNotFound, "Task not found in trash")
+	}
+
+	if err := h.TaskRepo.Restore(uint(taskId)); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	task, err := h.TaskRepo.FindByID(uint(taskId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	emit(c, h.WebhookRepo, task.Workspace_id, repository.EventTaskRestored, task)
+
+	return c.JSON(http.StatusOK, task)
+}
+
+func (h *TrashHandler) RestoreWorkspace(c echo.Context) error {
+	workspaceId, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	role, err := h.memberRole(c, uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if role == nil || role.Role != 1 {
+		return c.JSON(http.StatusForbidden, "Access denied to restore the workspace")
+	}
+
+	workspace, err := h.WorkspaceRepo.FindDeletedByID(uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if workspace == nil {
+		return c.JSON(http.StatusNotFound, "Workspace not found in trash")
+	}
+
+	if err := h.WorkspaceRepo.Restore(workspace.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	workspace, err = h.WorkspaceRepo.FindByID(workspace.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	emit(c, h.WebhookRepo, workspace.ID, repository.EventWorkspaceRestored, workspace)
+
+	return c.JSON(http.StatusOK, workspace)
+}
@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+	"github.com/raeinsoltani/gorello/back/utils"
+)
+
+const recoveryCodeCount = 10
+
+type TwoFactorHandler struct {
+	UserRepo         repository.User
+	RecoveryCodeRepo repository.RecoveryCode
+	LoginGuard       *LoginGuard
+	SessionRepo      repository.Session
+}
+
+func NewTwoFactorHandler(userRepo repository.User, recoveryCodeRepo repository.RecoveryCode, loginGuard *LoginGuard, sessionRepo repository.Session) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		UserRepo:         userRepo,
+		RecoveryCodeRepo: recoveryCodeRepo,
+		LoginGuard:       loginGuard,
+		SessionRepo:      sessionRepo,
+	}
+}
+
+type TwoFactorCodeDTO struct {
+	Code string `json:"code" validate:"required,max=32"`
+}
+
+type TwoFactorLoginDTO struct {
+	Challenge_token string `json:"challenge_token" validate:"required"`
+	Code            string `json:"code" validate:"required,max=32"`
+	Device          string `json:"device"`
+}
+
+type TwoFactorEnrollDTO struct {
+	Secret           string `json:"secret"`
+	Provisioning_uri string `json:"provisioning_uri"`
+}
+
+type TwoFactorStatusDTO struct {
+	Enabled                  bool  `json:"enabled"`
+	Recovery_codes_remaining int64 `json:"recovery_codes_remaining"`
+}
+
+// user loads the user named in the path after checking that it is the
+// caller, logged in rather than using an access token. On failure the
+// response has already been written and the returned user is nil.
+func (h *TwoFactorHandler) user(c echo.Context) (*models.User, error) {
+	username := c.Param("username")
+	authUsername := c.Get("username")
+	if authUsername != username {
+		return nil, c.JSON(http.StatusForbidden, "Access denied")
+	}
+	if c.Get("token_id") != nil {
+		return nil, c.JSON(http.StatusForbidden, "Two-factor authentication cannot be managed with an access token")
+	}
+
+	user, err := h.UserRepo.FindByUsername(username)
+	if err != nil {
+		log.Printf("error finding user: %s", err.Error())
+		return nil, c.NoContent(http.StatusInternalServerError)
+	}
+	if user == nil {
+		return nil, c.JSON(http.StatusNotFound, "User not found")
+	}
+	return user, nil
+}
+
+// checkCode accepts either a current TOTP code or an unused recovery code.
+func (h *TwoFactorHandler) checkCode(user *models.User, code string) (bool, error) {
+	if step, ok := utils.ValidateTOTP(user.Totp_secret, code, user.Totp_last_step); ok {
+		user.Totp_last_step = step
+		return true, h.UserRepo.Update(user)
+	}
+	return h.RecoveryCodeRepo.Use(user.ID, utils.HashRecoveryCode(code))
+}
+
+// recoveryCodes replaces the user's recovery codes and returns the new
+// ones, which are never shown again.
+func (h *TwoFactorHandler) recoveryCodes(user *models.User) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := utils.GenerateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		hashes[i] = utils.HashRecoveryCode(code)
+	}
+	return codes, h.RecoveryCodeRepo.Replace(user.ID, hashes)
+}
+
+func (h *TwoFactorHandler) GetStatus(c echo.Context) error {
+	user, err := h.user(c)
+	if user == nil {
+		return err
+	}
+
+	remaining, err := h.RecoveryCodeRepo.CountUnused(user.ID)
+	if err != nil {
+		log.Printf("error counting recovery codes: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.JSON(http.StatusOK, TwoFactorStatusDTO{Enabled: user.Totp_enabled, Recovery_codes_remaining: remaining})
+}
+
+// Enroll starts enrollment with a fresh secret. 2FA stays off until the
+// first code is verified.
+func (h *TwoFactorHandler) Enroll(c echo.Context) error {
+	user, err := h.user(c)
+	if user == nil {
+		return err
+	}
+
+	if user.Totp_enabled {
+		return c.JSON(http.StatusConflict, "Two-factor authentication is already enabled")
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		log.Printf("error generating TOTP secret: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	user.Totp_secret = secret
+	user.Totp_last_step = 0
+	if err := h.UserRepo.Update(user); err != nil {
+		log.Printf("error updating user: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.JSON(http.StatusOK, TwoFactorEnrollDTO{
+		Secret:           secret,
+		Provisioning_uri: utils.TOTPProvisioningURI(user.Username, secret),
+	})
+}
+
+// Verify enables 2FA once a code from the enrolled secret checks out and
+// returns the recovery codes.
+func (h *TwoFactorHandler) Verify(c echo.Context) error {
+	user, err := h.user(c)
+	if user == nil {
+		return err
+	}
+
+	twoFactorCodeDTO := new(TwoFactorCodeDTO)
+	if err := c.Bind(twoFactorCodeDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(twoFactorCodeDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if user.Totp_enabled {
+		return c.JSON(http.StatusConflict, "Two-factor authentication is already enabled")
+	}
+	if user.Totp_secret == "" {
+		return c.JSON(http.StatusBadRequest, "Enroll before verifying")
+	}
+
+	step, ok := utils.ValidateTOTP(user.Totp_secret, twoFactorCodeDTO.Code, user.Totp_last_step)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "Invalid code")
+	}
+
+	codes, err := h.recoveryCodes(user)
+	if err != nil {
+		log.Printf("error creating recovery codes: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	user.Totp_enabled = true
+	user.Totp_last_step = step
+	if err := h.UserRepo.Update(user); err != nil {
+		log.Printf("error updating user: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.JSON(http.StatusOK, map[string][]string{
+		"recovery_codes": codes,
+	})
+}
+
+// Disable turns 2FA off given a current code or a recovery code.
+func (h *TwoFactorHandler) Disable(c echo.Context) error {
+	user, err := h.user(c)
+	if user == nil {
+		return err
+	}
+
+	twoFactorCodeDTO := new(TwoFactorCodeDTO)
+	if err := c.Bind(twoFactorCodeDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(twoFactorCodeDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if !user.Totp_enabled {
+		return c.JSON(http.StatusConflict, "Two-factor authentication is not enabled")
+	}
+
+	ok, err := h.checkCode(user, twoFactorCodeDTO.Code)
+	if err != nil {
+		log.Printf("error checking code: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "Invalid code")
+	}
+
+	if err := h.RecoveryCodeRepo.DeleteByUserID(user.ID); err != nil {
+		log.Printf("error deleting recovery codes: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	user.Totp_enabled = false
+	user.Totp_secret = ""
+	user.Totp_last_step = 0
+	if err := h.UserRepo.Update(user); err != nil {
+		log.Printf("error updating user: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Login is the second step of a login with 2FA: it trades the challenge
+// token from /auth/login and a code for the JWT.
+func (h *TwoFactorHandler) Login(c echo.Context) error {
+	twoFactorLoginDTO := new(TwoFactorLoginDTO)
+	if err := c.Bind(twoFactorLoginDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(twoFactorLoginDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	username, err := utils.ParseChallengeJWT(twoFactorLoginDTO.Challenge_token)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, "Invalid or expired challenge token")
+	}
+
+	if ok, err := h.LoginGuard.allow(c, username); !ok {
+		return err
+	}
+
+	user, err := h.UserRepo.FindByUsername(username)
+	if err != nil {
+		log.Printf("error finding user: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if user == nil || !user.Totp_enabled {
+		return c.JSON(http.StatusUnauthorized, "Invalid or expired challenge token")
+	}
+
+	ok, err := h.checkCode(user, twoFactorLoginDTO.Code)
+	if errors.Is(err, repository.ErrStaleVersion) {
+		// Another login accepted a code at the same moment.
+		return c.JSON(http.StatusUnauthorized, "Invalid code")
+	}
+	if err != nil {
+		log.Printf("error checking code: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+	if !ok {
+		h.LoginGuard.failed(user.Username)
+		return c.JSON(http.StatusUnauthorized, "Invalid code")
+	}
+
+	token, err := issueToken(c, h.SessionRepo, user, twoFactorLoginDTO.Device)
+	if err != nil {
+		log.Printf("failed to generate token: %s", err.Error())
+		return c.NoContent(http.StatusInternalServerError)
+	}
+
+	h.LoginGuard.succeeded(user.Username)
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"token": token,
+	})
+}
@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"errors"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 	"github.com/raeinsoltani/gorello/back/models"
@@ -12,16 +14,14 @@ import (
 
 type UserHandler struct {
 	UserRepo repository.User
+	// PasswordLogin is false on deployments that only allow SSO.
+	PasswordLogin bool
+	LoginGuard    *LoginGuard
+	SessionRepo   repository.Session
 }
 
-func NewUserHandler(userRepo repository.User) *UserHandler {
-	return &UserHandler{UserRepo: userRepo}
-}
-
-type UserResponseDTO struct {
-	ID        uint   `json:"id"`
-	Firstname string `json:"firstname"`
-	Username  string `json:"username"`
+func NewUserHandler(userRepo repository.User, passwordLogin bool, loginGuard *LoginGuard, sessionRepo repository.Session) *UserHandler {
+	return &UserHandler{UserRepo: userRepo, PasswordLogin: passwordLogin, LoginGuard: loginGuard, SessionRepo: sessionRepo}
 }
 
 type UserRegisterDTO struct {
@@ -38,16 +38,25 @@ type UserUpdateDTO struct {
 type UserLoginDTO struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	// Device optionally names the device in the session list.
+	Device string `json:"device"`
 }
 
-func (h *UserHandler) Register(c echo.Context) error {
+// newUser builds a password account from the request body, without saving
+// it. If email is set, the account must use it. On failure the response has
+// already been written and the returned user is nil.
+func newUser(c echo.Context, userRepo repository.User, email string) (*models.User, error) {
 	userRegisterDTO := new(UserRegisterDTO)
 	if err := c.Bind(userRegisterDTO); err != nil {
-		return c.JSON(http.StatusBadRequest, err.Error())
+		return nil, c.JSON(http.StatusBadRequest, err.Error())
 	}
 
 	if err := c.Validate(userRegisterDTO); err != nil {
-		return c.JSON(http.StatusBadRequest, err.Error())
+		return nil, c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if email != "" && !strings.EqualFold(userRegisterDTO.Email, email) {
+		return nil, c.JSON(http.StatusBadRequest, "Register with the email address the invitation was sent to")
 	}
 
 	user := models.User{
@@ -56,29 +65,61 @@ func (h *UserHandler) Register(c echo.Context) error {
 		Password: utils.HashPassword(userRegisterDTO.Password),
 	}
 
-	existingUser, err := h.UserRepo.FindByUsername(user.Username)
+	existingUser, err := userRepo.FindByUsername(user.Username)
 	if err != nil {
 		log.Printf("error finding user: %s", err.Error())
-		return c.NoContent(http.StatusInternalServerError)
+		return nil, c.NoContent(http.StatusInternalServerError)
 	}
 	if existingUser != nil {
-		return c.JSON(http.StatusBadRequest, "Username already in use")
+		return nil, c.JSON(http.StatusBadRequest, "Username already in use")
+	}
+
+	return &user, nil
+}
+
+// registerUser creates a password account from the request body, like
+// newUser.
+func registerUser(c echo.Context, userRepo repository.User, email string) (*models.User, error) {
+	user, err := newUser(c, userRepo, email)
+	if user == nil {
+		return nil, err
 	}
 
-	if err := h.UserRepo.Create(&user); err != nil {
+	if err := userRepo.Create(user); err != nil {
 		log.Printf("error creating user: %s", err.Error())
-		return c.NoContent(http.StatusInternalServerError)
+		return nil, c.NoContent(http.StatusInternalServerError)
 	}
 
-	return c.JSON(http.StatusCreated, user)
+	return user, nil
+}
+
+func (h *UserHandler) Register(c echo.Context) error {
+	if !h.PasswordLogin {
+		return c.JSON(http.StatusForbidden, "Password accounts are disabled, sign in with SSO")
+	}
+
+	user, err := registerUser(c, h.UserRepo, "")
+	if user == nil {
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, newUserProfileDTO(user))
 }
 
 func (h *UserHandler) Login(c echo.Context) error {
+	if !h.PasswordLogin {
+		return c.JSON(http.StatusForbidden, "Password login is disabled, sign in with SSO")
+	}
+
 	userLoginDTO := new(UserLoginDTO)
 	if err := c.Bind(userLoginDTO); err != nil {
 		return c.JSON(http.StatusBadRequest, err.Error())
 	}
 
+	if ok, err := h.LoginGuard.allow(c, userLoginDTO.Username); !ok {
+		return err
+	}
+
 	user, err := h.UserRepo.FindByUsername(userLoginDTO.Username)
 	if err != nil {
 		log.Printf("error finding user: %s", err.Error())
@@ -86,15 +127,32 @@ func (h *UserHandler) Login(c echo.Context) error {
 	}
 
 	if user == nil || !utils.CheckPasswordHash(userLoginDTO.Password, user.Password) {
+		h.LoginGuard.failed(userLoginDTO.Username)
 		return c.JSON(http.StatusUnauthorized, "Invalid username or password")
 	}
 
-	token, err := utils.GenerateJWT(user.Username)
+	// With 2FA on, the password only earns a challenge token to exchange
+	// for the JWT at /auth/login/2fa together with a code.
+	if user.Totp_enabled {
+		challenge, err := utils.GenerateChallengeJWT(user.Username)
+		if err != nil {
+			log.Printf("failed to generate challenge: %s", err.Error())
+			return c.NoContent(http.StatusInternalServerError)
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+		})
+	}
+
+	token, err := issueToken(c, h.SessionRepo, user, userLoginDTO.Device)
 	if err != nil {
 		log.Printf("failed to generate token: %s", err.Error())
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
+	h.LoginGuard.succeeded(user.Username)
+
 	return c.JSON(http.StatusOK, map[string]string{
 		"token": token,
 	})
@@ -117,22 +175,8 @@ func (h *UserHandler) GetUser(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, "User not found")
 	}
 
-	return c.JSON(http.StatusOK, user)
-}
-
-func (h *UserHandler) DeleteUser(c echo.Context) error {
-	username := c.Param("username")
-	authUsername := c.Get("username")
-	if authUsername != username {
-		return c.JSON(http.StatusForbidden, "Access denied")
-	}
-
-	if err := h.UserRepo.Delete(username); err != nil {
-		log.Printf("error deleting user: %s", err.Error())
-		return c.NoContent(http.StatusInternalServerError)
-	}
-
-	return c.NoContent(http.StatusNoContent)
+	setETag(c, user.Version)
+	return c.JSON(http.StatusOK, newUserProfileDTO(user))
 }
 
 func (h *UserHandler) SearchUsers(c echo.Context) error {
@@ -156,6 +200,9 @@ func (h *UserHandler) UpdateUser(c echo.Context) error {
 	if authUsername != username {
 		return c.JSON(http.StatusForbidden, "Access denied")
 	}
+	if c.Get("token_id") != nil {
+		return c.JSON(http.StatusForbidden, "Accounts cannot be changed with an access token")
+	}
 
 	userUpdateDTO := new(UserUpdateDTO)
 	if err := c.Bind(userUpdateDTO); err != nil {
@@ -171,17 +218,35 @@ func (h *UserHandler) UpdateUser(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, "User not found")
 	}
 
+	if !ifMatch(c, user.Version) {
+		return c.JSON(http.StatusPreconditionFailed, "User was modified by another request")
+	}
+
 	if userUpdateDTO.Password != "" {
 		user.Password = utils.HashPassword(userUpdateDTO.Password)
 	}
 	user.Email = userUpdateDTO.Email
 
-	if err := h.UserRepo.Update(user); err != nil {
+	err = h.UserRepo.Update(user)
+	if errors.Is(err, repository.ErrStaleVersion) {
+		return c.JSON(http.StatusPreconditionFailed, "User was modified by another request")
+	}
+	if err != nil {
 		log.Printf("error updating user: %s", err.Error())
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
-	return c.JSON(http.StatusOK, user)
+	// A new password logs out every other session.
+	if userUpdateDTO.Password != "" {
+		current, _ := c.Get("session_id").(uint)
+		if err := h.SessionRepo.RevokeAllExcept(user.ID, current); err != nil {
+			log.Printf("error revoking sessions: %s", err.Error())
+			return c.NoContent(http.StatusInternalServerError)
+		}
+	}
+
+	setETag(c, user.Version)
+	return c.JSON(http.StatusOK, newUserProfileDTO(user))
 }
 
 func (h *UserHandler) GetUsers(c echo.Context) error {
@@ -191,14 +256,9 @@ func (h *UserHandler) GetUsers(c echo.Context) error {
 		return c.NoContent(http.StatusInternalServerError)
 	}
 
-	userList := make([]map[string]string, 0)
-
+	userList := make([]PublicUserDTO, 0, len(users))
 	for _, user := range users {
-		userData := map[string]string{
-			"username": user.Username,
-			"email":    user.Email,
-		}
-		userList = append(userList, userData)
+		userList = append(userList, newPublicUserDTO(user))
 	}
 
 	return c.JSON(http.StatusOK, userList)
@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+)
+
+type VisibilityHandler struct {
+	WorkspaceRepo         repository.Workspace
+	TaskRepo              repository.Task
+	UserWorkspaceRoleRepo repository.UserWorkspaceRole
+	UserRepo              repository.User
+	WebhookRepo           repository.Webhook
+}
+
+func NewVisibilityHandler(workspaceRepo repository.Workspace, taskRepo repository.Task, userWorkspaceRoleRepo repository.UserWorkspaceRole, userRepo repository.User, webhookRepo repository.Webhook) *VisibilityHandler {
+	return &VisibilityHandler{
+		WorkspaceRepo:         workspaceRepo,
+		TaskRepo:              taskRepo,
+		UserWorkspaceRoleRepo: userWorkspaceRoleRepo,
+		UserRepo:              userRepo,
+		WebhookRepo:           webhookRepo,
+	}
+}
+
+type VisibilityUpdateDTO struct {
+	Visibility string `json:"visibility" validate:"required,oneof=private organization public-link"`
+}
+
+// PublicBoardDTO is the read-only view of a public-link workspace. Members
+// are listed without their emails.
+type PublicBoardDTO struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Members     []MemberDTO    `json:"members"`
+	Tasks       []*models.Task `json:"tasks"`
+}
+
+// publicSlug returns a new unguessable slug for a public board.
+func publicSlug() (string, error) {
+	slug := make([]byte, 16)
+	if _, err := rand.Read(slug); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(slug), nil
+}
+
+// workspace loads the workspace in the path after checking that the caller
+// owns it. On failure the response has already been written and the
+// returned workspace is nil.
+func (h *VisibilityHandler) workspace(c echo.Context) (*models.Workspace, error) {
+	authUsername, ok := c.Get("username").(string)
+	if !ok {
+		return nil, c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	workspaceId, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
+	if err != nil {
+		return nil, c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	role, err := workspaceRole(h.UserRepo, h.UserWorkspaceRoleRepo, authUsername, uint(workspaceId))
+	if err != nil {
+		return nil, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if role == nil || role.Role != 1 {
+		return nil, c.JSON(http.StatusForbidden, "Only workspace owners can change its visibility")
+	}
+
+	workspace, err := h.WorkspaceRepo.FindByID(uint(workspaceId))
+	if err != nil {
+		return nil, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	if !ifMatch(c, workspace.Version) {
+		return nil, c.JSON(http.StatusPreconditionFailed, "Workspace was modified by another request")
+	}
+	return workspace, nil
+}
+
+// update saves the workspace and writes it, or the error.
+func (h *VisibilityHandler) update(c echo.Context, workspace *models.Workspace) error {
+	err := h.WorkspaceRepo.Update(workspace)
+	if errors.Is(err, repository.ErrStaleVersion) {
+		return c.JSON(http.StatusPreconditionFailed, "Workspace was modified by another request")
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	emit(c, h.WebhookRepo, workspace.ID, repository.EventWorkspaceUpdated, workspace)
+
+	setETag(c, workspace.Version)
+	return c.JSON(http.StatusOK, workspace)
+}
+
+// SetVisibility changes who can see the workspace. Making it public-link
+// creates its public board link, if it has none; any other visibility
+// revokes the link.
+func (h *VisibilityHandler) SetVisibility(c echo.Context) error {
+	workspace, err := h.workspace(c)
+	if workspace == nil {
+		return err
+	}
+
+	visibilityUpdateDTO := new(VisibilityUpdateDTO)
+	if err := c.Bind(visibilityUpdateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(visibilityUpdateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	visibility := visibilityUpdateDTO.Visibility
+	if visibility == models.WorkspaceVisibilityOrganization && workspace.Organization_id == nil {
+		return c.JSON(http.StatusBadRequest, "Only workspaces of an organization can be visible to it")
+	}
+
+	workspace.Visibility = visibility
+	if visibility != models.WorkspaceVisibilityPublicLink {
+		workspace.Public_slug = nil
+	} else if workspace.Public_slug == nil {
+		slug, err := publicSlug()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		workspace.Public_slug = &slug
+	}
+
+	return h.update(c, workspace)
+}
+
+// RegeneratePublicLink replaces the public board link, so that the old one
+// stops working.
+func (h *VisibilityHandler) RegeneratePublicLink(c echo.Context) error {
+	workspace, err := h.workspace(c)
+	if workspace == nil {
+		return err
+	}
+
+	if workspace.Visibility != models.WorkspaceVisibilityPublicLink {
+		return c.JSON(http.StatusConflict, "Workspace is not public, set its visibility to public-link first")
+	}
+
+	slug, err := publicSlug()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	workspace.Public_slug = &slug
+
+	return h.update(c, workspace)
+}
+
+// RevokePublicLink turns the public board off and makes the workspace
+// private.
+func (h *VisibilityHandler) RevokePublicLink(c echo.Context) error {
+	workspace, err := h.workspace(c)
+	if workspace == nil {
+		return err
+	}
+
+	if workspace.Visibility != models.WorkspaceVisibilityPublicLink {
+		return c.JSON(http.StatusNotFound, "Workspace has no public link")
+	}
+
+	workspace.Visibility = models.WorkspaceVisibilityPrivate
+	workspace.Public_slug = nil
+
+	return h.update(c, workspace)
+}
+
+// GetPublicBoard serves the read-only view of a public-link workspace. It
+// needs no authentication.
+func (h *VisibilityHandler) GetPublicBoard(c echo.Context) error {
+	workspace, err := h.WorkspaceRepo.FindByPublicSlug(c.Param("slug"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if workspace == nil {
+		return c.JSON(http.StatusNotFound, "Board not found")
+	}
+
+	tasks, err := h.TaskRepo.FindByWorkspaceIDWithDetails(workspace.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	roles, err := h.UserWorkspaceRoleRepo.FindByWorkspaceID(workspace.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	members := make([]MemberDTO, 0, len(roles))
+	for _, role := range roles {
+		user, err := h.UserRepo.FindByID(role.User_id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		members = append(members, MemberDTO{PublicUserDTO: newPublicUserDTO(user), Role: role.Role})
+	}
+
+	c.Response().Header().Set("X-Robots-Tag", "noindex")
+	return c.JSON(http.StatusOK, PublicBoardDTO{
+		Name:        workspace.Name,
+		Description: workspace.Description,
+		Members:     members,
+		Tasks:       tasks,
+	})
+}
@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+	"github.com/raeinsoltani/gorello/back/webhook"
+	"gorm.io/gorm"
+)
+
+type EventPayloadDTO struct {
+	Event        string      `json:"event"`
+	Workspace_id uint        `json:"workspace_id"`
+	Actor        string      `json:"actor,omitempty"`
+	Occurred_at  time.Time   `json:"occurred_at"`
+	Data         interface{} `json:"data"`
+}
+
+// emit queues an event for the workspace's webhooks. A failure to queue is
+// logged and doesn't fail the request that caused the event.
+func emit(c echo.Context, webhookRepo repository.Webhook, workspaceId uint, event string, data interface{}) {
+	actor, _ := c.Get("username").(string)
+	payload, err := json.Marshal(EventPayloadDTO{
+		Event:        event,
+		Workspace_id: workspaceId,
+		Actor:        actor,
+		Occurred_at:  time.Now().UTC(),
+		Data:         data,
+	})
+	if err != nil {
+		log.Printf("error encoding %s event: %s", event, err.Error())
+		return
+	}
+
+	if err := webhookRepo.Enqueue(workspaceId, event, payload); err != nil {
+		log.Printf("error queueing %s event: %s", event, err.Error())
+	}
+}
+
+type WebhookHandler struct {
+	WebhookRepo           repository.Webhook
+	UserWorkspaceRoleRepo repository.UserWorkspaceRole
+	UserRepo              repository.User
+	Sender                *webhook.Sender
+}
+
+func NewWebhookHandler(webhookRepo repository.Webhook, userWorkspaceRoleRepo repository.UserWorkspaceRole, userRepo repository.User, sender *webhook.Sender) *WebhookHandler {
+	return &WebhookHandler{
+		WebhookRepo:           webhookRepo,
+		UserWorkspaceRoleRepo: userWorkspaceRoleRepo,
+		UserRepo:              userRepo,
+		Sender:                sender,
+	}
+}
+
+// WebhookCreateDTO's Events is a comma separated list of event names or
+// patterns such as "task.*", each of which must match an event. It
+// defaults to "*".
+type WebhookCreateDTO struct {
+	Url    string `json:"url" validate:"required,url,max=2048"`
+	Secret string `json:"secret" validate:"omitempty,min=16,max=100"`
+	Events string `json:"events" validate:"max=1000"`
+	Active *bool  `json:"active"`
+}
+
+// WebhookSecretDTO is returned on creation, the only time the secret is
+// shown.
+type WebhookSecretDTO struct {
+	*models.Webhook
+	Secret string `json:"secret"`
+}
+
+// webhook loads the webhook named in the path after checking that the
+// caller owns its workspace. On failure the response has already been
+// written and the returned webhook is nil.
+func (h *WebhookHandler) webhook(c echo.Context) (*models.Webhook, error) {
+	authUsername, ok := c.Get("username").(string)
+	if !ok {
+		return nil, c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	workspaceId, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
+	if err != nil {
+		return nil, c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	role, err := workspaceRole(h.UserRepo, h.UserWorkspaceRoleRepo, authUsername, uint(workspaceId))
+	if err != nil {
+		return nil, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if role == nil || role.Role != 1 {
+		return nil, c.JSON(http.StatusForbidden, "Only workspace owners can manage webhooks")
+	}
+
+	if c.Param("webhookId") == "" {
+		return &models.Webhook{Workspace_id: uint(workspaceId)}, nil
+	}
+
+	webhookId, err := strconv.ParseUint(c.Param("webhookId"), 10, 64)
+	if err != nil {
+		return nil, c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	hook, err := h.WebhookRepo.FindByID(uint(webhookId))
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && hook.Workspace_id != uint(workspaceId)) {
+		return nil, c.JSON(http.StatusNotFound, "Webhook not found")
+	}
+	if err != nil {
+		return nil, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	return hook, nil
+}
+
+// checkEvents checks that every pattern of a webhook's event filter matches
+// at least one event, so that a webhook doesn't silently receive nothing.
+func checkEvents(events string) error {
+	for _, pattern := range strings.Split(events, ",") {
+		pattern = strings.TrimSpace(pattern)
+		matches := false
+		for _, event := range repository.Events {
+			if matched, _ := path.Match(pattern, event); matched {
+				matches = true
+				break
+			}
+		}
+		if !matches {
+			return fmt.Errorf("%q matches no event", pattern)
+		}
+	}
+	return nil
+}
+
+func (h *WebhookHandler) GetWebhooks(c echo.Context) error {
+	hook, err := h.webhook(c)
+	if hook == nil {
+		return err
+	}
+
+	webhooks, err := h.WebhookRepo.FindByWorkspaceID(hook.Workspace_id)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, webhooks)
+}
+
+func (h *WebhookHandler) CreateWebhook(c echo.Context) error {
+	hook, err := h.webhook(c)
+	if hook == nil {
+		return err
+	}
+
+	webhookCreateDTO := new(WebhookCreateDTO)
+	if err := c.Bind(webhookCreateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(webhookCreateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	hook.Url = webhookCreateDTO.Url
+	hook.Events = webhookCreateDTO.Events
+	if strings.TrimSpace(hook.Events) == "" {
+		hook.Events = "*"
+	}
+	if err := checkEvents(hook.Events); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+	hook.Active = webhookCreateDTO.Active == nil || *webhookCreateDTO.Active
+	hook.Secret = webhookCreateDTO.Secret
+	if hook.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		hook.Secret = hex.EncodeToString(secret)
+	}
+
+	if err := h.WebhookRepo.Create(hook); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusCreated, WebhookSecretDTO{Webhook: hook, Secret: hook.Secret})
+}
+
+func (h *WebhookHandler) GetWebhook(c echo.Context) error {
+	hook, err := h.webhook(c)
+	if hook == nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, hook)
+}
+
+func (h *WebhookHandler) UpdateWebhook(c echo.Context) error {
+	hook, err := h.webhook(c)
+	if hook == nil {
+		return err
+	}
+
+	webhookUpdateDTO := new(WebhookCreateDTO)
+	if err := c.Bind(webhookUpdateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(webhookUpdateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	hook.Url = webhookUpdateDTO.Url
+	if strings.TrimSpace(webhookUpdateDTO.Events) != "" {
+		if err := checkEvents(webhookUpdateDTO.Events); err != nil {
+			return c.JSON(http.StatusBadRequest, err.Error())
+		}
+		hook.Events = webhookUpdateDTO.Events
+	}
+	if webhookUpdateDTO.Secret != "" {
+		hook.Secret = webhookUpdateDTO.Secret
+	}
+	if webhookUpdateDTO.Active != nil {
+		hook.Active = *webhookUpdateDTO.Active
+	}
+
+	if err := h.WebhookRepo.Update(hook); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, hook)
+}
+
+func (h *WebhookHandler) DeleteWebhook(c echo.Context) error {
+	hook, err := h.webhook(c)
+	if hook == nil {
+		return err
+	}
+
+	if err := h.WebhookRepo.Delete(hook.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *WebhookHandler) GetDeliveries(c echo.Context) error {
+	hook, err := h.webhook(c)
+	if hook == nil {
+		return err
+	}
+
+	limit, err := strconv.Atoi(c.QueryParam("limit"))
+	if err != nil || limit <= 0 || limit > 100 {
+		limit = 50
+	}
+
+	deliveries, err := h.WebhookRepo.FindDeliveriesByWebhookID(hook.ID, limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, deliveries)
+}
+
+// Redeliver queues a fresh copy of an earlier delivery, whatever its
+// outcome was.
+func (h *WebhookHandler) Redeliver(c echo.Context) error {
+	hook, err := h.webhook(c)
+	if hook == nil {
+		return err
+	}
+
+	deliveryId, err := strconv.ParseUint(c.Param("deliveryId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	original, err := h.WebhookRepo.FindDeliveryByID(uint(deliveryId))
+	if err != nil || original.Webhook_id != hook.ID {
+		return c.JSON(http.StatusNotFound, "Delivery not found")
+	}
+
+	delivery := &models.WebhookDelivery{
+		Webhook_id:      hook.ID,
+		Event:           original.Event,
+		Payload:         original.Payload,
+		Status:          models.WebhookDeliveryPending,
+		Next_attempt_at: time.Now(),
+	}
+	if err := h.WebhookRepo.CreateDelivery(delivery); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusAccepted, delivery)
+}
+
+// Ping sends a ping event right away and returns the resulting delivery,
+// which makes it easy to check a receiver and its signature handling.
+func (h *WebhookHandler) Ping(c echo.Context) error {
+	hook, err := h.webhook(c)
+	if hook == nil {
+		return err
+	}
+
+	actor, _ := c.Get("username").(string)
+	payload, err := json.Marshal(EventPayloadDTO{
+		Event:        repository.EventPing,
+		Workspace_id: hook.Workspace_id,
+		Actor:        actor,
+		Occurred_at:  time.Now().UTC(),
+		Data:         map[string]uint{"webhook_id": hook.ID},
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	delivery := &models.WebhookDelivery{
+		Webhook_id:      hook.ID,
+		Event:           repository.EventPing,
+		Payload:         string(payload),
+		Status:          models.WebhookDeliveryPending,
+		Next_attempt_at: time.Now().Add(time.Minute),
+	}
+	if err := h.WebhookRepo.CreateDelivery(delivery); err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	h.Sender.Deliver(delivery)
+	return c.JSON(http.StatusOK, delivery)
+}
@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+)
+
+type WorkflowHandler struct {
+	WorkflowRepo          repository.Workflow
+	UserWorkspaceRoleRepo repository.UserWorkspaceRole
+	UserRepo              repository.User
+}
+
+func NewWorkflowHandler(workflowRepo repository.Workflow, userWorkspaceRoleRepo repository.UserWorkspaceRole, userRepo repository.User) *WorkflowHandler {
+	return &WorkflowHandler{
+		WorkflowRepo:          workflowRepo,
+		UserWorkspaceRoleRepo: userWorkspaceRoleRepo,
+		UserRepo:              userRepo,
+	}
+}
+
+type WorkflowStatusDTO struct {
+	Value    uint   `json:"value"`
+	Name     string `json:"name" validate:"required,max=50"`
+	Category string `json:"category" validate:"required,oneof=todo in_progress done"`
+	Color    string `json:"color" validate:"max=20"`
+	// Allowed_from lists the statuses a task can move to this one from; if
+	// empty it can come from any.
+	Allowed_from []uint `json:"allowed_from" validate:"max=50"`
+}
+
+type WorkflowPriorityDTO struct {
+	Value uint   `json:"value"`
+	Name  string `json:"name" validate:"required,max=50"`
+	Color string `json:"color" validate:"max=20"`
+}
+
+// WorkflowUpdateDTO replaces a workspace's statuses and priorities, each
+// in the order given. An empty list goes back to the default.
+type WorkflowUpdateDTO struct {
+	Statuses   []WorkflowStatusDTO   `json:"statuses" validate:"max=50,dive"`
+	Priorities []WorkflowPriorityDTO `json:"priorities" validate:"max=50,dive"`
+}
+
+type WorkflowDTO struct {
+	Statuses   []*models.WorkflowStatus   `json:"statuses"`
+	Priorities []*models.WorkflowPriority `json:"priorities"`
+}
+
+// workflow is what a workspace's task statuses and priorities are checked
+// against. Workspaces without statuses or priorities of their own accept
+// any.
+type workflow struct {
+	statuses         []*models.WorkflowStatus
+	priorities       []*models.WorkflowPriority
+	customStatuses   bool
+	customPriorities bool
+}
+
+func loadWorkflow(workflowRepo repository.Workflow, workspaceId uint) (*workflow, error) {
+	statuses, err := workflowRepo.FindStatuses(workspaceId)
+	if err != nil {
+		return nil, err
+	}
+	priorities, err := workflowRepo.FindPriorities(workspaceId)
+	if err != nil {
+		return nil, err
+	}
+
+	return newWorkflow(statuses, priorities), nil
+}
+
+// newWorkflow returns the workflow of a workspace with the statuses and
+// priorities.
+func newWorkflow(statuses []*models.WorkflowStatus, priorities []*models.WorkflowPriority) *workflow {
+	w := &workflow{
+		statuses:         statuses,
+		priorities:       priorities,
+		customStatuses:   len(statuses) > 0,
+		customPriorities: len(priorities) > 0,
+	}
+	if !w.customStatuses {
+		w.statuses = models.DefaultWorkflowStatuses()
+	}
+	return w
+}
+
+// checkWorkflow checks that statuses and priorities can be a workspace's
+// workflow: no status or priority is listed twice, transitions come from
+// listed statuses, and there is at least one todo and one done status.
+func checkWorkflow(statuses []*models.WorkflowStatus, priorities []*models.WorkflowPriority) error {
+	values := make(map[uint]bool)
+	names := make(map[string]bool)
+	categories := make(map[string]bool)
+	for _, status := range statuses {
+		if values[status.Value] || names[status.Name] {
+			return fmt.Errorf("status %q or its value %d is listed twice", status.Name, status.Value)
+		}
+		values[status.Value] = true
+		names[status.Name] = true
+		categories[status.Category] = true
+	}
+	for _, status := range statuses {
+		for _, from := range status.Allowed_from {
+			if !values[from] {
+				return fmt.Errorf("status %q allows moves from %d, which is not a status", status.Name, from)
+			}
+		}
+	}
+	if len(statuses) > 0 && (!categories[models.StatusCategoryTodo] || !categories[models.StatusCategoryDone]) {
+		return fmt.Errorf("the workflow needs at least one todo and one done status")
+	}
+
+	values = make(map[uint]bool)
+	names = make(map[string]bool)
+	for _, priority := range priorities {
+		if values[priority.Value] || names[priority.Name] {
+			return fmt.Errorf("priority %q or its value %d is listed twice", priority.Name, priority.Value)
+		}
+		values[priority.Value] = true
+		names[priority.Name] = true
+	}
+	return nil
+}
+
+func (w *workflow) status(value uint) *models.WorkflowStatus {
+	for _, status := range w.statuses {
+		if status.Value == value {
+			return status
+		}
+	}
+	return nil
+}
+
+// statusName names the status for error messages.
+func (w *workflow) statusName(value uint) string {
+	if status := w.status(value); status != nil {
+		return strconv.Quote(status.Name)
+	}
+	return strconv.FormatUint(uint64(value), 10)
+}
+
+// isDone reports whether the status is in the done category.
+func (w *workflow) isDone(value uint) bool {
+	status := w.status(value)
+	return status != nil && status.Category == models.StatusCategoryDone
+}
+
+// initialStatus returns the status a task is created with when it asks for
+// value: value itself, unless it is the zero value and the workspace has no
+// such status, in which case the first todo status.
+func (w *workflow) initialStatus(value uint) uint {
+	if value != 0 || w.status(value) != nil {
+		return value
+	}
+	for _, status := range w.statuses {
+		if status.Category == models.StatusCategoryTodo {
+			return status.Value
+		}
+	}
+	return value
+}
+
+func (w *workflow) checkStatus(value uint) error {
+	if w.customStatuses && w.status(value) == nil {
+		return fmt.Errorf("%d is not a status of the workspace", value)
+	}
+	return nil
+}
+
+func (w *workflow) checkPriority(value uint) error {
+	if !w.customPriorities {
+		return nil
+	}
+	for _, priority := range w.priorities {
+		if priority.Value == value {
+			return nil
+		}
+	}
+	return fmt.Errorf("%d is not a priority of the workspace", value)
+}
+
+// checkTransition checks that a task may move between the statuses.
+func (w *workflow) checkTransition(from, to uint) error {
+	if err := w.checkStatus(to); err != nil {
+		return err
+	}
+	status := w.status(to)
+	if from == to || status == nil || len(status.Allowed_from) == 0 {
+		return nil
+	}
+	for _, allowed := range status.Allowed_from {
+		if allowed == from {
+			return nil
+		}
+	}
+	return fmt.Errorf("tasks can't move to %s from %s", w.statusName(to), w.statusName(from))
+}
+
+// workspace checks the caller's access to the workspace in the path, which
+// must be owner access if owner is set, and returns its id. On failure the
+// response has already been written and the id is 0.
+func (h *WorkflowHandler) workspace(c echo.Context, owner bool) (uint, error) {
+	authUsername, ok := c.Get("username").(string)
+	if !ok {
+		return 0, c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	workspaceId, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
+	if err != nil {
+		return 0, c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	role, err := workspaceRole(h.UserRepo, h.UserWorkspaceRoleRepo, authUsername, uint(workspaceId))
+	if err != nil {
+		return 0, c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if role == nil {
+		return 0, c.JSON(http.StatusForbidden, "Access denied to the workspace")
+	}
+	if owner && role.Role != 1 {
+		return 0, c.JSON(http.StatusForbidden, "Only workspace owners can change its workflow")
+	}
+	return uint(workspaceId), nil
+}
+
+// GetWorkflow returns the workspace's statuses, the default ones if it has
+// none of its own, and its priorities, which are empty if it accepts any.
+func (h *WorkflowHandler) GetWorkflow(c echo.Context) error {
+	workspaceId, err := h.workspace(c, false)
+	if workspaceId == 0 {
+		return err
+	}
+
+	w, err := loadWorkflow(h.WorkflowRepo, workspaceId)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, WorkflowDTO{Statuses: w.statuses, Priorities: w.priorities})
+}
+
+// UpdateWorkflow replaces the workspace's statuses and priorities. Statuses
+// need at least one todo and one done status, and none that tasks still
+// have can be removed.
+func (h *WorkflowHandler) UpdateWorkflow(c echo.Context) error {
+	workspaceId, err := h.workspace(c, true)
+	if workspaceId == 0 {
+		return err
+	}
+
+	workflowUpdateDTO := new(WorkflowUpdateDTO)
+	if err := c.Bind(workflowUpdateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	if err := c.Validate(workflowUpdateDTO); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	statuses := make([]*models.WorkflowStatus, 0, len(workflowUpdateDTO.Statuses))
+	for _, status := range workflowUpdateDTO.Statuses {
+		statuses = append(statuses, &models.WorkflowStatus{
+			Value:        status.Value,
+			Name:         status.Name,
+			Category:     status.Category,
+			Color:        status.Color,
+			Allowed_from: status.Allowed_from,
+		})
+	}
+
+	priorities := make([]*models.WorkflowPriority, 0, len(workflowUpdateDTO.Priorities))
+	for _, priority := range workflowUpdateDTO.Priorities {
+		priorities = append(priorities, &models.WorkflowPriority{
+			Value: priority.Value,
+			Name:  priority.Name,
+			Color: priority.Color,
+		})
+	}
+
+	if err := checkWorkflow(statuses, priorities); err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	err = h.WorkflowRepo.Replace(workspaceId, statuses, priorities)
+	if errors.Is(err, repository.ErrWorkflowInUse) {
+		return c.JSON(http.StatusConflict, err.Error())
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	w, err := loadWorkflow(h.WorkflowRepo, workspaceId)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, WorkflowDTO{Statuses: w.statuses, Priorities: w.priorities})
+}
@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/raeinsoltani/gorello/back/models"
@@ -13,19 +15,31 @@ type WorkspaceHandler struct {
 	WorkspaceRepo         repository.Workspace
 	UserWorkspaceRoleRepo repository.UserWorkspaceRole
 	UserRepo              repository.User
+	WebhookRepo           repository.Webhook
+	OrganizationRepo      repository.Organization
+	TemplateRepo          repository.WorkspaceTemplate
 }
 
-func NewWorkspaceHandler(workspaceRepo repository.Workspace, userWorkspaceRoleRepo repository.UserWorkspaceRole, userRepo repository.User) *WorkspaceHandler {
+func NewWorkspaceHandler(workspaceRepo repository.Workspace, userWorkspaceRoleRepo repository.UserWorkspaceRole, userRepo repository.User, webhookRepo repository.Webhook, organizationRepo repository.Organization, templateRepo repository.WorkspaceTemplate) *WorkspaceHandler {
 	return &WorkspaceHandler{
 		WorkspaceRepo:         workspaceRepo,
 		UserWorkspaceRoleRepo: userWorkspaceRoleRepo,
 		UserRepo:              userRepo,
+		WebhookRepo:           webhookRepo,
+		OrganizationRepo:      organizationRepo,
+		TemplateRepo:          templateRepo,
 	}
 }
 
 type WorkspaceCreateDTO struct {
 	Name        string `json:"name"`
 	Description string `json:"description"`
+	// Organization_id creates the workspace in an organization the caller
+	// is a member of. It is ignored on update.
+	Organization_id *uint `json:"organization_id"`
+	// Template_id fills the new workspace with a template's labels, tasks
+	// and workflow. It is ignored on update.
+	Template_id uint `json:"template_id"`
 }
 
 func (h *WorkspaceHandler) CreateWorkspace(c echo.Context) error {
@@ -43,31 +57,53 @@ func (h *WorkspaceHandler) CreateWorkspace(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, err.Error())
 	}
 
-	workspace := models.Workspace{
-		Name:        WorkspaceCreateDTO.Name,
-		Description: WorkspaceCreateDTO.Description,
-	}
-
-	err := h.WorkspaceRepo.Create(&workspace)
+	user, err := h.UserRepo.FindByUsername(authUsername)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
 
-	user, err := h.UserRepo.FindByUsername(authUsername)
-	userWorkspaceRole := models.UserWorkspaceRole{
-		User_id:      user.ID,
-		Workspace_id: workspace.ID,
-		Role:         1,
+	if organizationId := WorkspaceCreateDTO.Organization_id; organizationId != nil {
+		member, err := h.OrganizationRepo.FindMember(*organizationId, user.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		if member == nil {
+			return c.JSON(http.StatusForbidden, "Access denied to the organization")
+		}
 	}
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, err.Error())
+
+	var content repository.WorkspaceContent
+	if templateId := WorkspaceCreateDTO.Template_id; templateId != 0 {
+		template, err := findTemplate(h.TemplateRepo, templateId, user)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, err.Error())
+		}
+		if template == nil {
+			return c.JSON(http.StatusNotFound, "Template not found")
+		}
+		content = templateWorkspace(template.Content)
 	}
 
-	err = h.UserWorkspaceRoleRepo.Create(&userWorkspaceRole)
+	workspace := models.Workspace{
+		Name:            WorkspaceCreateDTO.Name,
+		Description:     WorkspaceCreateDTO.Description,
+		Organization_id: WorkspaceCreateDTO.Organization_id,
+	}
+
+	userWorkspaceRole := models.UserWorkspaceRole{
+		User_id: user.ID,
+		Role:    1,
+	}
+
+	content.Roles = []*models.UserWorkspaceRole{&userWorkspaceRole}
+	err = h.WorkspaceRepo.Import(&workspace, content)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
 
+	emit(c, h.WebhookRepo, workspace.ID, repository.EventWorkspaceCreated, workspace)
+	emit(c, h.WebhookRepo, workspace.ID, repository.EventMemberAdded, userWorkspaceRole)
+
 	return c.JSON(http.StatusCreated, userWorkspaceRole)
 }
 
@@ -82,7 +118,16 @@ func (h *WorkspaceHandler) GetWorkspaces(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
 
-	userWorkspaceRoles, err := h.UserWorkspaceRoleRepo.FindByUserID(user.ID)
+	// Archived workspaces are only listed with archived=true, and then
+	// only they are.
+	archived := false
+	if value := c.QueryParam("archived"); value != "" {
+		if archived, err = strconv.ParseBool(value); err != nil {
+			return c.JSON(http.StatusBadRequest, "invalid archived: "+value)
+		}
+	}
+
+	userWorkspaceRoles, err := h.UserWorkspaceRoleRepo.FindEffectiveByUserID(user.ID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
@@ -93,6 +138,9 @@ func (h *WorkspaceHandler) GetWorkspaces(c echo.Context) error {
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, err.Error())
 		}
+		if (workspace.Archived_at != nil) != archived {
+			continue
+		}
 		workspaces = append(workspaces, *workspace)
 	}
 
@@ -115,7 +163,7 @@ func (h *WorkspaceHandler) GetWorkspaceDescription(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
 
-	userWorkspaceRoles, err := h.UserWorkspaceRoleRepo.FindByUserID(user.ID)
+	userWorkspaceRoles, err := h.UserWorkspaceRoleRepo.FindEffectiveByUserID(user.ID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
@@ -126,6 +174,7 @@ func (h *WorkspaceHandler) GetWorkspaceDescription(c echo.Context) error {
 			if err != nil {
 				return c.JSON(http.StatusInternalServerError, err.Error())
 			}
+			setETag(c, workspace.Version)
 			return c.JSON(http.StatusOK, workspace)
 		}
 	}
@@ -153,6 +202,10 @@ func (h *WorkspaceHandler) UpdateWorkspace(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, "Workspace not found")
 	}
 
+	if !ifMatch(c, workspace.Version) {
+		return c.JSON(http.StatusPreconditionFailed, "Workspace was modified by another request")
+	}
+
 	workspaceUpdateDTO := new(WorkspaceCreateDTO)
 	if err := c.Bind(workspaceUpdateDTO); err != nil {
 		return c.JSON(http.StatusBadRequest, err.Error())
@@ -170,15 +223,21 @@ func (h *WorkspaceHandler) UpdateWorkspace(c echo.Context) error {
 	}
 
 	err = h.WorkspaceRepo.Update(workspace)
+	if errors.Is(err, repository.ErrStaleVersion) {
+		return c.JSON(http.StatusPreconditionFailed, "Workspace was modified by another request")
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
 
+	emit(c, h.WebhookRepo, workspace.ID, repository.EventWorkspaceUpdated, workspace)
+
+	setETag(c, workspace.Version)
 	return c.JSON(http.StatusOK, workspace)
 }
 
 func (h *WorkspaceHandler) DeleteWorkspace(c echo.Context) error {
-	_, ok := c.Get("username").(string)
+	authUsername, ok := c.Get("username").(string)
 	if !ok {
 		return c.JSON(http.StatusUnauthorized, "User not authenticated")
 	}
@@ -197,25 +256,96 @@ func (h *WorkspaceHandler) DeleteWorkspace(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, "Workspace not found")
 	}
 
-	roles, err := h.UserWorkspaceRoleRepo.FindByWorkspaceID(uint(workspaceId))
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, err.Error())
+	if !ifMatch(c, workspace.Version) {
+		return c.JSON(http.StatusPreconditionFailed, "Workspace was modified by another request")
 	}
 
-	flag := false
-	for _, role := range roles {
-		if role.Role == 1 {
-			flag = true
-		}
+	role, err := workspaceRole(h.UserRepo, h.UserWorkspaceRoleRepo, authUsername, uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
-	if !flag {
+	if role == nil || role.Role != 1 {
 		return c.JSON(http.StatusForbidden, "Access denied to delete the workspace")
 	}
 
-	err = h.WorkspaceRepo.Delete(workspace.ID)
+	err = h.WorkspaceRepo.Delete(workspace.ID, workspace.Version)
+	if errors.Is(err, repository.ErrStaleVersion) {
+		return c.JSON(http.StatusPreconditionFailed, "Workspace was modified by another request")
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, err.Error())
 	}
 
+	// The workspace's webhooks stay until it is purged, so they still
+	// receive this.
+	emit(c, h.WebhookRepo, workspace.ID, repository.EventWorkspaceDeleted, workspace)
+
 	return c.JSON(http.StatusOK, "Workspace deleted successfully")
 }
+
+// ArchiveWorkspace makes the workspace read-only and hides it from the
+// default workspace listing. It can still be read, searched and exported.
+func (h *WorkspaceHandler) ArchiveWorkspace(c echo.Context) error {
+	return h.setArchived(c, true)
+}
+
+func (h *WorkspaceHandler) UnarchiveWorkspace(c echo.Context) error {
+	return h.setArchived(c, false)
+}
+
+func (h *WorkspaceHandler) setArchived(c echo.Context, archived bool) error {
+	authUsername, ok := c.Get("username").(string)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, "User not authenticated")
+	}
+
+	workspaceId, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, err.Error())
+	}
+
+	role, err := workspaceRole(h.UserRepo, h.UserWorkspaceRoleRepo, authUsername, uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+	if role == nil || role.Role != 1 {
+		return c.JSON(http.StatusForbidden, "Only workspace owners can archive it")
+	}
+
+	workspace, err := h.WorkspaceRepo.FindByID(uint(workspaceId))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	if !ifMatch(c, workspace.Version) {
+		return c.JSON(http.StatusPreconditionFailed, "Workspace was modified by another request")
+	}
+
+	event := repository.EventWorkspaceUnarchived
+	if archived {
+		if workspace.Archived_at != nil {
+			return c.JSON(http.StatusConflict, "Workspace is already archived")
+		}
+		now := time.Now()
+		workspace.Archived_at = &now
+		event = repository.EventWorkspaceArchived
+	} else {
+		if workspace.Archived_at == nil {
+			return c.JSON(http.StatusConflict, "Workspace is not archived")
+		}
+		workspace.Archived_at = nil
+	}
+
+	err = h.WorkspaceRepo.Update(workspace)
+	if errors.Is(err, repository.ErrStaleVersion) {
+		return c.JSON(http.StatusPreconditionFailed, "Workspace was modified by another request")
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, err.Error())
+	}
+
+	emit(c, h.WebhookRepo, workspace.ID, event, workspace)
+
+	setETag(c, workspace.Version)
+	return c.JSON(http.StatusOK, workspace)
+}
@@ -3,7 +3,11 @@ package main
 import (
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-playground/validator/v10"
@@ -14,7 +18,12 @@ import (
 	"github.com/raeinsoltani/gorello/back/db"
 	"github.com/raeinsoltani/gorello/back/handlers"
 	customMiddleware "github.com/raeinsoltani/gorello/back/middleware"
+	"github.com/raeinsoltani/gorello/back/oidc"
+	"github.com/raeinsoltani/gorello/back/repository"
 	"github.com/raeinsoltani/gorello/back/repository/gorm"
+	"github.com/raeinsoltani/gorello/back/repository/memory"
+	"github.com/raeinsoltani/gorello/back/templates"
+	"github.com/raeinsoltani/gorello/back/webhook"
 )
 
 type CustomValidator struct {
@@ -31,6 +40,7 @@ func main() {
 	e := echo.New()
 
 	e.Validator = &CustomValidator{validator: validator.New()}
+	e.IPExtractor = ipExtractor()
 
 	c := jaegertracing.New(e, nil)
 	defer func(c io.Closer) {
@@ -56,9 +66,80 @@ func main() {
 	workspaceRepo := gorm.NewWorkspaceRepo(db.DB)
 	taskRepo := gorm.NewTaskRepo(db.DB)
 	userWorkspaceRoleRepo := gorm.NewUserWorkspaceRoleRepo(db.DB)
+	labelRepo := gorm.NewLabelRepo(db.DB)
+	searchRepo := gorm.NewSearchRepo(db.DB)
+	taskLinkRepo := gorm.NewTaskLinkRepo(db.DB)
+	reportRepo := gorm.NewReportRepo(db.DB)
+	sprintRepo := gorm.NewSprintRepo(db.DB)
+	webhookRepo := gorm.NewWebhookRepo(db.DB)
+	tokenRepo := gorm.NewPersonalAccessTokenRepo(db.DB)
+	recoveryCodeRepo := gorm.NewRecoveryCodeRepo(db.DB)
+	sessionRepo := gorm.NewSessionRepo(db.DB)
+	avatarRepo := gorm.NewAvatarRepo(db.DB)
+	invitationRepo := gorm.NewInvitationRepo(db.DB)
+	organizationRepo := gorm.NewOrganizationRepo(db.DB)
+	teamRepo := gorm.NewTeamRepo(db.DB)
+	templateRepo := gorm.NewWorkspaceTemplateRepo(db.DB)
+	customFieldRepo := gorm.NewCustomFieldRepo(db.DB)
+	workflowRepo := gorm.NewWorkflowRepo(db.DB)
 
-	userHandler := handlers.NewUserHandler(userRepo)
-	workspaceHandler := handlers.NewWorkspaceHandler(workspaceRepo, userWorkspaceRoleRepo, userRepo)
+	if err := templateRepo.SaveBuiltIn(templates.BuiltIn()); err != nil {
+		log.Printf("Failed to save built-in templates: %v\n", err)
+	}
+
+	var rateLimitRepo repository.RateLimit = memory.NewRateLimitRepo()
+	if os.Getenv("RATE_LIMIT_STORE") == "postgres" {
+		rateLimitRepo = gorm.NewRateLimitRepo(db.DB)
+	}
+	loginLimit := rateLimitPolicy("RATE_LIMIT_LOGIN", repository.RateLimitPolicy{Events: 10, Per: time.Minute})
+	signupLimit := rateLimitPolicy("RATE_LIMIT_SIGNUP", repository.RateLimitPolicy{Events: 5, Per: 10 * time.Minute})
+	accountLimit := rateLimitPolicy("RATE_LIMIT_ACCOUNT", repository.RateLimitPolicy{Events: 5, Per: time.Minute})
+	apiLimit := rateLimitPolicy("RATE_LIMIT_API", repository.RateLimitPolicy{Events: 600, Per: time.Minute})
+	lockout := lockoutPolicy()
+
+	go purgeTrash(taskRepo, workspaceRepo, trashRetention())
+
+	webhookSender := webhook.NewSender(webhookRepo)
+	go webhookSender.Run()
+
+	loginGuard := handlers.NewLoginGuard(rateLimitRepo, accountLimit, lockout)
+
+	userHandler := handlers.NewUserHandler(userRepo, passwordLogin(), loginGuard, sessionRepo)
+	invitationHandler := handlers.NewInvitationHandler(invitationRepo, workspaceRepo, userWorkspaceRoleRepo, userRepo, webhookRepo, passwordLogin())
+	workspaceHandler := handlers.NewWorkspaceHandler(workspaceRepo, userWorkspaceRoleRepo, userRepo, webhookRepo, organizationRepo, templateRepo)
+	taskHandler := handlers.NewTaskHandler(taskRepo, taskLinkRepo, userWorkspaceRoleRepo, userRepo, webhookRepo, customFieldRepo, workflowRepo)
+	trashHandler := handlers.NewTrashHandler(taskRepo, workspaceRepo, userWorkspaceRoleRepo, userRepo, webhookRepo)
+	archiveHandler := handlers.NewArchiveHandler(workspaceRepo, taskRepo, labelRepo, userWorkspaceRoleRepo, userRepo, workflowRepo, sprintRepo, customFieldRepo)
+	taskCSVHandler := handlers.NewTaskCSVHandler(taskRepo, labelRepo, userWorkspaceRoleRepo, userRepo, webhookRepo, workflowRepo, customFieldRepo)
+	taskBulkHandler := handlers.NewTaskBulkHandler(taskRepo, taskLinkRepo, labelRepo, userWorkspaceRoleRepo, userRepo, webhookRepo, workflowRepo, workspaceRepo, customFieldRepo)
+	searchHandler := handlers.NewSearchHandler(searchRepo, userRepo)
+	reportHandler := handlers.NewReportHandler(reportRepo, userWorkspaceRoleRepo, userRepo)
+	sprintHandler := handlers.NewSprintHandler(sprintRepo, taskRepo, userWorkspaceRoleRepo, userRepo)
+	webhookHandler := handlers.NewWebhookHandler(webhookRepo, userWorkspaceRoleRepo, userRepo, webhookSender)
+	tokenHandler := handlers.NewTokenHandler(tokenRepo, userRepo)
+	twoFactorHandler := handlers.NewTwoFactorHandler(userRepo, recoveryCodeRepo, loginGuard, sessionRepo)
+	sessionHandler := handlers.NewSessionHandler(sessionRepo, userRepo)
+	profileHandler := handlers.NewProfileHandler(userRepo, avatarRepo, userWorkspaceRoleRepo)
+	visibilityHandler := handlers.NewVisibilityHandler(workspaceRepo, taskRepo, userWorkspaceRoleRepo, userRepo, webhookRepo)
+	memberHandler := handlers.NewMemberHandler(userWorkspaceRoleRepo, userRepo, workspaceRepo, webhookRepo)
+	organizationHandler := handlers.NewOrganizationHandler(organizationRepo, workspaceRepo, userWorkspaceRoleRepo, userRepo)
+	teamHandler := handlers.NewTeamHandler(teamRepo, organizationRepo, workspaceRepo, userRepo)
+	templateHandler := handlers.NewTemplateHandler(templateRepo, workspaceRepo, taskRepo, labelRepo, userWorkspaceRoleRepo, userRepo, workflowRepo, customFieldRepo)
+	customFieldHandler := handlers.NewCustomFieldHandler(customFieldRepo, userWorkspaceRoleRepo, userRepo)
+	workflowHandler := handlers.NewWorkflowHandler(workflowRepo, userWorkspaceRoleRepo, userRepo)
+	accountHandler := handlers.NewAccountHandler(userRepo, userWorkspaceRoleRepo, workspaceRepo, taskRepo, sessionRepo, tokenRepo)
+
+	authenticator := customMiddleware.NewAuthenticator(tokenRepo, sessionRepo, userRepo)
+	signupRateLimit := customMiddleware.RateLimit(rateLimitRepo, "signup", signupLimit, customMiddleware.ByIP)
+	loginRateLimit := customMiddleware.RateLimit(rateLimitRepo, "login", loginLimit, customMiddleware.ByIP)
+	apiRateLimit := customMiddleware.RateLimit(rateLimitRepo, "api", apiLimit, customMiddleware.ByUser)
+	publicRateLimit := customMiddleware.RateLimit(rateLimitRepo, "public", apiLimit, customMiddleware.ByIP)
+	archivedReadOnly := customMiddleware.ArchivedReadOnly(workspaceRepo,
+		"POST /workspaces/:workspaceId/unarchive",
+		"POST /workspaces/:workspaceId/clone",
+		"POST /workspaces/:workspaceId/templates",
+		"DELETE /workspaces/:workspaceId",
+	)
 
 	e.GET("/healthz", func(c echo.Context) error { return c.NoContent(http.StatusNoContent) })
 
@@ -66,35 +147,282 @@ func main() {
 	users := e.Group("/users")
 	workspaces := e.Group("/workspaces")
 	tasks := e.Group("/workspaces/:workspaceId/tasks")
+	invitations := e.Group("/invitations")
+	organizations := e.Group("/organizations")
+	workspaceTemplates := e.Group("/templates")
 
 	// User auth Handlers
-	auth.POST("/signup", userHandler.Register)
-	auth.POST("/login", userHandler.Login)
+	auth.POST("/signup", userHandler.Register, signupRateLimit)
+	auth.POST("/login", userHandler.Login, loginRateLimit)
+	auth.POST("/login/2fa", twoFactorHandler.Login, loginRateLimit)
+
+	// SSO Handlers
+	var oidcHandler *handlers.OIDCHandler
+	if oidcConfig := oidc.ConfigFromEnv(); oidcConfig.Enabled() {
+		oidcHandler = handlers.NewOIDCHandler(userRepo, sessionRepo, oidc.NewProvider(oidcConfig))
+		auth.GET("/oidc/login", oidcHandler.Login, loginRateLimit)
+		auth.GET("/oidc/callback", oidcHandler.Callback, loginRateLimit)
+	}
 
 	// Users Handlers
-	users.Use(customMiddleware.JWTAuthentication)
+	users.Use(authenticator.JWTAuthentication, apiRateLimit)
 	users.GET("/", userHandler.GetUsers)
 	users.GET("/:username", userHandler.GetUser)
 	users.PUT("/:username", userHandler.UpdateUser)
-	users.DELETE("/:username", userHandler.DeleteUser)
+	users.DELETE("/:username", accountHandler.DeleteAccount)
 	users.GET("/search", userHandler.SearchUsers)
 
+	// Profile Handlers
+	users.GET("/:username/profile", profileHandler.GetProfile)
+	users.PUT("/:username/profile", profileHandler.UpdateProfile)
+	users.GET("/:username/preferences", profileHandler.GetPreferences)
+	users.PUT("/:username/preferences", profileHandler.UpdatePreferences)
+	users.PUT("/:username/avatar", profileHandler.UploadAvatar)
+	users.DELETE("/:username/avatar", profileHandler.DeleteAvatar)
+	e.GET("/avatars/:userId", profileHandler.GetAvatar)
+
+	// Account Handlers
+	users.GET("/:username/export", accountHandler.ExportAccount)
+
+	// Access Token Handlers
+	users.GET("/:username/tokens", tokenHandler.GetTokens)
+	users.POST("/:username/tokens", tokenHandler.CreateToken)
+	users.DELETE("/:username/tokens/:tokenId", tokenHandler.RevokeToken)
+
+	// Two-Factor Handlers
+	users.GET("/:username/2fa", twoFactorHandler.GetStatus)
+	users.POST("/:username/2fa/enroll", twoFactorHandler.Enroll)
+	users.POST("/:username/2fa/verify", twoFactorHandler.Verify)
+	users.POST("/:username/2fa/disable", twoFactorHandler.Disable)
+
+	// Session Handlers
+	users.GET("/:username/sessions", sessionHandler.GetSessions)
+	users.DELETE("/:username/sessions", sessionHandler.RevokeOtherSessions)
+	users.DELETE("/:username/sessions/:sessionId", sessionHandler.RevokeSession)
+
+	// SSO Link Handlers
+	if oidcHandler != nil {
+		users.POST("/:username/oidc/link", oidcHandler.Link)
+	}
+
 	// Workspaces Handlers
-	workspaces.Use(customMiddleware.JWTAuthentication)
+	workspaces.Use(authenticator.JWTAuthentication, apiRateLimit, archivedReadOnly)
 	workspaces.GET("/", workspaceHandler.GetWorkspaces)
 	workspaces.POST("/", workspaceHandler.CreateWorkspace)
 	workspaces.GET("/:workspaceId", workspaceHandler.GetWorkspaceDescription)
 	workspaces.PUT("/:workspaceId", workspaceHandler.UpdateWorkspace)
 	workspaces.DELETE("/:workspaceId", workspaceHandler.DeleteWorkspace)
+	workspaces.POST("/:workspaceId/archive", workspaceHandler.ArchiveWorkspace)
+	workspaces.POST("/:workspaceId/unarchive", workspaceHandler.UnarchiveWorkspace)
+
+	// Visibility Handlers
+	workspaces.PUT("/:workspaceId/visibility", visibilityHandler.SetVisibility)
+	workspaces.POST("/:workspaceId/public-link", visibilityHandler.RegeneratePublicLink)
+	workspaces.DELETE("/:workspaceId/public-link", visibilityHandler.RevokePublicLink)
+	e.GET("/public/boards/:slug", visibilityHandler.GetPublicBoard, publicRateLimit)
+
+	// Member Handlers
+	workspaces.GET("/:workspaceId/members", memberHandler.GetMembers)
+	workspaces.PUT("/:workspaceId/members/:username", memberHandler.UpdateMember)
+	workspaces.DELETE("/:workspaceId/members/:username", memberHandler.RemoveMember)
+	workspaces.POST("/:workspaceId/transfer-ownership", memberHandler.TransferOwnership)
+
+	// Template Handlers
+	workspaces.POST("/:workspaceId/clone", templateHandler.CloneWorkspace)
+	workspaces.POST("/:workspaceId/templates", templateHandler.SaveTemplate)
+	workspaceTemplates.Use(authenticator.JWTAuthentication, apiRateLimit)
+	workspaceTemplates.GET("/", templateHandler.GetTemplates)
+	workspaceTemplates.GET("/:templateId", templateHandler.GetTemplate)
+	workspaceTemplates.DELETE("/:templateId", templateHandler.DeleteTemplate)
+
+	// Custom Field Handlers
+	workspaces.GET("/:workspaceId/custom-fields", customFieldHandler.GetCustomFields)
+	workspaces.POST("/:workspaceId/custom-fields", customFieldHandler.CreateCustomField)
+	workspaces.PUT("/:workspaceId/custom-fields/:fieldId", customFieldHandler.UpdateCustomField)
+	workspaces.DELETE("/:workspaceId/custom-fields/:fieldId", customFieldHandler.DeleteCustomField)
+
+	// Workflow Handlers
+	workspaces.GET("/:workspaceId/workflow", workflowHandler.GetWorkflow)
+	workspaces.PUT("/:workspaceId/workflow", workflowHandler.UpdateWorkflow)
+
+	// Organization Handlers
+	organizations.Use(authenticator.JWTAuthentication, apiRateLimit)
+	organizations.GET("/", organizationHandler.GetOrganizations)
+	organizations.POST("/", organizationHandler.CreateOrganization)
+	organizations.GET("/:organizationId", organizationHandler.GetOrganization)
+	organizations.PUT("/:organizationId", organizationHandler.UpdateOrganization)
+	organizations.DELETE("/:organizationId", organizationHandler.DeleteOrganization)
+	organizations.GET("/:organizationId/members", organizationHandler.GetMembers)
+	organizations.PUT("/:organizationId/members/:username", organizationHandler.SetMember)
+	organizations.DELETE("/:organizationId/members/:username", organizationHandler.RemoveMember)
+	organizations.GET("/:organizationId/workspaces", organizationHandler.GetWorkspaces)
+	organizations.PUT("/:organizationId/workspaces/:workspaceId", organizationHandler.AddWorkspace, archivedReadOnly)
+	organizations.DELETE("/:organizationId/workspaces/:workspaceId", organizationHandler.RemoveWorkspace, archivedReadOnly)
+
+	// Team Handlers
+	organizations.GET("/:organizationId/teams", teamHandler.GetTeams)
+	organizations.POST("/:organizationId/teams", teamHandler.CreateTeam)
+	organizations.PUT("/:organizationId/teams/:teamId", teamHandler.UpdateTeam)
+	organizations.DELETE("/:organizationId/teams/:teamId", teamHandler.DeleteTeam)
+	organizations.GET("/:organizationId/teams/:teamId/members", teamHandler.GetTeamMembers)
+	organizations.PUT("/:organizationId/teams/:teamId/members/:username", teamHandler.AddTeamMember)
+	organizations.DELETE("/:organizationId/teams/:teamId/members/:username", teamHandler.RemoveTeamMember)
+	organizations.GET("/:organizationId/teams/:teamId/workspaces", teamHandler.GetTeamWorkspaces)
+	organizations.PUT("/:organizationId/teams/:teamId/workspaces/:workspaceId", teamHandler.SetTeamWorkspaceRole, archivedReadOnly)
+	organizations.DELETE("/:organizationId/teams/:teamId/workspaces/:workspaceId", teamHandler.RemoveTeamWorkspaceRole, archivedReadOnly)
+
+	// Invitation Handlers
+	workspaces.GET("/:workspaceId/invitations", invitationHandler.GetInvitations)
+	workspaces.POST("/:workspaceId/invitations", invitationHandler.CreateInvitation)
+	workspaces.DELETE("/:workspaceId/invitations/:invitationId", invitationHandler.RevokeInvitation)
+	invitations.GET("/:token", invitationHandler.GetInvitation, loginRateLimit)
+	invitations.POST("/:token/accept", invitationHandler.AcceptInvitation, authenticator.JWTAuthentication, apiRateLimit)
+	invitations.POST("/:token/register", invitationHandler.RegisterAndAccept, signupRateLimit)
+
+	// Trash Handlers
+	workspaces.GET("/:workspaceId/trash", trashHandler.GetTrash)
+	workspaces.POST("/:workspaceId/trash/restore", trashHandler.RestoreWorkspace)
+	workspaces.POST("/:workspaceId/trash/tasks/:taskId/restore", trashHandler.RestoreTask)
+
+	// Export/Import Handlers
+	workspaces.GET("/:workspaceId/export", archiveHandler.ExportWorkspace)
+	workspaces.POST("/import", archiveHandler.ImportWorkspace)
+	workspaces.GET("/:workspaceId/tasks.csv", taskCSVHandler.ExportTasks)
+
+	// Report Handlers
+	workspaces.GET("/:workspaceId/reports/counts", reportHandler.GetTaskCounts)
+	workspaces.GET("/:workspaceId/reports/throughput", reportHandler.GetThroughput)
+	workspaces.GET("/:workspaceId/reports/cycle-time", reportHandler.GetCycleTime)
+	workspaces.GET("/:workspaceId/reports/time-tracking", reportHandler.GetTimeTracking)
+
+	// Sprint Handlers
+	workspaces.GET("/:workspaceId/sprints", sprintHandler.GetSprints)
+	workspaces.POST("/:workspaceId/sprints", sprintHandler.CreateSprint)
+	workspaces.GET("/:workspaceId/sprints/:sprintId", sprintHandler.GetSprint)
+	workspaces.PUT("/:workspaceId/sprints/:sprintId", sprintHandler.UpdateSprint)
+	workspaces.POST("/:workspaceId/sprints/:sprintId/start", sprintHandler.StartSprint)
+	workspaces.POST("/:workspaceId/sprints/:sprintId/complete", sprintHandler.CompleteSprint)
+	workspaces.POST("/:workspaceId/sprints/:sprintId/tasks", sprintHandler.AddSprintTasks)
+	workspaces.DELETE("/:workspaceId/sprints/:sprintId/tasks/:taskId", sprintHandler.RemoveSprintTask)
+	workspaces.GET("/:workspaceId/sprints/:sprintId/burndown", sprintHandler.GetBurndown)
+
+	// Webhook Handlers
+	workspaces.GET("/:workspaceId/webhooks", webhookHandler.GetWebhooks)
+	workspaces.POST("/:workspaceId/webhooks", webhookHandler.CreateWebhook)
+	workspaces.GET("/:workspaceId/webhooks/:webhookId", webhookHandler.GetWebhook)
+	workspaces.PUT("/:workspaceId/webhooks/:webhookId", webhookHandler.UpdateWebhook)
+	workspaces.DELETE("/:workspaceId/webhooks/:webhookId", webhookHandler.DeleteWebhook)
+	workspaces.GET("/:workspaceId/webhooks/:webhookId/deliveries", webhookHandler.GetDeliveries)
+	workspaces.POST("/:workspaceId/webhooks/:webhookId/deliveries/:deliveryId/redeliver", webhookHandler.Redeliver)
+	workspaces.POST("/:workspaceId/webhooks/:webhookId/ping", webhookHandler.Ping)
 
 	// Task Handlers
-	tasks.Use(customMiddleware.JWTAuthentication)
-	tasks.GET("/", handlers.NewTaskHandler(taskRepo, userWorkspaceRoleRepo, userRepo).GetTasks)
-	tasks.POST("/", handlers.NewTaskHandler(taskRepo, userWorkspaceRoleRepo, userRepo).CreateTask)
-	tasks.GET("/:taskId", handlers.NewTaskHandler(taskRepo, userWorkspaceRoleRepo, userRepo).GetTask)
-	tasks.PUT("/:taskId", handlers.NewTaskHandler(taskRepo, userWorkspaceRoleRepo, userRepo).UpdateTask)
-	tasks.DELETE("/:taskId", handlers.NewTaskHandler(taskRepo, userWorkspaceRoleRepo, userRepo).DeleteTask)
+	tasks.Use(authenticator.JWTAuthentication, apiRateLimit, archivedReadOnly)
+	tasks.GET("/", taskHandler.GetTasks)
+	tasks.POST("/", taskHandler.CreateTask)
+	tasks.GET("/:taskId", taskHandler.GetTask)
+	tasks.PUT("/:taskId", taskHandler.UpdateTask)
+	tasks.DELETE("/:taskId", taskHandler.DeleteTask)
+	tasks.POST("/:taskId/archive", taskHandler.ArchiveTask)
+	tasks.POST("/:taskId/unarchive", taskHandler.UnarchiveTask)
+	tasks.POST("/import", taskCSVHandler.ImportTasks)
+	tasks.POST("/bulk", taskBulkHandler.BulkTasks)
+	tasks.POST("/:taskId/links", taskHandler.CreateTaskLink)
+	tasks.DELETE("/:taskId/links/:linkId", taskHandler.DeleteTaskLink)
+
+	// Search Handlers
+	e.GET("/search", searchHandler.Search, authenticator.JWTAuthentication, apiRateLimit)
 
 	log.Println("Starting Echo server on port 8080...")
 	e.Logger.Fatal(e.Start(":8080"))
 }
+
+// passwordLogin reports whether password signup and login are allowed;
+// set PASSWORD_LOGIN_DISABLED=true to allow only SSO.
+func passwordLogin() bool {
+	disabled, _ := strconv.ParseBool(os.Getenv("PASSWORD_LOGIN_DISABLED"))
+	return !disabled
+}
+
+// ipExtractor decides where client addresses, used for rate limits and
+// sessions, come from. Forwarding headers are only believed from the
+// proxies listed in TRUSTED_PROXIES, a comma-separated list of CIDR
+// ranges; without it the address of the connection is used.
+func ipExtractor() echo.IPExtractor {
+	var trusted []echo.TrustOption
+	for _, cidr := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, ipRange, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Printf("Ignoring invalid trusted proxy %q: %v\n", cidr, err)
+			continue
+		}
+		trusted = append(trusted, echo.TrustIPRange(ipRange))
+	}
+	if len(trusted) == 0 {
+		return echo.ExtractIPDirect()
+	}
+
+	trusted = append(trusted, echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false))
+	return echo.ExtractIPFromXFFHeader(trusted...)
+}
+
+// rateLimitPolicy reads a rate limit such as "10/1m" (ten requests a
+// minute) from the environment variable, defaulting to fallback.
+func rateLimitPolicy(name string, fallback repository.RateLimitPolicy) repository.RateLimitPolicy {
+	events, per, found := strings.Cut(os.Getenv(name), "/")
+	if !found {
+		return fallback
+	}
+	policy := repository.RateLimitPolicy{}
+	var err error
+	if policy.Events, err = strconv.Atoi(events); err != nil || policy.Events <= 0 {
+		return fallback
+	}
+	if policy.Per, err = time.ParseDuration(per); err != nil || policy.Per <= 0 {
+		return fallback
+	}
+	return policy
+}
+
+// lockoutPolicy reads the account lockout settings: LOCKOUT_THRESHOLD
+// failures (default 5) lock an account for LOCKOUT_BASE (default 1m),
+// doubling with each further failure up to LOCKOUT_MAX (default 1h).
+func lockoutPolicy() repository.LockoutPolicy {
+	policy := repository.LockoutPolicy{Threshold: 5, Base: time.Minute, Max: time.Hour}
+	if threshold, err := strconv.ParseUint(os.Getenv("LOCKOUT_THRESHOLD"), 10, 32); err == nil && threshold > 0 {
+		policy.Threshold = uint(threshold)
+	}
+	if base, err := time.ParseDuration(os.Getenv("LOCKOUT_BASE")); err == nil && base > 0 {
+		policy.Base = base
+	}
+	if max, err := time.ParseDuration(os.Getenv("LOCKOUT_MAX")); err == nil && max >= policy.Base {
+		policy.Max = max
+	}
+	return policy
+}
+
+// trashRetention reads how long soft-deleted rows are kept from
+// TRASH_RETENTION_DAYS, defaulting to 30 days.
+func trashRetention() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("TRASH_RETENTION_DAYS"))
+	if err != nil || days <= 0 {
+		days = 30
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+func purgeTrash(taskRepo *gorm.Task, workspaceRepo *gorm.Workspace, retention time.Duration) {
+	for {
+		before := time.Now().Add(-retention)
+		if err := taskRepo.Purge(before); err != nil {
+			log.Printf("error purging tasks from trash: %s", err.Error())
+		}
+		if err := workspaceRepo.Purge(before); err != nil {
+			log.Printf("error purging workspaces from trash: %s", err.Error())
+		}
+		time.Sleep(time.Hour)
+	}
+}
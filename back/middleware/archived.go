@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/repository"
+	"gorm.io/gorm"
+)
+
+// ArchivedReadOnly rejects requests that could change an archived workspace
+// with 409. Only safe methods and the routes listed in writable, such as
+// the one that unarchives it, are let through. Requests without a
+// workspaceId path parameter or for a workspace that no longer exists are
+// left to the handler.
+func ArchivedReadOnly(workspaceRepo repository.Workspace, writable ...string) echo.MiddlewareFunc {
+	allowed := make(map[string]bool, len(writable))
+	for _, path := range writable {
+		allowed[path] = true
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			method := c.Request().Method
+			if method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions ||
+				allowed[method+" "+c.Path()] {
+				return next(c)
+			}
+
+			workspaceId, err := strconv.ParseUint(c.Param("workspaceId"), 10, 64)
+			if err != nil {
+				return next(c)
+			}
+
+			workspace, err := workspaceRepo.FindByID(uint(workspaceId))
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return next(c)
+			}
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, err.Error())
+			}
+			if workspace.Archived_at != nil {
+				return c.JSON(http.StatusConflict, "Workspace is archived, unarchive it first")
+			}
+			return next(c)
+		}
+	}
+}
@@ -3,12 +3,44 @@ package middleware
 import (
 	"net/http"
 	"strings"
+	"time"
 
-	"github.com/raeinsoltani/gorello/back/utils"
 	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+	"github.com/raeinsoltani/gorello/back/utils"
 )
 
-func JWTAuthentication(next echo.HandlerFunc) echo.HandlerFunc {
+// lastUsedResolution limits how often a token's or session's last-used
+// timestamp is written, so busy clients don't cause a write per request.
+const lastUsedResolution = time.Minute
+
+// scopeLevels orders the token scopes; a token may do whatever its scope or
+// any lower one allows.
+var scopeLevels = map[string]int{
+	models.TokenScopeRead:           0,
+	models.TokenScopeTasksWrite:     1,
+	models.TokenScopeWorkspaceAdmin: 2,
+}
+
+type Authenticator struct {
+	TokenRepo   repository.PersonalAccessToken
+	SessionRepo repository.Session
+	UserRepo    repository.User
+}
+
+func NewAuthenticator(tokenRepo repository.PersonalAccessToken, sessionRepo repository.Session, userRepo repository.User) *Authenticator {
+	return &Authenticator{
+		TokenRepo:   tokenRepo,
+		SessionRepo: sessionRepo,
+		UserRepo:    userRepo,
+	}
+}
+
+// JWTAuthentication accepts either a login JWT or a personal access token
+// as the bearer token and sets the caller's username in the context, along
+// with "session_id" for a JWT or "token_id" for a personal access token.
+func (a *Authenticator) JWTAuthentication(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		authHeader := c.Request().Header.Get("Authorization")
 		if authHeader == "" {
@@ -20,14 +52,74 @@ func JWTAuthentication(next echo.HandlerFunc) echo.HandlerFunc {
 			return c.JSON(http.StatusUnauthorized, "invalid Authorization header format")
 		}
 
-		username, err := utils.ParseJWT(headerParts[1])
+		if utils.IsAccessToken(headerParts[1]) {
+			return a.accessToken(c, next, headerParts[1])
+		}
+
+		username, sessionId, err := utils.ParseJWT(headerParts[1])
 		if err != nil {
 			return c.JSON(http.StatusUnauthorized, "invalid or expired JWT")
 		}
 
+		session, err := a.SessionRepo.FindByID(sessionId)
+		if err != nil || session.Revoked_at != nil {
+			return c.JSON(http.StatusUnauthorized, "session has been revoked")
+		}
+
+		now := time.Now()
+		if now.Sub(session.Last_seen_at) >= lastUsedResolution || session.Ip != c.RealIP() {
+			if err := a.SessionRepo.Touch(session.ID, now, c.RealIP()); err != nil {
+				c.Logger().Errorf("error updating session %d: %s", session.ID, err.Error())
+			}
+		}
+
 		// Set the username in the context
 		c.Set("username", username)
+		c.Set("session_id", session.ID)
 
 		return next(c)
 	}
 }
+
+func (a *Authenticator) accessToken(c echo.Context, next echo.HandlerFunc, raw string) error {
+	token, err := a.TokenRepo.FindByHash(utils.HashAccessToken(raw))
+	now := time.Now()
+	if err != nil || token.Revoked_at != nil || (token.Expires_at != nil && token.Expires_at.Before(now)) {
+		return c.JSON(http.StatusUnauthorized, "invalid, expired or revoked access token")
+	}
+
+	if scopeLevels[token.Scope] < scopeLevels[requiredScope(c)] {
+		return c.JSON(http.StatusForbidden, "access token scope does not allow this request")
+	}
+
+	user, err := a.UserRepo.FindByID(token.User_id)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, "invalid, expired or revoked access token")
+	}
+
+	if token.Last_used_at == nil || now.Sub(*token.Last_used_at) >= lastUsedResolution {
+		if err := a.TokenRepo.Touch(token.ID, now); err != nil {
+			c.Logger().Errorf("error updating access token %d: %s", token.ID, err.Error())
+		}
+	}
+
+	c.Set("username", user.Username)
+	c.Set("token_id", token.ID)
+
+	return next(c)
+}
+
+// requiredScope is the scope a token needs for the request: reads need
+// read, changes to tasks and sprints need tasks:write and any other change
+// needs workspace:admin.
+func requiredScope(c echo.Context) string {
+	switch c.Request().Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return models.TokenScopeRead
+	}
+	if strings.HasPrefix(c.Path(), "/workspaces/:workspaceId/tasks") ||
+		strings.HasPrefix(c.Path(), "/workspaces/:workspaceId/sprints") {
+		return models.TokenScopeTasksWrite
+	}
+	return models.TokenScopeWorkspaceAdmin
+}
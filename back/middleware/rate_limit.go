@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/raeinsoltani/gorello/back/repository"
+)
+
+// ByIP keys rate limits by the client's address.
+func ByIP(c echo.Context) string {
+	return "ip:" + c.RealIP()
+}
+
+// ByUser keys rate limits by the authenticated user, falling back to the
+// address. It must run after JWTAuthentication.
+func ByUser(c echo.Context) string {
+	if username, ok := c.Get("username").(string); ok {
+		return "user:" + username
+	}
+	return ByIP(c)
+}
+
+// RateLimit rejects requests with 429 and a Retry-After header once the
+// caller's bucket for the named limit is empty. If the store fails the
+// request is let through.
+func RateLimit(store repository.RateLimit, name string, policy repository.RateLimitPolicy, key func(echo.Context) string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			wait, err := store.Take(name+":"+key(c), policy)
+			if err != nil {
+				c.Logger().Errorf("error checking rate limit %s: %s", name, err.Error())
+				return next(c)
+			}
+			if wait > 0 {
+				SetRetryAfter(c, wait)
+				return c.JSON(http.StatusTooManyRequests, "too many requests")
+			}
+			return next(c)
+		}
+	}
+}
+
+// SetRetryAfter sets the Retry-After header in whole seconds, rounded up.
+func SetRetryAfter(c echo.Context, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+const (
+	CustomFieldTypeText        = "text"
+	CustomFieldTypeNumber      = "number"
+	CustomFieldTypeDate        = "date"
+	CustomFieldTypeSelect      = "select"
+	CustomFieldTypeMultiSelect = "multi_select"
+	CustomFieldTypeUser        = "user"
+)
+
+// CustomField is a field a workspace adds to its tasks.
+type CustomField struct {
+	gorm.Model
+	Workspace_id uint   `gorm:"index;not null"`
+	Name         string `gorm:"type:varchar(100);not null"`
+	Type         string `gorm:"type:varchar(20);not null"`
+	// Options are the choices of select and multi-select fields.
+	Options  []string `gorm:"type:jsonb;serializer:json"`
+	Required bool     `gorm:"not null;default:false"`
+}
+
+// CustomFieldValue is a task's value for a custom field, as JSON: a string
+// for text, date (YYYY-MM-DD) and select fields, a number for number
+// fields, a user id for user fields and an array of strings for
+// multi-select fields.
+type CustomFieldValue struct {
+	Task_id         uint   `gorm:"primaryKey"`
+	Custom_field_id uint   `gorm:"primaryKey;index"`
+	Value           string `gorm:"type:jsonb;not null"`
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Invitation lets someone join a workspace. An invitation with an email is
+// for that person only and can be accepted once; one without is a
+// shareable join link, usable Max_uses times, or without limit if 0.
+type Invitation struct {
+	gorm.Model
+	Workspace_id uint   `gorm:"not null;index"`
+	Email        string `gorm:"type:varchar(255)"`
+	Role         uint   `gorm:"default:0"`
+	Inviter_id   uint   `gorm:"not null"`
+	Token_hash   string `gorm:"type:char(64);not null;uniqueIndex" json:"-"`
+	Expires_at   time.Time
+	Max_uses     uint
+	Uses         uint
+	Revoked_at   *time.Time
+}
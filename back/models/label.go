@@ -0,0 +1,12 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+type Label struct {
+	gorm.Model
+	Name         string `gorm:"type:varchar(100);not null"`
+	Color        string `gorm:"type:varchar(100)"`
+	Workspace_id uint   `gorm:"foreignKey:WorkspaceID;not null"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// Organization groups workspaces and the people working on them. Its
+// admins have owner access to every workspace in it.
+type Organization struct {
+	gorm.Model
+	Name string `gorm:"type:varchar(100);not null"`
+	// Seat_limit caps the number of members; 0 means no limit.
+	Seat_limit uint
+}
+
+// OrganizationMember is a user's membership in an organization. Role 1 is
+// admin, 0 a regular member.
+type OrganizationMember struct {
+	Organization_id uint `gorm:"primaryKey;autoIncrement:false"`
+	User_id         uint `gorm:"primaryKey;autoIncrement:false;index"`
+	Role            uint `gorm:"default:0"`
+}
+
+// Team is a group of organization members that can be given a role in the
+// organization's workspaces all at once.
+type Team struct {
+	gorm.Model
+	Organization_id uint   `gorm:"not null;index"`
+	Name            string `gorm:"type:varchar(100);not null"`
+	Description     string `gorm:"type:varchar(255)"`
+}
+
+type TeamMember struct {
+	Team_id uint `gorm:"primaryKey;autoIncrement:false"`
+	User_id uint `gorm:"primaryKey;autoIncrement:false;index"`
+}
+
+// TeamWorkspaceRole gives every member of a team a role in a workspace of
+// the team's organization.
+type TeamWorkspaceRole struct {
+	Team_id      uint `gorm:"primaryKey;autoIncrement:false"`
+	Workspace_id uint `gorm:"primaryKey;autoIncrement:false;index"`
+	Role         uint `gorm:"default:0"`
+}
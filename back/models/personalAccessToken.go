@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Scopes of a personal access token. Each scope includes the ones before
+// it: workspace:admin can also write tasks, and every token can read.
+const (
+	TokenScopeRead           = "read"
+	TokenScopeTasksWrite     = "tasks:write"
+	TokenScopeWorkspaceAdmin = "workspace:admin"
+)
+
+type PersonalAccessToken struct {
+	gorm.Model
+	User_id      uint   `gorm:"not null;index"`
+	Name         string `gorm:"type:varchar(100);not null"`
+	Token_hash   string `gorm:"type:char(64);not null;uniqueIndex" json:"-"`
+	Prefix       string `gorm:"type:varchar(12);not null"`
+	Scope        string `gorm:"type:varchar(20);not null"`
+	Expires_at   *time.Time
+	Last_used_at *time.Time
+	Revoked_at   *time.Time
+}
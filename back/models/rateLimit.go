@@ -0,0 +1,21 @@
+package models
+
+import (
+	"time"
+)
+
+// RateLimitBucket is the token bucket of one rate-limited key, used when
+// limits are kept in Postgres.
+type RateLimitBucket struct {
+	Key        string  `gorm:"primaryKey;type:varchar(255)"`
+	Tokens     float64 `gorm:"not null"`
+	Updated_at time.Time
+}
+
+// LoginFailure counts the consecutive failed logins of one account.
+type LoginFailure struct {
+	Key          string `gorm:"primaryKey;type:varchar(255)"`
+	Failures     uint   `gorm:"not null;default:0"`
+	Locked_until *time.Time
+	Updated_at   time.Time
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RecoveryCode is a hashed one-time code that stands in for a TOTP code.
+type RecoveryCode struct {
+	gorm.Model
+	User_id   uint   `gorm:"not null;index"`
+	Code_hash string `gorm:"type:char(64);not null" json:"-"`
+	Used_at   *time.Time
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Session is one login, backing the JWT issued for it.
+type Session struct {
+	gorm.Model
+	User_id      uint   `gorm:"not null;index"`
+	Device       string `gorm:"type:varchar(100)"`
+	User_agent   string `gorm:"type:varchar(512)"`
+	Ip           string `gorm:"type:varchar(64)"`
+	Last_seen_at time.Time
+	Expires_at   time.Time `gorm:"not null"`
+	Revoked_at   *time.Time
+}
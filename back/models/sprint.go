@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	SprintPlanned   = "planned"
+	SprintActive    = "active"
+	SprintCompleted = "completed"
+)
+
+type Sprint struct {
+	gorm.Model
+	Workspace_id     uint      `gorm:"not null;index"`
+	Name             string    `gorm:"type:varchar(100);not null"`
+	Goal             string    `gorm:"type:varchar(255)"`
+	Start_date       time.Time `gorm:"type:date;not null"`
+	End_date         time.Time `gorm:"type:date;not null"`
+	State            string    `gorm:"type:varchar(20);not null;default:planned"`
+	Started_at       *time.Time
+	Completed_at     *time.Time
+	Committed_points uint `gorm:"default:0"`
+	Completed_points uint `gorm:"default:0"`
+}
@@ -1,19 +1,36 @@
 package models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
+// The statuses of the default workflow, see DefaultWorkflowStatuses.
+const (
+	TaskStatusTodo       uint = 0
+	TaskStatusInProgress uint = 1
+	TaskStatusDone       uint = 2
+)
+
 type Task struct {
 	gorm.Model
-	Title          string `gorm:"type:varchar(100);not null"`
-	Description    string `gorm:"type:varchar(100)"`
-	Status         uint   `gorm:"default:0"`
-	Estimated_time string `gorm:"type:varchar(100)"`
-	Actual_time    string `gorm:"type:varchar(100)"`
-	Due_date       string `gorm:"type:varchar(100)"`
-	Priority       uint   `gorm:"default:0"`
-	Workspace_id   uint   `gorm:"foreignKey:not null"`
-	Assignee_id    uint   `gorm:"foreignKey:optional"`
-	Image_url      string `gorm:"type:varchar(100)"`
+	Title          string    `gorm:"type:varchar(100);not null"`
+	Description    string    `gorm:"type:varchar(100)"`
+	Status         uint      `gorm:"default:0"`
+	Estimated_time string    `gorm:"type:varchar(100)"`
+	Actual_time    string    `gorm:"type:varchar(100)"`
+	Due_date       string    `gorm:"type:varchar(100)"`
+	Priority       uint      `gorm:"default:0"`
+	Workspace_id   uint      `gorm:"foreignKey:not null"`
+	Assignee_id    uint      `gorm:"foreignKey:optional"`
+	Image_url      string    `gorm:"type:varchar(100)"`
+	Sprint_id      uint      `gorm:"index;default:0"`
+	Story_points   uint      `gorm:"default:0"`
+	Version        uint      `gorm:"not null;default:1"`
+	SubTasks       []SubTask `gorm:"foreignKey:Task_id"`
+	Labels         []Label   `gorm:"many2many:task_labels"`
+	// Archived_at is set while the task is archived, which hides it from
+	// the default listing.
+	Archived_at *time.Time `gorm:"index"`
 }
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// TaskActivity records a task's status transitions. From_status is nil for
+// the entry written when the task is created.
+type TaskActivity struct {
+	gorm.Model
+	Task_id      uint `gorm:"not null;index"`
+	Workspace_id uint `gorm:"not null;index"`
+	From_status  *uint
+	To_status    uint `gorm:"not null"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+const (
+	TaskLinkBlocks     = "blocks"
+	TaskLinkRelatesTo  = "relates_to"
+	TaskLinkDuplicates = "duplicates"
+)
+
+// TaskLink is a directed relation between two tasks of the same workspace,
+// read as "Task_id <Type> Linked_task_id", e.g. task 1 blocks task 2.
+type TaskLink struct {
+	gorm.Model
+	Task_id        uint   `gorm:"not null;uniqueIndex:idx_task_link"`
+	Linked_task_id uint   `gorm:"not null;uniqueIndex:idx_task_link;index"`
+	Type           string `gorm:"type:varchar(20);not null;uniqueIndex:idx_task_link"`
+	Workspace_id   uint   `gorm:"not null;index"`
+}
@@ -1,12 +1,54 @@
 package models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
+// UserPreferences are kept as JSON on the user. An empty theme means follow
+// the system setting.
+type UserPreferences struct {
+	Theme                string                  `json:"theme"`
+	Default_workspace_id uint                    `json:"default_workspace_id"`
+	Notifications        NotificationPreferences `json:"notifications"`
+}
+
+type NotificationPreferences struct {
+	Email_assignments bool `json:"email_assignments"`
+	Email_mentions    bool `json:"email_mentions"`
+	Email_due_dates   bool `json:"email_due_dates"`
+	Email_digest      bool `json:"email_digest"`
+}
+
 type User struct {
 	gorm.Model
 	Username string `gorm:"unique;type:varchar(100);not null"`
 	Email    string `gorm:"unique;type:varchar(100);not null"`
-	Password string `gorm:"type:varchar(100)"`
+	Password string `gorm:"type:varchar(100)" json:"-"`
+	Version  uint   `gorm:"not null;default:1"`
+	// Oidc_subject links the account to the identity provider's user.
+	Oidc_subject *string `gorm:"uniqueIndex;type:varchar(255)" json:"-"`
+	// Totp_secret is set on enrollment and used once Totp_enabled is
+	// confirmed with a first code. Totp_last_step is the time step of the
+	// last accepted code, which can't be used again.
+	Totp_secret    string `gorm:"type:varchar(64)" json:"-"`
+	Totp_enabled   bool   `gorm:"not null;default:false"`
+	Totp_last_step int64  `gorm:"not null;default:0" json:"-"`
+
+	Display_name string          `gorm:"type:varchar(100)"`
+	Avatar_url   string          `gorm:"type:varchar(255)"`
+	Timezone     string          `gorm:"type:varchar(64);not null;default:UTC"`
+	Locale       string          `gorm:"type:varchar(35);not null;default:en"`
+	Bio          string          `gorm:"type:varchar(500)"`
+	Preferences  UserPreferences `gorm:"type:jsonb;serializer:json;not null;default:'{}'"`
+}
+
+// Avatar is a user's uploaded profile picture, kept apart from the user row
+// so that loading users doesn't load images.
+type Avatar struct {
+	User_id      uint   `gorm:"primaryKey;autoIncrement:false"`
+	Content_type string `gorm:"type:varchar(50);not null"`
+	Data         []byte `gorm:"not null"`
+	Updated_at   time.Time
 }
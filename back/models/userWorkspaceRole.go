@@ -1,7 +1,12 @@
 package models
 
+import (
+	"gorm.io/gorm"
+)
+
 type UserWorkspaceRole struct {
-	User_id      uint `gorm:"foreignKey:UserID;not null"`
-	Workspace_id uint `gorm:"foreignKey:WorkspaceID;not null"`
-	Role         uint `gorm:"default:0"`
+	User_id      uint           `gorm:"foreignKey:UserID;not null"`
+	Workspace_id uint           `gorm:"foreignKey:WorkspaceID;not null"`
+	Role         uint           `gorm:"default:0"`
+	DeletedAt    gorm.DeletedAt `gorm:"index"`
 }
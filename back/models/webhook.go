@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// Webhook is a workspace's subscription to its events. Events is a comma
+// separated list of patterns such as "task.*,workspace.deleted" or "*".
+type Webhook struct {
+	gorm.Model
+	Workspace_id uint   `gorm:"not null;index"`
+	Url          string `gorm:"type:varchar(2048);not null"`
+	Secret       string `gorm:"type:varchar(100);not null" json:"-"`
+	Events       string `gorm:"type:varchar(1000);not null;default:*"`
+	Active       bool   `gorm:"default:true"`
+}
+
+type WebhookDelivery struct {
+	gorm.Model
+	Webhook_id      uint   `gorm:"not null;index"`
+	Event           string `gorm:"type:varchar(100);not null"`
+	Payload         string `gorm:"type:text;not null"`
+	Status          string `gorm:"type:varchar(20);not null;default:pending;index"`
+	Attempts        uint   `gorm:"default:0"`
+	Response_code   int
+	Last_error      string    `gorm:"type:text"`
+	Next_attempt_at time.Time `gorm:"index"`
+	Delivered_at    *time.Time
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+// The categories a workspace's statuses fall into. Reports, sprints and
+// blockers treat every status of the done category as done.
+const (
+	StatusCategoryTodo       = "todo"
+	StatusCategoryInProgress = "in_progress"
+	StatusCategoryDone       = "done"
+)
+
+// WorkflowStatus is one of the statuses of a workspace's tasks;
+// Task.Status holds its Value. A workspace without statuses of its own uses
+// DefaultWorkflowStatuses and accepts any status.
+type WorkflowStatus struct {
+	gorm.Model
+	Workspace_id uint   `gorm:"index;not null"`
+	Value        uint   `gorm:"not null"`
+	Name         string `gorm:"type:varchar(50);not null"`
+	Category     string `gorm:"type:varchar(20);not null"`
+	Color        string `gorm:"type:varchar(20)"`
+	Position     uint   `gorm:"not null;default:0"`
+	// Allowed_from lists the statuses a task can move to this one from; if
+	// empty it can come from any.
+	Allowed_from []uint `gorm:"type:jsonb;serializer:json"`
+}
+
+// WorkflowPriority is one step of a workspace's priority scale;
+// Task.Priority holds its Value. A workspace without priorities of its own
+// accepts any priority.
+type WorkflowPriority struct {
+	gorm.Model
+	Workspace_id uint   `gorm:"index;not null"`
+	Value        uint   `gorm:"not null"`
+	Name         string `gorm:"type:varchar(50);not null"`
+	Color        string `gorm:"type:varchar(20)"`
+	Position     uint   `gorm:"not null;default:0"`
+}
+
+// DefaultWorkflowStatuses returns the statuses of a workspace that has
+// none of its own, matching the TaskStatus constants.
+func DefaultWorkflowStatuses() []*WorkflowStatus {
+	return []*WorkflowStatus{
+		{Value: TaskStatusTodo, Name: "To do", Category: StatusCategoryTodo, Position: 0},
+		{Value: TaskStatusInProgress, Name: "In progress", Category: StatusCategoryInProgress, Position: 1},
+		{Value: TaskStatusDone, Name: "Done", Category: StatusCategoryDone, Position: 2},
+	}
+}
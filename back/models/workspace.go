@@ -1,11 +1,32 @@
 package models
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
+// Who can see a workspace besides its members. Organization workspaces
+// are open to every member of the organization; public-link ones can be
+// read by anyone with the link.
+const (
+	WorkspaceVisibilityPrivate      = "private"
+	WorkspaceVisibilityOrganization = "organization"
+	WorkspaceVisibilityPublicLink   = "public-link"
+)
+
 type Workspace struct {
 	gorm.Model
 	Name        string `gorm:"type:varchar(100);not null"`
 	Description string `gorm:"type:varchar(100)"`
+	Version     uint   `gorm:"not null;default:1"`
+	// Organization_id is set for workspaces owned by an organization.
+	Organization_id *uint  `gorm:"index"`
+	Visibility      string `gorm:"type:varchar(20);not null;default:private"`
+	// Public_slug names the public read-only board; it is set only while
+	// the visibility is public-link.
+	Public_slug *string `gorm:"type:varchar(64);uniqueIndex"`
+	// Archived_at is set while the workspace is archived, which makes it
+	// read-only and hides it from the default listing.
+	Archived_at *time.Time `gorm:"index"`
 }
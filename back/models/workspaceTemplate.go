@@ -0,0 +1,56 @@
+package models
+
+import (
+	"gorm.io/gorm"
+)
+
+type TemplateLabel struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+type TemplateTask struct {
+	Title          string   `json:"title"`
+	Description    string   `json:"description"`
+	Status         uint     `json:"status"`
+	Priority       uint     `json:"priority"`
+	Estimated_time string   `json:"estimated_time"`
+	Story_points   uint     `json:"story_points"`
+	Labels         []string `json:"labels"`
+	SubTasks       []string `json:"subtasks"`
+}
+
+type TemplateStatus struct {
+	Value        uint   `json:"value"`
+	Name         string `json:"name"`
+	Category     string `json:"category"`
+	Color        string `json:"color"`
+	Allowed_from []uint `json:"allowed_from"`
+}
+
+type TemplatePriority struct {
+	Value uint   `json:"value"`
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+// TemplateContent is what a new workspace starts with. Without statuses or
+// priorities it gets the default workflow.
+type TemplateContent struct {
+	Labels     []TemplateLabel    `json:"labels"`
+	Tasks      []TemplateTask     `json:"tasks"`
+	Statuses   []TemplateStatus   `json:"statuses,omitempty"`
+	Priorities []TemplatePriority `json:"priorities,omitempty"`
+}
+
+// WorkspaceTemplate is a reusable starting point for workspaces. Built-in
+// templates ship with the server and are available to everyone; others
+// are only available to the user who saved them.
+type WorkspaceTemplate struct {
+	gorm.Model
+	Name        string          `gorm:"type:varchar(100);not null"`
+	Description string          `gorm:"type:varchar(255)"`
+	Built_in    bool            `gorm:"not null;default:false"`
+	User_id     uint            `gorm:"index"`
+	Content     TemplateContent `gorm:"type:jsonb;serializer:json;not null"`
+}
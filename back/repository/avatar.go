@@ -0,0 +1,11 @@
+package repository
+
+import (
+	"github.com/raeinsoltani/gorello/back/models"
+)
+
+type Avatar interface {
+	Save(avatar *models.Avatar) error
+	FindByUserID(user_id uint) (*models.Avatar, error)
+	Delete(user_id uint) error
+}
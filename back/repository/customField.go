@@ -0,0 +1,18 @@
+package repository
+
+import (
+	"github.com/raeinsoltani/gorello/back/models"
+)
+
+type CustomField interface {
+	Create(field *models.CustomField) error
+	FindByID(id uint) (*models.CustomField, error)
+	FindByWorkspaceID(workspace_id uint) ([]*models.CustomField, error)
+	Update(field *models.CustomField) error
+	// Delete deletes the field along with every task's value for it.
+	Delete(id uint) error
+	FindValues(task_id uint) ([]*models.CustomFieldValue, error)
+	// FindValuesByWorkspaceID returns the values of all the workspace's
+	// tasks.
+	FindValuesByWorkspaceID(workspace_id uint) ([]*models.CustomFieldValue, error)
+}
@@ -0,0 +1,23 @@
+package repository
+
+import "errors"
+
+// ErrStaleVersion is returned by Update when the row was changed by someone
+// else since it was read, i.e. its version no longer matches.
+var ErrStaleVersion = errors.New("record was modified by another request")
+
+// ErrLastOwner is returned when a change would leave a workspace without an
+// owner.
+var ErrLastOwner = errors.New("workspace must keep at least one owner")
+
+// ErrLastAdmin is returned when a change would leave an organization that
+// still has members without an admin.
+var ErrLastAdmin = errors.New("organization must keep at least one admin")
+
+// ErrSeatLimit is returned when an organization has no free seat for a new
+// member.
+var ErrSeatLimit = errors.New("organization has no free seats")
+
+// ErrWorkflowInUse is returned when a workflow change would remove a status
+// or priority that tasks still have.
+var ErrWorkflowInUse = errors.New("tasks still have a status or priority that would be removed")
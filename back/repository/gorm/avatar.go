@@ -0,0 +1,31 @@
+package gorm
+
+import (
+	"github.com/raeinsoltani/gorello/back/models"
+	"gorm.io/gorm"
+)
+
+type Avatar struct {
+	db *gorm.DB
+}
+
+func NewAvatarRepo(db *gorm.DB) *Avatar {
+	return &Avatar{db: db}
+}
+
+// Save creates or replaces the user's avatar.
+func (repo *Avatar) Save(avatar *models.Avatar) error {
+	result := repo.db.Save(avatar)
+	return result.Error
+}
+
+func (repo *Avatar) FindByUserID(user_id uint) (*models.Avatar, error) {
+	var avatar models.Avatar
+	result := repo.db.First(&avatar, "user_id = ?", user_id)
+	return &avatar, result.Error
+}
+
+func (repo *Avatar) Delete(user_id uint) error {
+	result := repo.db.Where("user_id = ?", user_id).Delete(&models.Avatar{})
+	return result.Error
+}
@@ -0,0 +1,82 @@
+package gorm
+
+import (
+	"github.com/raeinsoltani/gorello/back/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type CustomField struct {
+	db *gorm.DB
+}
+
+func NewCustomFieldRepo(db *gorm.DB) *CustomField {
+	return &CustomField{db: db}
+}
+
+func (repo *CustomField) Create(field *models.CustomField) error {
+	result := repo.db.Create(field)
+	return result.Error
+}
+
+func (repo *CustomField) FindByID(id uint) (*models.CustomField, error) {
+	var field models.CustomField
+	result := repo.db.First(&field, "id = ?", id)
+	return &field, result.Error
+}
+
+func (repo *CustomField) FindByWorkspaceID(workspace_id uint) ([]*models.CustomField, error) {
+	var fields []*models.CustomField
+	result := repo.db.Order("id").Find(&fields, "workspace_id = ?", workspace_id)
+	return fields, result.Error
+}
+
+func (repo *CustomField) Update(field *models.CustomField) error {
+	result := repo.db.Save(field)
+	return result.Error
+}
+
+func (repo *CustomField) Delete(id uint) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("custom_field_id = ?", id).Delete(&models.CustomFieldValue{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(&models.CustomField{}).Error
+	})
+}
+
+func (repo *CustomField) FindValues(task_id uint) ([]*models.CustomFieldValue, error) {
+	var values []*models.CustomFieldValue
+	result := repo.db.Find(&values, "task_id = ?", task_id)
+	return values, result.Error
+}
+
+func (repo *CustomField) FindValuesByWorkspaceID(workspace_id uint) ([]*models.CustomFieldValue, error) {
+	var values []*models.CustomFieldValue
+	result := repo.db.
+		Joins("JOIN custom_fields ON custom_fields.id = custom_field_values.custom_field_id AND custom_fields.deleted_at IS NULL").
+		Order("custom_field_values.task_id").
+		Find(&values, "custom_fields.workspace_id = ?", workspace_id)
+	return values, result.Error
+}
+
+// setCustomFieldValues saves the task's values, replacing any it had for
+// the same fields, and removes its values for the cleared fields.
+func setCustomFieldValues(tx *gorm.DB, task_id uint, values []*models.CustomFieldValue, cleared []uint) error {
+	if len(cleared) > 0 {
+		err := tx.Where("task_id = ? AND custom_field_id IN ?", task_id, cleared).Delete(&models.CustomFieldValue{}).Error
+		if err != nil {
+			return err
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	for _, value := range values {
+		value.Task_id = task_id
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "task_id"}, {Name: "custom_field_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value"}),
+	}).Create(values).Error
+}
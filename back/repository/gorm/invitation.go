@@ -0,0 +1,110 @@
+package gorm
+
+import (
+	"time"
+
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Invitation struct {
+	db *gorm.DB
+}
+
+func NewInvitationRepo(db *gorm.DB) *Invitation {
+	return &Invitation{db: db}
+}
+
+func (repo *Invitation) Create(invitation *models.Invitation) error {
+	result := repo.db.Create(invitation)
+	return result.Error
+}
+
+func (repo *Invitation) FindByID(id uint) (*models.Invitation, error) {
+	var invitation models.Invitation
+	result := repo.db.First(&invitation, "id = ?", id)
+	return &invitation, result.Error
+}
+
+func (repo *Invitation) FindByHash(hash string) (*models.Invitation, error) {
+	var invitation models.Invitation
+	result := repo.db.First(&invitation, "token_hash = ?", hash)
+	return &invitation, result.Error
+}
+
+// pendingInvitations narrows a query to invitations that can still be
+// accepted.
+func pendingInvitations(db *gorm.DB, now time.Time) *gorm.DB {
+	return db.Where("revoked_at IS NULL AND expires_at > ? AND (max_uses = 0 OR uses < max_uses)", now)
+}
+
+func (repo *Invitation) FindPendingByWorkspaceID(workspace_id uint) ([]*models.Invitation, error) {
+	var invitations []*models.Invitation
+	result := pendingInvitations(repo.db, time.Now()).Order("id").Find(&invitations, "workspace_id = ?", workspace_id)
+	return invitations, result.Error
+}
+
+func (repo *Invitation) Revoke(id uint) error {
+	result := repo.db.Model(&models.Invitation{}).Where("id = ? AND revoked_at IS NULL", id).
+		UpdateColumn("revoked_at", time.Now())
+	return result.Error
+}
+
+// Accept locks the invitation so that concurrent accepts can't use it more
+// often than allowed.
+func (repo *Invitation) Accept(id uint, user_id uint) (*models.UserWorkspaceRole, error) {
+	var role *models.UserWorkspaceRole
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		role, err = acceptInvitation(tx, id, user_id)
+		return err
+	})
+	return role, err
+}
+
+func (repo *Invitation) AcceptNewUser(id uint, user *models.User) (*models.UserWorkspaceRole, error) {
+	var role *models.UserWorkspaceRole
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		var err error
+		role, err = acceptInvitation(tx, id, user.ID)
+		return err
+	})
+	return role, err
+}
+
+func acceptInvitation(tx *gorm.DB, id uint, user_id uint) (*models.UserWorkspaceRole, error) {
+	var invitation models.Invitation
+	result := pendingInvitations(tx.Clauses(clause.Locking{Strength: "UPDATE"}), time.Now()).
+		Limit(1).Find(&invitation, "id = ?", id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, repository.ErrInvitationUnusable
+	}
+
+	var members int64
+	if err := tx.Model(&models.UserWorkspaceRole{}).
+		Where("workspace_id = ? AND user_id = ?", invitation.Workspace_id, user_id).
+		Count(&members).Error; err != nil {
+		return nil, err
+	}
+	if members > 0 {
+		return nil, repository.ErrAlreadyMember
+	}
+
+	role := &models.UserWorkspaceRole{
+		User_id:      user_id,
+		Workspace_id: invitation.Workspace_id,
+		Role:         invitation.Role,
+	}
+	if err := tx.Create(role).Error; err != nil {
+		return nil, err
+	}
+	return role, tx.Model(&invitation).UpdateColumn("uses", gorm.Expr("uses + 1")).Error
+}
@@ -0,0 +1,40 @@
+package gorm
+
+import (
+	"github.com/raeinsoltani/gorello/back/models"
+	"gorm.io/gorm"
+)
+
+type Label struct {
+	db *gorm.DB
+}
+
+func NewLabelRepo(db *gorm.DB) *Label {
+	return &Label{db: db}
+}
+
+func (repo *Label) Create(label *models.Label) error {
+	result := repo.db.Create(label)
+	return result.Error
+}
+
+func (repo *Label) FindByID(id uint) (*models.Label, error) {
+	var label models.Label
+	result := repo.db.First(&label, "id = ?", id)
+	return &label, result.Error
+}
+
+func (repo *Label) FindByWorkspaceID(workspace_id uint) ([]*models.Label, error) {
+	var labels []*models.Label
+	result := repo.db.Find(&labels, "workspace_id = ?", workspace_id)
+	return labels, result.Error
+}
+
+func (repo *Label) Delete(id uint) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM task_labels WHERE label_id = ?", id).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", id).Delete(&models.Label{}).Error
+	})
+}
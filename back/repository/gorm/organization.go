@@ -0,0 +1,183 @@
+package gorm
+
+import (
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Organization struct {
+	db *gorm.DB
+}
+
+func NewOrganizationRepo(db *gorm.DB) *Organization {
+	return &Organization{db: db}
+}
+
+func (repo *Organization) Create(organization *models.Organization, creator_id uint) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(organization).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.OrganizationMember{
+			Organization_id: organization.ID,
+			User_id:         creator_id,
+			Role:            1,
+		}).Error
+	})
+}
+
+func (repo *Organization) FindByID(id uint) (*models.Organization, error) {
+	var organization models.Organization
+	result := repo.db.First(&organization, "id = ?", id)
+	return &organization, result.Error
+}
+
+func (repo *Organization) FindByUserID(user_id uint) ([]*models.Organization, error) {
+	var organizations []*models.Organization
+	memberOf := repo.db.Model(&models.OrganizationMember{}).Select("organization_id").Where("user_id = ?", user_id)
+	result := repo.db.Order("id").Find(&organizations, "id IN (?)", memberOf)
+	return organizations, result.Error
+}
+
+// lockMembers locks the organization row so that concurrent membership
+// changes are serialized, and returns its members.
+func lockMembers(tx *gorm.DB, organization_id uint) (*models.Organization, []*models.OrganizationMember, error) {
+	var organization models.Organization
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&organization, "id = ?", organization_id).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var members []*models.OrganizationMember
+	err := tx.Find(&members, "organization_id = ?", organization_id).Error
+	return &organization, members, err
+}
+
+// soleAdmin reports whether user_id is the only admin among members that
+// include someone else.
+func soleAdmin(members []*models.OrganizationMember, user_id uint) bool {
+	admins := 0
+	isAdmin := false
+	for _, member := range members {
+		if member.Role == 1 {
+			admins++
+			isAdmin = isAdmin || member.User_id == user_id
+		}
+	}
+	return isAdmin && admins == 1 && len(members) > 1
+}
+
+func (repo *Organization) Update(organization *models.Organization) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		_, members, err := lockMembers(tx, organization.ID)
+		if err != nil {
+			return err
+		}
+		if organization.Seat_limit != 0 && uint(len(members)) > organization.Seat_limit {
+			return repository.ErrSeatLimit
+		}
+		return tx.Model(organization).Select("name", "seat_limit").Updates(organization).Error
+	})
+}
+
+func (repo *Organization) Delete(id uint) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		// Workspaces open to the organization become private again.
+		if err := tx.Model(&models.Workspace{}).Where("organization_id = ? AND visibility = ?", id, models.WorkspaceVisibilityOrganization).
+			Update("visibility", models.WorkspaceVisibilityPrivate).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Workspace{}).Where("organization_id = ?", id).
+			Update("organization_id", nil).Error; err != nil {
+			return err
+		}
+
+		teamIDs := tx.Model(&models.Team{}).Select("id").Where("organization_id = ?", id)
+		if err := tx.Where("team_id IN (?)", teamIDs).Delete(&models.TeamWorkspaceRole{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("team_id IN (?)", teamIDs).Delete(&models.TeamMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("organization_id = ?", id).Delete(&models.Team{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("organization_id = ?", id).Delete(&models.OrganizationMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Organization{}, id).Error
+	})
+}
+
+func (repo *Organization) FindMembers(organization_id uint) ([]*models.OrganizationMember, error) {
+	var members []*models.OrganizationMember
+	result := repo.db.Order("user_id").Find(&members, "organization_id = ?", organization_id)
+	return members, result.Error
+}
+
+func (repo *Organization) FindMember(organization_id uint, user_id uint) (*models.OrganizationMember, error) {
+	var member models.OrganizationMember
+	result := repo.db.Limit(1).Find(&member, "organization_id = ? AND user_id = ?", organization_id, user_id)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+	return &member, nil
+}
+
+func (repo *Organization) SetMember(organization_id uint, user_id uint, role uint) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		organization, members, err := lockMembers(tx, organization_id)
+		if err != nil {
+			return err
+		}
+
+		for _, member := range members {
+			if member.User_id != user_id {
+				continue
+			}
+			if role != 1 && soleAdmin(members, user_id) {
+				return repository.ErrLastAdmin
+			}
+			return tx.Model(member).Update("role", role).Error
+		}
+
+		if organization.Seat_limit != 0 && uint(len(members)) >= organization.Seat_limit {
+			return repository.ErrSeatLimit
+		}
+		return tx.Create(&models.OrganizationMember{
+			Organization_id: organization_id,
+			User_id:         user_id,
+			Role:            role,
+		}).Error
+	})
+}
+
+func (repo *Organization) RemoveMember(organization_id uint, user_id uint) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		_, members, err := lockMembers(tx, organization_id)
+		if err != nil {
+			return err
+		}
+		if soleAdmin(members, user_id) {
+			return repository.ErrLastAdmin
+		}
+
+		teamIDs := tx.Model(&models.Team{}).Select("id").Where("organization_id = ?", organization_id)
+		if err := tx.Where("user_id = ? AND team_id IN (?)", user_id, teamIDs).Delete(&models.TeamMember{}).Error; err != nil {
+			return err
+		}
+
+		result := tx.Where("organization_id = ? AND user_id = ?", organization_id, user_id).Delete(&models.OrganizationMember{})
+		if result.Error == nil && result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return result.Error
+	})
+}
+
+func (repo *Organization) FindWorkspaces(organization_id uint) ([]*models.Workspace, error) {
+	var workspaces []*models.Workspace
+	result := repo.db.Order("id").Find(&workspaces, "organization_id = ?", organization_id)
+	return workspaces, result.Error
+}
@@ -0,0 +1,53 @@
+package gorm
+
+import (
+	"time"
+
+	"github.com/raeinsoltani/gorello/back/models"
+	"gorm.io/gorm"
+)
+
+type PersonalAccessToken struct {
+	db *gorm.DB
+}
+
+func NewPersonalAccessTokenRepo(db *gorm.DB) *PersonalAccessToken {
+	return &PersonalAccessToken{db: db}
+}
+
+func (repo *PersonalAccessToken) Create(token *models.PersonalAccessToken) error {
+	result := repo.db.Create(token)
+	return result.Error
+}
+
+func (repo *PersonalAccessToken) FindByID(id uint) (*models.PersonalAccessToken, error) {
+	var token models.PersonalAccessToken
+	result := repo.db.First(&token, "id = ?", id)
+	return &token, result.Error
+}
+
+func (repo *PersonalAccessToken) FindByUserID(user_id uint) ([]*models.PersonalAccessToken, error) {
+	var tokens []*models.PersonalAccessToken
+	result := repo.db.Order("id").Find(&tokens, "user_id = ?", user_id)
+	return tokens, result.Error
+}
+
+func (repo *PersonalAccessToken) FindByHash(hash string) (*models.PersonalAccessToken, error) {
+	var token models.PersonalAccessToken
+	result := repo.db.First(&token, "token_hash = ?", hash)
+	return &token, result.Error
+}
+
+func (repo *PersonalAccessToken) Touch(id uint, used_at time.Time) error {
+	result := repo.db.Model(&models.PersonalAccessToken{}).Where("id = ?", id).
+		UpdateColumn("last_used_at", used_at)
+	return result.Error
+}
+
+// Revoke marks the token as revoked. The row is kept so that its name and
+// last use stay visible to the owner.
+func (repo *PersonalAccessToken) Revoke(id uint) error {
+	result := repo.db.Model(&models.PersonalAccessToken{}).Where("id = ? AND revoked_at IS NULL", id).
+		UpdateColumn("revoked_at", time.Now())
+	return result.Error
+}
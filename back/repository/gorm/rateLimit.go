@@ -0,0 +1,85 @@
+package gorm
+
+import (
+	"time"
+
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RateLimit keeps rate limit buckets and lockouts in Postgres so that they
+// are shared by every instance of the API.
+type RateLimit struct {
+	db *gorm.DB
+}
+
+func NewRateLimitRepo(db *gorm.DB) *RateLimit {
+	return &RateLimit{db: db}
+}
+
+func (repo *RateLimit) Take(key string, policy repository.RateLimitPolicy) (time.Duration, error) {
+	var wait time.Duration
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.RateLimitBucket{Key: key, Tokens: float64(policy.Events), Updated_at: now}).Error; err != nil {
+			return err
+		}
+
+		var bucket models.RateLimitBucket
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&bucket, "key = ?", key).Error; err != nil {
+			return err
+		}
+
+		bucket.Tokens, wait = policy.Take(bucket.Tokens, now.Sub(bucket.Updated_at))
+		bucket.Updated_at = now
+		return tx.Save(&bucket).Error
+	})
+	return wait, err
+}
+
+func (repo *RateLimit) LockedFor(key string) (time.Duration, error) {
+	var failure models.LoginFailure
+	result := repo.db.Limit(1).Find(&failure, "key = ?", key)
+	if result.Error != nil || failure.Locked_until == nil {
+		return 0, result.Error
+	}
+	return time.Until(*failure.Locked_until), nil
+}
+
+func (repo *RateLimit) RecordFailure(key string, policy repository.LockoutPolicy) (time.Duration, error) {
+	var lockout time.Duration
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.LoginFailure{Key: key, Updated_at: now}).Error; err != nil {
+			return err
+		}
+
+		var failure models.LoginFailure
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&failure, "key = ?", key).Error; err != nil {
+			return err
+		}
+
+		// Failures older than the longest lockout are forgotten.
+		if now.Sub(failure.Updated_at) > policy.Max {
+			failure.Failures = 0
+		}
+		failure.Failures++
+		failure.Updated_at = now
+		lockout = policy.Duration(failure.Failures)
+		if lockout > 0 {
+			lockedUntil := now.Add(lockout)
+			failure.Locked_until = &lockedUntil
+		}
+		return tx.Save(&failure).Error
+	})
+	return lockout, err
+}
+
+func (repo *RateLimit) ResetFailures(key string) error {
+	result := repo.db.Where("key = ?", key).Delete(&models.LoginFailure{})
+	return result.Error
+}
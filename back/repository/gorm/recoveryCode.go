@@ -0,0 +1,50 @@
+package gorm
+
+import (
+	"time"
+
+	"github.com/raeinsoltani/gorello/back/models"
+	"gorm.io/gorm"
+)
+
+type RecoveryCode struct {
+	db *gorm.DB
+}
+
+func NewRecoveryCodeRepo(db *gorm.DB) *RecoveryCode {
+	return &RecoveryCode{db: db}
+}
+
+// Replace swaps the user's recovery codes for a new set.
+func (repo *RecoveryCode) Replace(user_id uint, hashes []string) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", user_id).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		codes := make([]*models.RecoveryCode, len(hashes))
+		for i, hash := range hashes {
+			codes[i] = &models.RecoveryCode{User_id: user_id, Code_hash: hash}
+		}
+		return tx.Create(codes).Error
+	})
+}
+
+// Use marks an unused code as used, reporting whether there was one. The
+// conditional update makes concurrent uses of one code fail safely.
+func (repo *RecoveryCode) Use(user_id uint, hash string) (bool, error) {
+	result := repo.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user_id, hash).
+		UpdateColumn("used_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+func (repo *RecoveryCode) CountUnused(user_id uint) (int64, error) {
+	var count int64
+	result := repo.db.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", user_id).Count(&count)
+	return count, result.Error
+}
+
+func (repo *RecoveryCode) DeleteByUserID(user_id uint) error {
+	result := repo.db.Unscoped().Where("user_id = ?", user_id).Delete(&models.RecoveryCode{})
+	return result.Error
+}
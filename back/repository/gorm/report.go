@@ -0,0 +1,120 @@
+package gorm
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+	"gorm.io/gorm"
+)
+
+// hoursSQL converts a free-form duration column such as "3", "2.5h", "90m"
+// or "1d" (a working day of 8 hours) into hours, or NULL when it can't.
+func hoursSQL(column string) string {
+	number := fmt.Sprintf("substring(%s from '[0-9]+(?:\\.[0-9]+)?')::numeric", column)
+	return fmt.Sprintf(`CASE
+		WHEN %[1]s ~* '^\s*[0-9]+(\.[0-9]+)?\s*h?\s*$' THEN %[2]s
+		WHEN %[1]s ~* '^\s*[0-9]+(\.[0-9]+)?\s*m\s*$' THEN %[2]s / 60
+		WHEN %[1]s ~* '^\s*[0-9]+(\.[0-9]+)?\s*d\s*$' THEN %[2]s * 8
+	END`, column, number)
+}
+
+type Report struct {
+	db *gorm.DB
+}
+
+func NewReportRepo(db *gorm.DB) *Report {
+	return &Report{db: db}
+}
+
+func (repo *Report) countBy(workspace_id uint, column string) ([]repository.CountDTO, error) {
+	counts := make([]repository.CountDTO, 0)
+	result := repo.db.Model(&models.Task{}).
+		Select(column+" AS key, count(*) AS count").
+		Where("workspace_id = ?", workspace_id).
+		Group(column).Order(column).
+		Scan(&counts)
+	return counts, result.Error
+}
+
+func (repo *Report) TaskCounts(workspace_id uint) (*repository.TaskCountsDTO, error) {
+	var counts repository.TaskCountsDTO
+	var err error
+
+	if counts.ByStatus, err = repo.countBy(workspace_id, "status"); err != nil {
+		return nil, err
+	}
+	if counts.ByPriority, err = repo.countBy(workspace_id, "priority"); err != nil {
+		return nil, err
+	}
+	if counts.ByAssignee, err = repo.countBy(workspace_id, "assignee_id"); err != nil {
+		return nil, err
+	}
+	for _, count := range counts.ByStatus {
+		counts.Total += count.Count
+	}
+
+	// Due dates are free text; only ones starting with an ISO date count.
+	result := repo.db.Model(&models.Task{}).
+		Where("workspace_id = ? AND status NOT IN (?)", workspace_id, statusesIn(repo.db, workspace_id, models.StatusCategoryDone)).
+		Where(`due_date ~ '^\d{4}-\d{2}-\d{2}' AND left(due_date, 10) < to_char(CURRENT_DATE, 'YYYY-MM-DD')`).
+		Count(&counts.Overdue)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &counts, nil
+}
+
+// firstMoveTo selects, per task of the workspace, when it first reached a
+// status of the category.
+func (repo *Report) firstMoveTo(workspace_id uint, category string) *gorm.DB {
+	return repo.db.Model(&models.TaskActivity{}).
+		Select("task_id, min(created_at) AS moved_at").
+		Where("workspace_id = ? AND to_status IN (?)", workspace_id, statusesIn(repo.db, workspace_id, category)).
+		Group("task_id")
+}
+
+func (repo *Report) Throughput(workspace_id uint, since time.Time) ([]*repository.ThroughputDTO, error) {
+	weeks := make([]*repository.ThroughputDTO, 0)
+	result := repo.db.Table("(?) AS done", repo.firstMoveTo(workspace_id, models.StatusCategoryDone)).
+		Select("date_trunc('week', done.moved_at) AS week, count(*) AS completed").
+		Where("done.moved_at >= ?", since).
+		Group("week").Order("week").
+		Scan(&weeks)
+	return weeks, result.Error
+}
+
+func (repo *Report) CycleTime(workspace_id uint, since time.Time) (*repository.CycleTimeDTO, error) {
+	var cycleTime repository.CycleTimeDTO
+	result := repo.db.Table("(?) AS done", repo.firstMoveTo(workspace_id, models.StatusCategoryDone)).
+		Select(`count(*) AS completed,
+			avg(extract(epoch FROM done.moved_at - tasks.created_at)) / 3600 AS avg_lead_time_hours,
+			avg(extract(epoch FROM done.moved_at - started.moved_at)) / 3600 AS avg_cycle_time_hours`).
+		Joins("JOIN tasks ON tasks.id = done.task_id AND tasks.deleted_at IS NULL").
+		Joins("LEFT JOIN (?) AS started ON started.task_id = done.task_id AND started.moved_at <= done.moved_at",
+			repo.firstMoveTo(workspace_id, models.StatusCategoryInProgress)).
+		Where("done.moved_at >= ?", since).
+		Scan(&cycleTime)
+	return &cycleTime, result.Error
+}
+
+func (repo *Report) TimeTracking(workspace_id uint) (*repository.TimeTrackingDTO, error) {
+	var timeTracking repository.TimeTrackingDTO
+	hours := repo.db.Model(&models.Task{}).
+		Select(hoursSQL("estimated_time")+" AS estimated, "+hoursSQL("actual_time")+" AS actual").
+		Where("workspace_id = ?", workspace_id)
+	result := repo.db.Table("(?) AS hours", hours).
+		Select("count(*) AS tasks, coalesce(sum(estimated), 0) AS estimated_hours, coalesce(sum(actual), 0) AS actual_hours").
+		Where("estimated IS NOT NULL AND actual IS NOT NULL").
+		Scan(&timeTracking)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	if timeTracking.ActualHours > 0 {
+		accuracy := timeTracking.EstimatedHours / timeTracking.ActualHours
+		timeTracking.Accuracy = &accuracy
+	}
+	return &timeTracking, nil
+}
@@ -0,0 +1,178 @@
+package gorm
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+	"gorm.io/gorm"
+)
+
+const headlineOptions = "StartSel=<mark>, StopSel=</mark>, MaxWords=35, MinWords=15"
+
+type Search struct {
+	db *gorm.DB
+}
+
+func NewSearchRepo(db *gorm.DB) *Search {
+	return &Search{db: db}
+}
+
+// Search runs the query against tasks, subtasks and workspaces the user is a
+// member of and merges the results by rank.
+func (repo *Search) Search(query repository.SearchQuery) ([]*repository.SearchResultDTO, error) {
+	types := map[string]bool{"task": true, "subtask": true, "workspace": true}
+	if len(query.Types) > 0 {
+		types = make(map[string]bool)
+		for _, t := range query.Types {
+			types[t] = true
+		}
+	}
+	if query.HasTaskFilters() {
+		types["workspace"] = false
+	}
+
+	var results []*repository.SearchResultDTO
+	if types["task"] {
+		var tasks []*repository.SearchResultDTO
+		if err := repo.tasks(query).Scan(&tasks).Error; err != nil {
+			return nil, err
+		}
+		results = append(results, tasks...)
+	}
+	if types["subtask"] {
+		var subTasks []*repository.SearchResultDTO
+		if err := repo.subTasks(query).Scan(&subTasks).Error; err != nil {
+			return nil, err
+		}
+		results = append(results, subTasks...)
+	}
+	if types["workspace"] {
+		var workspaces []*repository.SearchResultDTO
+		if err := repo.workspaces(query).Scan(&workspaces).Error; err != nil {
+			return nil, err
+		}
+		results = append(results, workspaces...)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Rank > results[j].Rank })
+	if query.Limit > 0 && len(results) > query.Limit {
+		results = results[:query.Limit]
+	}
+	return results, nil
+}
+
+func (repo *Search) accessible(query repository.SearchQuery) *gorm.DB {
+	return repo.db.Raw("SELECT workspace_id FROM (?) roles WHERE user_id = ?",
+		effectiveRoles(repo.db, false), query.User_id)
+}
+
+// escapeHTML wraps a text expression so that it is safe to embed in HTML.
+func escapeHTML(expr string) string {
+	for _, entity := range [][2]string{{"&", "&amp;"}, {"<", "&lt;"}, {">", "&gt;"}, {`"`, "&quot;"}} {
+		expr = "replace(" + expr + ", '" + entity[0] + "', '" + entity[1] + "')"
+	}
+	return expr
+}
+
+// matching adds the full-text condition, rank and snippet columns to a
+// query over table. The snippet is HTML: the escaped document with the
+// matches in <mark> tags. Without text every row matches with rank 0 and
+// the escaped title as snippet.
+func matching(db *gorm.DB, query repository.SearchQuery, table, document, title, columns string) *gorm.DB {
+	if query.Limit > 0 {
+		db = db.Limit(query.Limit)
+	}
+	if query.Text == "" {
+		return db.Select(columns + ", " + title + " AS title, " + escapeHTML(title) + " AS snippet, 0 AS rank").
+			Order(table + ".updated_at DESC")
+	}
+	tsQuery := "websearch_to_tsquery('english', ?)"
+	return db.Select(columns+", "+title+" AS title, "+
+		"ts_headline('english', "+escapeHTML(document)+", "+tsQuery+", ?) AS snippet, "+
+		"ts_rank("+table+".search_vector, "+tsQuery+") AS rank",
+		query.Text, headlineOptions, query.Text).
+		Where(table+".search_vector @@ "+tsQuery, query.Text).
+		Order("rank DESC")
+}
+
+func filterTasks(db *gorm.DB, query repository.SearchQuery) *gorm.DB {
+	if query.Status != nil {
+		db = db.Where("tasks.status = ?", *query.Status)
+	}
+	if query.Status_name != "" {
+		db = filterStatusName(db, query.Status_name)
+	}
+	if query.Priority != nil {
+		db = db.Where("tasks.priority = ?", *query.Priority)
+	}
+	if query.DueOn != "" {
+		db = db.Where("tasks.due_date LIKE ?", query.DueOn+"%")
+	}
+	if query.DueBefore != "" {
+		db = db.Where("tasks.due_date <> '' AND tasks.due_date < ?", query.DueBefore)
+	}
+	if query.DueAfter != "" {
+		db = db.Where("tasks.due_date > ?", query.DueAfter)
+	}
+	if query.Workspace_id != nil {
+		db = db.Where("tasks.workspace_id = ?", *query.Workspace_id)
+	}
+	return db
+}
+
+// filterStatusName keeps the tasks whose status has the name, compared
+// case-insensitively with underscores standing for spaces, or the category
+// in their workspace's workflow or, without one, in the default statuses.
+func filterStatusName(db *gorm.DB, name string) *gorm.DB {
+	name = strings.ToLower(name)
+	spaced := strings.ReplaceAll(name, "_", " ")
+
+	defaults := []uint{}
+	for _, status := range models.DefaultWorkflowStatuses() {
+		if statusName := strings.ToLower(status.Name); statusName == name || statusName == spaced || status.Category == name {
+			defaults = append(defaults, status.Value)
+		}
+	}
+
+	return db.Where("EXISTS (SELECT 1 FROM workflow_statuses ws WHERE ws.deleted_at IS NULL AND "+
+		"ws.workspace_id = tasks.workspace_id AND ws.value = tasks.status AND "+
+		"(lower(ws.name) IN (?, ?) OR ws.category = ?)) OR "+
+		"(NOT EXISTS (SELECT 1 FROM workflow_statuses ws WHERE ws.deleted_at IS NULL AND "+
+		"ws.workspace_id = tasks.workspace_id) AND tasks.status IN (?))",
+		name, spaced, name, defaults)
+}
+
+func (repo *Search) tasks(query repository.SearchQuery) *gorm.DB {
+	db := repo.db.Table("tasks").
+		Where("tasks.deleted_at IS NULL AND tasks.workspace_id IN (?)", repo.accessible(query))
+	db = matching(db, query, "tasks", "tasks.title || ' ' || coalesce(tasks.description, '')", "tasks.title",
+		"'task' AS type, tasks.id, tasks.workspace_id, 0 AS task_id, tasks.archived_at IS NOT NULL AS archived")
+	if query.Assignee_id != nil {
+		db = db.Where("tasks.assignee_id = ?", *query.Assignee_id)
+	}
+	return filterTasks(db, query)
+}
+
+func (repo *Search) subTasks(query repository.SearchQuery) *gorm.DB {
+	db := repo.db.Table("sub_tasks").
+		Joins("JOIN tasks ON tasks.id = sub_tasks.task_id AND tasks.deleted_at IS NULL").
+		Where("sub_tasks.deleted_at IS NULL AND tasks.workspace_id IN (?)", repo.accessible(query))
+	db = matching(db, query, "sub_tasks", "sub_tasks.title", "sub_tasks.title",
+		"'subtask' AS type, sub_tasks.id, tasks.workspace_id, sub_tasks.task_id, tasks.archived_at IS NOT NULL AS archived")
+	if query.Assignee_id != nil {
+		db = db.Where("sub_tasks.assignee_id = ?", *query.Assignee_id)
+	}
+	return filterTasks(db, query)
+}
+
+func (repo *Search) workspaces(query repository.SearchQuery) *gorm.DB {
+	db := repo.db.Table("workspaces").
+		Where("workspaces.deleted_at IS NULL AND workspaces.id IN (?)", repo.accessible(query))
+	if query.Workspace_id != nil {
+		db = db.Where("workspaces.id = ?", *query.Workspace_id)
+	}
+	return matching(db, query, "workspaces", "workspaces.name || ' ' || coalesce(workspaces.description, '')", "workspaces.name",
+		"'workspace' AS type, workspaces.id, workspaces.id AS workspace_id, 0 AS task_id, workspaces.archived_at IS NOT NULL AS archived")
+}
@@ -0,0 +1,57 @@
+package gorm
+
+import (
+	"time"
+
+	"github.com/raeinsoltani/gorello/back/models"
+	"gorm.io/gorm"
+)
+
+type Session struct {
+	db *gorm.DB
+}
+
+func NewSessionRepo(db *gorm.DB) *Session {
+	return &Session{db: db}
+}
+
+func (repo *Session) Create(session *models.Session) error {
+	result := repo.db.Create(session)
+	return result.Error
+}
+
+func (repo *Session) FindByID(id uint) (*models.Session, error) {
+	var session models.Session
+	result := repo.db.First(&session, "id = ?", id)
+	return &session, result.Error
+}
+
+// FindActiveByUserID returns the user's sessions that are neither revoked
+// nor expired, most recently seen first.
+func (repo *Session) FindActiveByUserID(user_id uint) ([]*models.Session, error) {
+	var sessions []*models.Session
+	result := repo.db.Order("last_seen_at DESC").
+		Find(&sessions, "user_id = ? AND revoked_at IS NULL AND expires_at > ?", user_id, time.Now())
+	return sessions, result.Error
+}
+
+func (repo *Session) Touch(id uint, seen_at time.Time, ip string) error {
+	result := repo.db.Model(&models.Session{}).Where("id = ?", id).
+		UpdateColumns(map[string]interface{}{"last_seen_at": seen_at, "ip": ip})
+	return result.Error
+}
+
+func (repo *Session) Revoke(id uint) error {
+	result := repo.db.Model(&models.Session{}).Where("id = ? AND revoked_at IS NULL", id).
+		UpdateColumn("revoked_at", time.Now())
+	return result.Error
+}
+
+// RevokeAllExcept revokes every session of the user but keep_id, which may
+// be 0 to revoke them all.
+func (repo *Session) RevokeAllExcept(user_id uint, keep_id uint) error {
+	result := repo.db.Model(&models.Session{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL", user_id, keep_id).
+		UpdateColumn("revoked_at", time.Now())
+	return result.Error
+}
@@ -0,0 +1,137 @@
+package gorm
+
+import (
+	"time"
+
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+	"gorm.io/gorm"
+)
+
+type Sprint struct {
+	db *gorm.DB
+}
+
+func NewSprintRepo(db *gorm.DB) *Sprint {
+	return &Sprint{db: db}
+}
+
+func (repo *Sprint) Create(sprint *models.Sprint) error {
+	result := repo.db.Create(sprint)
+	return result.Error
+}
+
+func (repo *Sprint) FindByID(id uint) (*models.Sprint, error) {
+	var sprint models.Sprint
+	result := repo.db.First(&sprint, "id = ?", id)
+	return &sprint, result.Error
+}
+
+func (repo *Sprint) FindByWorkspaceID(workspace_id uint) ([]*models.Sprint, error) {
+	var sprints []*models.Sprint
+	result := repo.db.Order("start_date").Find(&sprints, "workspace_id = ?", workspace_id)
+	return sprints, result.Error
+}
+
+func (repo *Sprint) Update(sprint *models.Sprint) error {
+	result := repo.db.Save(sprint)
+	return result.Error
+}
+
+// Complete closes the sprint, recording its committed and completed story
+// points, and moves its unfinished tasks to the rollover sprint (0 for the
+// backlog).
+func (repo *Sprint) Complete(sprint *models.Sprint, rollover_sprint_id uint) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		done := statusesIn(tx, sprint.Workspace_id, models.StatusCategoryDone)
+		var points struct {
+			Committed uint
+			Completed uint
+		}
+		if err := tx.Model(&models.Task{}).
+			Select("coalesce(sum(story_points), 0) AS committed, "+
+				"coalesce(sum(story_points) FILTER (WHERE status IN (?)), 0) AS completed", done).
+			Where("sprint_id = ?", sprint.ID).
+			Scan(&points).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&models.Task{}).
+			Where("sprint_id = ? AND status NOT IN (?)", sprint.ID, done).
+			Updates(map[string]interface{}{
+				"sprint_id": rollover_sprint_id,
+				"version":   gorm.Expr("version + 1"),
+			}).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		sprint.State = models.SprintCompleted
+		sprint.Completed_at = &now
+		sprint.Committed_points = points.Committed
+		sprint.Completed_points = points.Completed
+		return tx.Save(sprint).Error
+	})
+}
+
+// Burndown replays the status history of the sprint's tasks day by day,
+// from the start date up to today or the end date, whichever comes first.
+func (repo *Sprint) Burndown(sprint *models.Sprint) ([]*repository.BurndownPointDTO, error) {
+	points := make([]*repository.BurndownPointDTO, 0)
+	if sprint.State == models.SprintPlanned {
+		return points, nil
+	}
+
+	last := sprint.End_date
+	if sprint.Completed_at != nil && sprint.Completed_at.Before(last) {
+		last = *sprint.Completed_at
+	}
+	if today := time.Now(); today.Before(last) {
+		last = today
+	}
+
+	done := statusesIn(repo.db, sprint.Workspace_id, models.StatusCategoryDone)
+	result := repo.db.Raw(`SELECT day,
+			coalesce(sum(tasks.story_points) FILTER (WHERE latest.to_status IN (?)), 0) AS completed_points,
+			count(tasks.id) FILTER (WHERE latest.to_status IN (?)) AS completed_tasks
+		FROM generate_series(?::date, ?::date, interval '1 day') AS day
+		LEFT JOIN tasks ON tasks.sprint_id = ? AND tasks.deleted_at IS NULL
+		LEFT JOIN LATERAL (
+			SELECT to_status FROM task_activities
+			WHERE task_activities.task_id = tasks.id AND task_activities.created_at < day + interval '1 day'
+			ORDER BY task_activities.created_at DESC LIMIT 1
+		) AS latest ON true
+		GROUP BY day ORDER BY day`,
+		done, done,
+		sprint.Start_date.Format("2006-01-02"), last.Format("2006-01-02"), sprint.ID).
+		Scan(&points)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	var total uint
+	if err := repo.db.Model(&models.Task{}).Select("coalesce(sum(story_points), 0)").
+		Where("sprint_id = ?", sprint.ID).Scan(&total).Error; err != nil {
+		return nil, err
+	}
+	// Tasks rolled over at completion have left the sprint, so fall back to
+	// the points recorded when it was closed.
+	if sprint.Committed_points > total {
+		total = sprint.Committed_points
+	}
+
+	days := sprint.End_date.Sub(sprint.Start_date).Hours()/24 + 1
+	for i, point := range points {
+		point.Total_points = total
+		if point.Completed_points < total {
+			point.Remaining_points = total - point.Completed_points
+		}
+		if days > 1 {
+			point.Ideal_remaining = float64(total) * (1 - float64(i)/(days-1))
+		}
+		if point.Ideal_remaining < 0 {
+			point.Ideal_remaining = 0
+		}
+	}
+	return points, nil
+}
@@ -1,8 +1,13 @@
 package gorm
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Task struct {
@@ -13,9 +18,58 @@ func NewTaskRepo(db *gorm.DB) *Task {
 	return &Task{db: db}
 }
 
+// recordStatusChange writes the activity entry for a task that has just
+// moved from the given status to its current one.
+func recordStatusChange(tx *gorm.DB, task *models.Task, from *uint) error {
+	return tx.Create(&models.TaskActivity{
+		Task_id:      task.ID,
+		Workspace_id: task.Workspace_id,
+		From_status:  from,
+		To_status:    task.Status,
+	}).Error
+}
+
 func (repo *Task) Create(task *models.Task) error {
-	result := repo.db.Create(task)
-	return result.Error
+	return repo.CreateWithCustomFields(task, nil)
+}
+
+func (repo *Task) CreateWithCustomFields(task *models.Task, values []*models.CustomFieldValue) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(task).Error; err != nil {
+			return err
+		}
+		if err := recordStatusChange(tx, task, nil); err != nil {
+			return err
+		}
+		return setCustomFieldValues(tx, task.ID, values, nil)
+	})
+}
+
+// CreateBatch creates all tasks in one transaction.
+func (repo *Task) CreateBatch(tasks []*models.Task) error {
+	return repo.CreateBatchWithCustomFields(tasks, nil)
+}
+
+func (repo *Task) CreateBatchWithCustomFields(tasks []*models.Task, values [][]*models.CustomFieldValue) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(tasks).Error; err != nil {
+			return err
+		}
+		for i, task := range tasks {
+			if err := recordStatusChange(tx, task, nil); err != nil {
+				return err
+			}
+			if i < len(values) {
+				if err := setCustomFieldValues(tx, task.ID, values[i], nil); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
 }
 
 func (repo *Task) FindByID(id uint) (*models.Task, error) {
@@ -30,12 +84,234 @@ func (repo *Task) FindByWorkspaceID(id uint) ([]*models.Task, error) {
 	return tasks, result.Error
 }
 
+// FindByWorkspaceIDWithDetails is FindByWorkspaceID with subtasks and labels
+// preloaded.
+func (repo *Task) FindByWorkspaceIDWithDetails(id uint) ([]*models.Task, error) {
+	var tasks []*models.Task
+	result := repo.db.Preload("SubTasks").Preload("Labels").Find(&tasks, "workspace_id = ?", id)
+	return tasks, result.Error
+}
+
+// FindByAssigneeID returns the tasks assigned to the user, or with a subtask
+// assigned to them, across all workspaces.
+func (repo *Task) FindByAssigneeID(user_id uint) ([]*models.Task, error) {
+	var tasks []*models.Task
+	subTaskTaskIDs := repo.db.Model(&models.SubTask{}).Select("task_id").Where("assignee_id = ?", user_id)
+	result := repo.db.Preload("SubTasks").Preload("Labels").
+		Where("assignee_id = ? OR id IN (?)", user_id, subTaskTaskIDs).
+		Order("id").Find(&tasks)
+	return tasks, result.Error
+}
+
+func (repo *Task) FindByFilter(workspace_id uint, filter repository.TaskFilter) ([]*models.Task, error) {
+	var tasks []*models.Task
+	query := repo.db.Preload("Labels").Where("workspace_id = ?", workspace_id)
+	if filter.Status != nil {
+		query = query.Where("status = ?", *filter.Status)
+	}
+	if filter.Priority != nil {
+		query = query.Where("priority = ?", *filter.Priority)
+	}
+	if filter.Assignee_id != nil {
+		query = query.Where("assignee_id = ?", *filter.Assignee_id)
+	}
+	if filter.Sprint_id != nil {
+		query = query.Where("sprint_id = ?", *filter.Sprint_id)
+	}
+	if filter.Archived != nil {
+		if *filter.Archived {
+			query = query.Where("archived_at IS NOT NULL")
+		} else {
+			query = query.Where("archived_at IS NULL")
+		}
+	}
+	for _, field := range filter.Custom_fields {
+		query = query.Where("EXISTS (SELECT 1 FROM custom_field_values WHERE task_id = tasks.id AND custom_field_id = ? AND value @> ?::jsonb)",
+			field.Field_id, field.Value)
+	}
+	if filter.Sort_field != nil {
+		direction := "ASC"
+		if filter.Sort_desc {
+			direction = "DESC"
+		}
+		query = query.Select("tasks.*").
+			Joins("LEFT JOIN custom_field_values AS sort_value ON sort_value.task_id = tasks.id AND sort_value.custom_field_id = ?", *filter.Sort_field).
+			Order("sort_value.value " + direction + " NULLS LAST")
+	}
+	result := query.Order("tasks.id").Find(&tasks)
+	return tasks, result.Error
+}
+
 func (repo *Task) Update(task *models.Task) error {
-	result := repo.db.Save(task)
-	return result.Error
+	return repo.UpdateWithCustomFields(task, nil, nil)
+}
+
+func (repo *Task) UpdateWithCustomFields(task *models.Task, values []*models.CustomFieldValue, cleared []uint) error {
+	version := task.Version
+	task.Version++
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		var previous models.Task
+		if err := tx.Select("status").First(&previous, "id = ?", task.ID).Error; err != nil {
+			return err
+		}
+
+		result := tx.Model(task).Where("version = ?", version).Select("*").Omit(clause.Associations).Updates(task)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return repository.ErrStaleVersion
+		}
+
+		if previous.Status != task.Status {
+			if err := recordStatusChange(tx, task, &previous.Status); err != nil {
+				return err
+			}
+		}
+		return setCustomFieldValues(tx, task.ID, values, cleared)
+	})
+	if err != nil {
+		task.Version = version
+	}
+	return err
+}
+
+// BulkApply applies op to all the given tasks in one transaction with
+// targeted updates, bumping each task's version.
+func (repo *Task) BulkApply(ids []uint, op repository.TaskBulkOperation) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		tasks := tx.Model(&models.Task{}).Where("id IN ?", ids)
+		bump := map[string]interface{}{"version": gorm.Expr("version + 1")}
+
+		switch op.Op {
+		case repository.TaskBulkSetStatus:
+			if err := tx.Exec(`INSERT INTO task_activities (created_at, updated_at, task_id, workspace_id, from_status, to_status)
+				SELECT now(), now(), id, workspace_id, status, ? FROM tasks
+				WHERE id IN ? AND status <> ? AND deleted_at IS NULL`, op.Status, ids, op.Status).Error; err != nil {
+				return err
+			}
+			bump["status"] = op.Status
+			return tasks.Updates(bump).Error
+		case repository.TaskBulkSetPriority:
+			bump["priority"] = op.Priority
+			return tasks.Updates(bump).Error
+		case repository.TaskBulkAssign:
+			bump["assignee_id"] = op.Assignee_id
+			return tasks.Updates(bump).Error
+		case repository.TaskBulkSetSprint:
+			bump["sprint_id"] = op.Sprint_id
+			return tasks.Updates(bump).Error
+		case repository.TaskBulkAddLabel:
+			if err := tx.Exec("INSERT INTO task_labels (task_id, label_id) SELECT id, ? FROM tasks WHERE id IN ? ON CONFLICT DO NOTHING",
+				op.Label_id, ids).Error; err != nil {
+				return err
+			}
+			return tasks.Updates(bump).Error
+		case repository.TaskBulkRemoveLabel:
+			if err := tx.Exec("DELETE FROM task_labels WHERE label_id = ? AND task_id IN ?", op.Label_id, ids).Error; err != nil {
+				return err
+			}
+			return tasks.Updates(bump).Error
+		case repository.TaskBulkMove:
+			// Labels and sprints belong to the old workspace and assignees
+			// may not be members of the new one, so they are dropped where
+			// needed. Custom field values are replaced by the remapped ones.
+			if err := tx.Exec("DELETE FROM task_labels WHERE task_id IN ?", ids).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("task_id IN ?", ids).Delete(&models.CustomFieldValue{}).Error; err != nil {
+				return err
+			}
+			for _, id := range ids {
+				if err := setCustomFieldValues(tx, id, op.Custom_field_values[id], nil); err != nil {
+					return err
+				}
+			}
+			members := tx.Model(&models.UserWorkspaceRole{}).Select("user_id").Where("workspace_id = ?", op.Workspace_id)
+			if err := tx.Model(&models.Task{}).Where("id IN ? AND assignee_id NOT IN (?)", ids, members).
+				Update("assignee_id", 0).Error; err != nil {
+				return err
+			}
+			if err := tx.Model(&models.TaskActivity{}).Where("task_id IN ?", ids).
+				Update("workspace_id", op.Workspace_id).Error; err != nil {
+				return err
+			}
+			bump["workspace_id"] = op.Workspace_id
+			bump["sprint_id"] = 0
+			return tx.Model(&models.Task{}).Where("id IN ?", ids).Updates(bump).Error
+		case repository.TaskBulkDelete:
+			now := tx.NowFunc()
+			if err := tx.Model(&models.SubTask{}).Where("task_id IN ?", ids).Update("deleted_at", now).Error; err != nil {
+				return err
+			}
+			return tasks.Update("deleted_at", now).Error
+		}
+		return fmt.Errorf("unknown bulk operation %q", op.Op)
+	})
+}
+
+func (repo *Task) FindDeletedByWorkspaceID(id uint) ([]*models.Task, error) {
+	var tasks []*models.Task
+	result := repo.db.Unscoped().Where("deleted_at IS NOT NULL").Find(&tasks, "workspace_id = ?", id)
+	return tasks, result.Error
+}
+
+// Delete soft-deletes the task together with its subtasks, stamping them all
+// with the same deleted_at so Restore can bring back exactly that set.
+func (repo *Task) Delete(id uint, version uint) error {
+	now := repo.db.NowFunc()
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Task{}).Where("id = ? AND version = ?", id, version).Update("deleted_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return repository.ErrStaleVersion
+		}
+		return tx.Model(&models.SubTask{}).Where("task_id = ?", id).Update("deleted_at", now).Error
+	})
+}
+
+func (repo *Task) Restore(id uint) error {
+	var task models.Task
+	if err := repo.db.Unscoped().Where("deleted_at IS NOT NULL").First(&task, "id = ?", id).Error; err != nil {
+		return err
+	}
+
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&models.SubTask{}).
+			Where("task_id = ? AND deleted_at = ?", id, task.DeletedAt).
+			Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Model(&models.Task{}).Where("id = ?", id).Update("deleted_at", nil).Error
+	})
 }
 
-func (repo *Task) Delete(id uint) error {
-	result := repo.db.Where("id = ?", id).Delete(&models.Task{})
-	return result.Error
+// Purge permanently removes tasks and subtasks that were soft-deleted before
+// the given time.
+func (repo *Task) Purge(before time.Time) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		purged := tx.Unscoped().Model(&models.Task{}).Select("id").Where("deleted_at < ?", before)
+		if err := tx.Unscoped().Where("task_id IN (?)", purged).Delete(&models.TaskActivity{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("task_id IN (?) OR linked_task_id IN (?)", purged, purged).Delete(&models.TaskLink{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM task_labels WHERE task_id IN (?)", purged).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("task_id IN (?)", purged).Delete(&models.CustomFieldValue{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("deleted_at < ?", before).Delete(&models.SubTask{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("deleted_at < ?", before).Delete(&models.Task{}).Error
+	})
 }
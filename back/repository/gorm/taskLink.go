@@ -0,0 +1,43 @@
+package gorm
+
+import (
+	"github.com/raeinsoltani/gorello/back/models"
+	"gorm.io/gorm"
+)
+
+type TaskLink struct {
+	db *gorm.DB
+}
+
+func NewTaskLinkRepo(db *gorm.DB) *TaskLink {
+	return &TaskLink{db: db}
+}
+
+func (repo *TaskLink) Create(link *models.TaskLink) error {
+	result := repo.db.Create(link)
+	return result.Error
+}
+
+func (repo *TaskLink) FindByID(id uint) (*models.TaskLink, error) {
+	var link models.TaskLink
+	result := repo.db.First(&link, "id = ?", id)
+	return &link, result.Error
+}
+
+// FindByTaskID returns the links on either side of the task.
+func (repo *TaskLink) FindByTaskID(task_id uint) ([]*models.TaskLink, error) {
+	var links []*models.TaskLink
+	result := repo.db.Find(&links, "task_id = ? OR linked_task_id = ?", task_id, task_id)
+	return links, result.Error
+}
+
+func (repo *TaskLink) FindByWorkspaceID(workspace_id uint) ([]*models.TaskLink, error) {
+	var links []*models.TaskLink
+	result := repo.db.Find(&links, "workspace_id = ?", workspace_id)
+	return links, result.Error
+}
+
+func (repo *TaskLink) Delete(id uint) error {
+	result := repo.db.Unscoped().Where("id = ?", id).Delete(&models.TaskLink{})
+	return result.Error
+}
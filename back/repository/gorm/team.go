@@ -0,0 +1,91 @@
+package gorm
+
+import (
+	"github.com/raeinsoltani/gorello/back/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Team struct {
+	db *gorm.DB
+}
+
+func NewTeamRepo(db *gorm.DB) *Team {
+	return &Team{db: db}
+}
+
+func (repo *Team) Create(team *models.Team) error {
+	result := repo.db.Create(team)
+	return result.Error
+}
+
+func (repo *Team) FindByID(id uint) (*models.Team, error) {
+	var team models.Team
+	result := repo.db.First(&team, "id = ?", id)
+	return &team, result.Error
+}
+
+func (repo *Team) FindByOrganizationID(organization_id uint) ([]*models.Team, error) {
+	var teams []*models.Team
+	result := repo.db.Order("id").Find(&teams, "organization_id = ?", organization_id)
+	return teams, result.Error
+}
+
+func (repo *Team) Update(team *models.Team) error {
+	result := repo.db.Model(team).Select("name", "description").Updates(team)
+	return result.Error
+}
+
+func (repo *Team) Delete(id uint) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("team_id = ?", id).Delete(&models.TeamWorkspaceRole{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("team_id = ?", id).Delete(&models.TeamMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Team{}, id).Error
+	})
+}
+
+func (repo *Team) FindMembers(team_id uint) ([]*models.TeamMember, error) {
+	var members []*models.TeamMember
+	result := repo.db.Order("user_id").Find(&members, "team_id = ?", team_id)
+	return members, result.Error
+}
+
+func (repo *Team) AddMember(team_id uint, user_id uint) error {
+	result := repo.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.TeamMember{Team_id: team_id, User_id: user_id})
+	return result.Error
+}
+
+func (repo *Team) RemoveMember(team_id uint, user_id uint) error {
+	result := repo.db.Where("team_id = ? AND user_id = ?", team_id, user_id).Delete(&models.TeamMember{})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
+
+func (repo *Team) FindWorkspaceRoles(team_id uint) ([]*models.TeamWorkspaceRole, error) {
+	var roles []*models.TeamWorkspaceRole
+	result := repo.db.Order("workspace_id").Find(&roles, "team_id = ?", team_id)
+	return roles, result.Error
+}
+
+func (repo *Team) SetWorkspaceRole(role *models.TeamWorkspaceRole) error {
+	result := repo.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "team_id"}, {Name: "workspace_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"role"}),
+	}).Create(role)
+	return result.Error
+}
+
+func (repo *Team) RemoveWorkspaceRole(team_id uint, workspace_id uint) error {
+	result := repo.db.Where("team_id = ? AND workspace_id = ?", team_id, workspace_id).Delete(&models.TeamWorkspaceRole{})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
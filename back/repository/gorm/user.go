@@ -2,6 +2,7 @@ package gorm
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/raeinsoltani/gorello/back/models"
 	"github.com/raeinsoltani/gorello/back/repository"
@@ -36,6 +37,24 @@ func (repo *User) FindByUsername(username string) (*models.User, error) {
 	return &user, result.Error
 }
 
+func (repo *User) FindByEmail(email string) (*models.User, error) {
+	var user models.User
+	result := repo.db.First(&user, "lower(email) = lower(?)", email)
+	if errors.Is(gorm.ErrRecordNotFound, result.Error) {
+		return nil, nil
+	}
+	return &user, result.Error
+}
+
+func (repo *User) FindByOidcSubject(subject string) (*models.User, error) {
+	var user models.User
+	result := repo.db.First(&user, "oidc_subject = ?", subject)
+	if errors.Is(gorm.ErrRecordNotFound, result.Error) {
+		return nil, nil
+	}
+	return &user, result.Error
+}
+
 func (repo *User) FindByKeyWord(keyword string) ([]*repository.UserSearchResultDTO, error) {
 	var users []*repository.UserSearchResultDTO
 	result := repo.db.Model(&models.User{}).Select("id, username, email").
@@ -50,8 +69,18 @@ func (repo *User) FindByKeyWord(keyword string) ([]*repository.UserSearchResultD
 }
 
 func (repo *User) Update(user *models.User) error {
-	result := repo.db.Save(user)
-	return result.Error
+	version := user.Version
+	user.Version++
+	result := repo.db.Model(user).Where("version = ?", version).Select("*").Updates(user)
+	if result.Error != nil {
+		user.Version = version
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		user.Version = version
+		return repository.ErrStaleVersion
+	}
+	return nil
 }
 
 func (repo *User) Delete(username string) error {
@@ -59,6 +88,153 @@ func (repo *User) Delete(username string) error {
 	return result.Error
 }
 
+// DeleteAccount carries out the plan, removes the user's memberships and
+// credentials and anonymizes the user row before soft-deleting it, so that
+// anything still pointing at the user shows no personal data. Tasks and
+// subtasks record no author, so besides assignments the only content tied
+// to the user is their saved templates, which are deleted, and
+// invitations: those sent to their email are deleted, and those they sent
+// keep pointing at the anonymized row.
+func (repo *User) DeleteAccount(user *models.User, plan repository.AccountDeletionPlan) error {
+	now := repo.db.NowFunc()
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		for workspace_id, owner_id := range plan.Transfer_workspaces {
+			result := tx.Model(&models.UserWorkspaceRole{}).
+				Where("workspace_id = ? AND user_id = ?", workspace_id, owner_id).
+				Update("role", 1)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				if err := promote(tx, workspace_id, owner_id); err != nil {
+					return err
+				}
+			}
+		}
+		for _, workspace_id := range plan.Delete_workspaces {
+			if err := deleteWorkspace(tx, workspace_id, now); err != nil {
+				return err
+			}
+		}
+
+		if plan.Reassign_tasks_to != 0 {
+			workspaceIDs := tx.Model(&models.UserWorkspaceRole{}).Select("workspace_id").
+				Where("user_id = ?", plan.Reassign_tasks_to)
+			if err := tx.Model(&models.Task{}).
+				Where("assignee_id = ? AND workspace_id IN (?)", user.ID, workspaceIDs).
+				Updates(map[string]interface{}{
+					"assignee_id": plan.Reassign_tasks_to,
+					"version":     gorm.Expr("version + 1"),
+				}).Error; err != nil {
+				return err
+			}
+			taskIDs := tx.Model(&models.Task{}).Select("id").Where("workspace_id IN (?)", workspaceIDs)
+			if err := tx.Model(&models.SubTask{}).
+				Where("assignee_id = ? AND task_id IN (?)", user.ID, taskIDs).
+				Update("assignee_id", plan.Reassign_tasks_to).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&models.Task{}).Where("assignee_id = ?", user.ID).
+			Updates(map[string]interface{}{
+				"assignee_id": 0,
+				"version":     gorm.Expr("version + 1"),
+			}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.SubTask{}).Where("assignee_id = ?", user.ID).
+			Update("assignee_id", 0).Error; err != nil {
+			return err
+		}
+
+		// A co-owner may have left since the plan was made.
+		var owned []uint
+		if err := tx.Model(&models.UserWorkspaceRole{}).Where("user_id = ? AND role = 1", user.ID).
+			Pluck("workspace_id", &owned).Error; err != nil {
+			return err
+		}
+		for _, workspace_id := range owned {
+			owners, err := lockOwners(tx, workspace_id)
+			if err != nil {
+				return err
+			}
+			if soleOwner(owners, user.ID) {
+				return repository.ErrLastOwner
+			}
+		}
+
+		// Memberships deleted along with a workspace in the trash stay, so
+		// that restoring it brings its owners back.
+		if err := tx.Unscoped().Where("user_id = ? AND deleted_at IS NULL", user.ID).Delete(&models.UserWorkspaceRole{}).Error; err != nil {
+			return err
+		}
+
+		var organizationIDs []uint
+		if err := tx.Model(&models.OrganizationMember{}).Where("user_id = ?", user.ID).
+			Pluck("organization_id", &organizationIDs).Error; err != nil {
+			return err
+		}
+		for _, organization_id := range organizationIDs {
+			_, members, err := lockMembers(tx, organization_id)
+			if err != nil {
+				return err
+			}
+			if soleAdmin(members, user.ID) {
+				return repository.ErrLastAdmin
+			}
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.TeamMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.OrganizationMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).
+			Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.PersonalAccessToken{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).
+			Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.Avatar{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("user_id = ? AND NOT built_in", user.ID).Delete(&models.WorkspaceTemplate{}).Error; err != nil {
+			return err
+		}
+		// Join links have no email.
+		if err := tx.Unscoped().Where("email <> '' AND lower(email) = lower(?)", user.Email).Delete(&models.Invitation{}).Error; err != nil {
+			return err
+		}
+
+		result := tx.Model(&models.User{}).Where("id = ? AND version = ?", user.ID, user.Version).Updates(map[string]interface{}{
+			"username":     fmt.Sprintf("deleted-user-%d", user.ID),
+			"email":        fmt.Sprintf("deleted-user-%d@users.invalid", user.ID),
+			"password":     "",
+			"oidc_subject": nil,
+			"totp_secret":  "",
+			"totp_enabled": false,
+			"display_name": "Deleted user",
+			"avatar_url":   "",
+			"bio":          "",
+			"preferences":  "{}",
+			"version":      gorm.Expr("version + 1"),
+			"deleted_at":   now,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return repository.ErrStaleVersion
+		}
+		return nil
+	})
+}
+
 func (repo *User) FindAll() ([]*models.User, error) {
 	var users []*models.User
 	result := repo.db.Find(&users)
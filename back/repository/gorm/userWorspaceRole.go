@@ -2,7 +2,9 @@ package gorm
 
 import (
 	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UserWorkspaceRole struct {
@@ -35,3 +37,156 @@ func (repo *UserWorkspaceRole) FindByWorkspaceID(workspace_id uint) ([]*models.U
 	result := repo.db.Find(&userWorkspaceRoles, "workspace_id = ?", workspace_id)
 	return userWorkspaceRoles, result.Error
 }
+
+// lockOwners locks the workspace row so that concurrent membership changes
+// are serialized, and returns the ids of its owners.
+func lockOwners(tx *gorm.DB, workspace_id uint) ([]uint, error) {
+	var workspace models.Workspace
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&workspace, "id = ?", workspace_id).Error; err != nil {
+		return nil, err
+	}
+
+	var owners []uint
+	err := tx.Model(&models.UserWorkspaceRole{}).
+		Where("workspace_id = ? AND role = 1", workspace_id).
+		Pluck("user_id", &owners).Error
+	return owners, err
+}
+
+// promote gives a user who can access the workspace only through a team
+// or the organization a membership of their own as an owner, since only
+// those count towards its owners.
+func promote(tx *gorm.DB, workspace_id uint, user_id uint) error {
+	return tx.Create(&models.UserWorkspaceRole{Workspace_id: workspace_id, User_id: user_id, Role: 1}).Error
+}
+
+// soleOwner reports whether user_id is the only owner in owners.
+func soleOwner(owners []uint, user_id uint) bool {
+	return len(owners) == 1 && owners[0] == user_id
+}
+
+func (repo *UserWorkspaceRole) UpdateRole(workspace_id uint, user_id uint, role uint) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		owners, err := lockOwners(tx, workspace_id)
+		if err != nil {
+			return err
+		}
+		if role != 1 && soleOwner(owners, user_id) {
+			return repository.ErrLastOwner
+		}
+
+		result := tx.Model(&models.UserWorkspaceRole{}).
+			Where("workspace_id = ? AND user_id = ?", workspace_id, user_id).
+			Update("role", role)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			if role != 1 {
+				return gorm.ErrRecordNotFound
+			}
+			return promote(tx, workspace_id, user_id)
+		}
+		return nil
+	})
+}
+
+func (repo *UserWorkspaceRole) Delete(workspace_id uint, user_id uint) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		owners, err := lockOwners(tx, workspace_id)
+		if err != nil {
+			return err
+		}
+		if soleOwner(owners, user_id) {
+			return repository.ErrLastOwner
+		}
+
+		result := tx.Unscoped().
+			Where("workspace_id = ? AND user_id = ? AND deleted_at IS NULL", workspace_id, user_id).
+			Delete(&models.UserWorkspaceRole{})
+		if result.Error == nil && result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return result.Error
+	})
+}
+
+func (repo *UserWorkspaceRole) TransferOwnership(workspace_id uint, from uint, to uint, keep bool) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockOwners(tx, workspace_id); err != nil {
+			return err
+		}
+
+		result := tx.Model(&models.UserWorkspaceRole{}).
+			Where("workspace_id = ? AND user_id = ?", workspace_id, to).
+			Update("role", 1)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			if err := promote(tx, workspace_id, to); err != nil {
+				return err
+			}
+		}
+
+		if keep {
+			return nil
+		}
+		return tx.Model(&models.UserWorkspaceRole{}).
+			Where("workspace_id = ? AND user_id = ?", workspace_id, from).
+			Update("role", 0).Error
+	})
+}
+
+// effectiveRoles selects the highest role of every user in every
+// workspace they can access, the same way as FindEffectiveByUserID. With
+// unscoped set it also covers workspaces in the trash, through the
+// memberships that were deleted along with them.
+func effectiveRoles(db *gorm.DB, unscoped bool) *gorm.DB {
+	workspaces := "w.deleted_at IS NULL"
+	if unscoped {
+		workspaces = "TRUE"
+	}
+	return db.Raw(`
+		SELECT user_id, workspace_id, MAX(role) AS role FROM (
+			SELECT uwr.user_id, uwr.workspace_id, uwr.role FROM user_workspace_roles uwr
+			JOIN workspaces w ON w.id = uwr.workspace_id
+			WHERE (uwr.deleted_at IS NULL OR uwr.deleted_at = w.deleted_at) AND `+workspaces+`
+			UNION ALL
+			SELECT tm.user_id, twr.workspace_id, twr.role FROM team_workspace_roles twr
+			JOIN team_members tm ON tm.team_id = twr.team_id
+			JOIN teams t ON t.id = twr.team_id AND t.deleted_at IS NULL
+			JOIN workspaces w ON w.id = twr.workspace_id AND w.organization_id = t.organization_id
+			WHERE `+workspaces+`
+			UNION ALL
+			SELECT om.user_id, w.id, 1 FROM workspaces w
+			JOIN organization_members om ON om.organization_id = w.organization_id
+			WHERE om.role = 1 AND `+workspaces+`
+			UNION ALL
+			SELECT om.user_id, w.id, 0 FROM workspaces w
+			JOIN organization_members om ON om.organization_id = w.organization_id
+			WHERE w.visibility = ? AND `+workspaces+`
+		) roles GROUP BY user_id, workspace_id`,
+		models.WorkspaceVisibilityOrganization)
+}
+
+func (repo *UserWorkspaceRole) FindEffectiveByUserID(user_id uint) ([]*models.UserWorkspaceRole, error) {
+	var userWorkspaceRoles []*models.UserWorkspaceRole
+	result := repo.db.Raw("SELECT user_id, workspace_id, role FROM (?) roles WHERE user_id = ? ORDER BY workspace_id",
+		effectiveRoles(repo.db, false), user_id).Scan(&userWorkspaceRoles)
+	return userWorkspaceRoles, result.Error
+}
+
+func (repo *UserWorkspaceRole) FindEffectiveByWorkspaceID(workspace_id uint) ([]*models.UserWorkspaceRole, error) {
+	var userWorkspaceRoles []*models.UserWorkspaceRole
+	result := repo.db.Raw("SELECT user_id, workspace_id, role FROM (?) roles WHERE workspace_id = ? ORDER BY user_id",
+		effectiveRoles(repo.db, false), workspace_id).Scan(&userWorkspaceRoles)
+	return userWorkspaceRoles, result.Error
+}
+
+func (repo *UserWorkspaceRole) FindEffectiveByWorkspaceIDUnscoped(workspace_id uint) ([]*models.UserWorkspaceRole, error) {
+	var userWorkspaceRoles []*models.UserWorkspaceRole
+	result := repo.db.Raw("SELECT user_id, workspace_id, role FROM (?) roles WHERE workspace_id = ? ORDER BY user_id",
+		effectiveRoles(repo.db, true), workspace_id).Scan(&userWorkspaceRoles)
+	return userWorkspaceRoles, result.Error
+}
@@ -0,0 +1,129 @@
+package gorm
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/raeinsoltani/gorello/back/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Webhook struct {
+	db *gorm.DB
+}
+
+func NewWebhookRepo(db *gorm.DB) *Webhook {
+	return &Webhook{db: db}
+}
+
+// subscribed reports whether the webhook's event filter matches the event.
+func subscribed(webhook *models.Webhook, event string) bool {
+	for _, pattern := range strings.Split(webhook.Events, ",") {
+		if matched, _ := path.Match(strings.TrimSpace(pattern), event); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func (repo *Webhook) Create(webhook *models.Webhook) error {
+	result := repo.db.Create(webhook)
+	return result.Error
+}
+
+func (repo *Webhook) FindByID(id uint) (*models.Webhook, error) {
+	var webhook models.Webhook
+	result := repo.db.First(&webhook, "id = ?", id)
+	return &webhook, result.Error
+}
+
+func (repo *Webhook) FindByWorkspaceID(workspace_id uint) ([]*models.Webhook, error) {
+	var webhooks []*models.Webhook
+	result := repo.db.Find(&webhooks, "workspace_id = ?", workspace_id)
+	return webhooks, result.Error
+}
+
+func (repo *Webhook) Update(webhook *models.Webhook) error {
+	result := repo.db.Save(webhook)
+	return result.Error
+}
+
+func (repo *Webhook) Delete(id uint) error {
+	result := repo.db.Where("id = ?", id).Delete(&models.Webhook{})
+	return result.Error
+}
+
+func (repo *Webhook) Enqueue(workspace_id uint, event string, payload []byte) error {
+	webhooks, err := repo.FindByWorkspaceID(workspace_id)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	deliveries := make([]*models.WebhookDelivery, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		if !webhook.Active || !subscribed(webhook, event) {
+			continue
+		}
+		deliveries = append(deliveries, &models.WebhookDelivery{
+			Webhook_id:      webhook.ID,
+			Event:           event,
+			Payload:         string(payload),
+			Status:          models.WebhookDeliveryPending,
+			Next_attempt_at: now,
+		})
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	result := repo.db.Create(deliveries)
+	return result.Error
+}
+
+func (repo *Webhook) CreateDelivery(delivery *models.WebhookDelivery) error {
+	result := repo.db.Create(delivery)
+	return result.Error
+}
+
+func (repo *Webhook) FindDeliveryByID(id uint) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	result := repo.db.First(&delivery, "id = ?", id)
+	return &delivery, result.Error
+}
+
+func (repo *Webhook) FindDeliveriesByWebhookID(webhook_id uint, limit int) ([]*models.WebhookDelivery, error) {
+	var deliveries []*models.WebhookDelivery
+	result := repo.db.Order("id DESC").Limit(limit).Find(&deliveries, "webhook_id = ?", webhook_id)
+	return deliveries, result.Error
+}
+
+func (repo *Webhook) ClaimDueDeliveries(limit int, lease time.Duration) ([]*models.WebhookDelivery, error) {
+	var deliveries []*models.WebhookDelivery
+	err := repo.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", models.WebhookDeliveryPending, time.Now()).
+			Order("next_attempt_at").Limit(limit).
+			Find(&deliveries).Error; err != nil {
+			return err
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, len(deliveries))
+		for i, delivery := range deliveries {
+			ids[i] = delivery.ID
+		}
+		return tx.Model(&models.WebhookDelivery{}).Where("id IN ?", ids).
+			Update("next_attempt_at", time.Now().Add(lease)).Error
+	})
+	return deliveries, err
+}
+
+func (repo *Webhook) UpdateDelivery(delivery *models.WebhookDelivery) error {
+	result := repo.db.Save(delivery)
+	return result.Error
+}
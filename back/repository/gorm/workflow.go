@@ -0,0 +1,114 @@
+package gorm
+
+import (
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+	"gorm.io/gorm"
+)
+
+type Workflow struct {
+	db *gorm.DB
+}
+
+func NewWorkflowRepo(db *gorm.DB) *Workflow {
+	return &Workflow{db: db}
+}
+
+// statusesIn selects the values of the workspace's statuses in the
+// category, or those of the default workflow if it has none of its own.
+func statusesIn(db *gorm.DB, workspace_id uint, category string) *gorm.DB {
+	var fallback uint
+	for _, status := range models.DefaultWorkflowStatuses() {
+		if status.Category == category {
+			fallback = status.Value
+		}
+	}
+	return db.Raw(`SELECT value FROM workflow_statuses
+		WHERE workspace_id = ? AND category = ? AND deleted_at IS NULL
+		UNION ALL
+		SELECT CAST(? AS bigint) WHERE NOT EXISTS (
+			SELECT 1 FROM workflow_statuses WHERE workspace_id = ? AND deleted_at IS NULL
+		)`, workspace_id, category, fallback, workspace_id)
+}
+
+func (repo *Workflow) FindStatuses(workspace_id uint) ([]*models.WorkflowStatus, error) {
+	var statuses []*models.WorkflowStatus
+	result := repo.db.Order("position").Find(&statuses, "workspace_id = ?", workspace_id)
+	return statuses, result.Error
+}
+
+func (repo *Workflow) FindPriorities(workspace_id uint) ([]*models.WorkflowPriority, error) {
+	var priorities []*models.WorkflowPriority
+	result := repo.db.Order("position").Find(&priorities, "workspace_id = ?", workspace_id)
+	return priorities, result.Error
+}
+
+func (repo *Workflow) Replace(workspace_id uint, statuses []*models.WorkflowStatus, priorities []*models.WorkflowPriority) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		// Tasks in the trash count too, as they keep their status and
+		// priority when restored.
+		tasks := tx.Unscoped().Model(&models.Task{}).Where("workspace_id = ?", workspace_id)
+
+		if len(statuses) > 0 {
+			values := make([]uint, 0, len(statuses))
+			for _, status := range statuses {
+				values = append(values, status.Value)
+			}
+			var count int64
+			if err := tasks.Session(&gorm.Session{}).Where("status NOT IN ?", values).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return repository.ErrWorkflowInUse
+			}
+		}
+		if len(priorities) > 0 {
+			values := make([]uint, 0, len(priorities))
+			for _, priority := range priorities {
+				values = append(values, priority.Value)
+			}
+			var count int64
+			if err := tasks.Session(&gorm.Session{}).Where("priority NOT IN ?", values).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return repository.ErrWorkflowInUse
+			}
+		}
+
+		if err := tx.Unscoped().Where("workspace_id = ?", workspace_id).Delete(&models.WorkflowStatus{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("workspace_id = ?", workspace_id).Delete(&models.WorkflowPriority{}).Error; err != nil {
+			return err
+		}
+
+		return createWorkflow(tx, workspace_id, statuses, priorities)
+	})
+}
+
+// createWorkflow saves the statuses and priorities for the workspace in the
+// order given.
+func createWorkflow(tx *gorm.DB, workspace_id uint, statuses []*models.WorkflowStatus, priorities []*models.WorkflowPriority) error {
+	for i, status := range statuses {
+		status.ID = 0
+		status.Workspace_id = workspace_id
+		status.Position = uint(i)
+	}
+	for i, priority := range priorities {
+		priority.ID = 0
+		priority.Workspace_id = workspace_id
+		priority.Position = uint(i)
+	}
+	if len(statuses) > 0 {
+		if err := tx.Create(statuses).Error; err != nil {
+			return err
+		}
+	}
+	if len(priorities) > 0 {
+		if err := tx.Create(priorities).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,8 +2,10 @@ package gorm
 
 import (
 	"errors"
+	"time"
 
 	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
 	"gorm.io/gorm"
 )
 
@@ -20,6 +22,85 @@ func (repo *Workspace) Create(workspace *models.Workspace) error {
 	return result.Error
 }
 
+// Import creates a workspace together with its memberships, labels,
+// workflow, sprints, custom fields and tasks with their subtasks and
+// custom field values in a single transaction. Task labels are matched to
+// the given labels by name.
+func (repo *Workspace) Import(workspace *models.Workspace, content repository.WorkspaceContent) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(workspace).Error; err != nil {
+			return err
+		}
+
+		if err := createWorkflow(tx, workspace.ID, content.Statuses, content.Priorities); err != nil {
+			return err
+		}
+
+		for _, role := range content.Roles {
+			role.Workspace_id = workspace.ID
+			if err := tx.Create(role).Error; err != nil {
+				return err
+			}
+		}
+
+		labelsByName := make(map[string]models.Label)
+		for _, label := range content.Labels {
+			label.Workspace_id = workspace.ID
+			if err := tx.Create(label).Error; err != nil {
+				return err
+			}
+			labelsByName[label.Name] = *label
+		}
+
+		for _, sprint := range content.Sprints {
+			sprint.ID = 0
+			sprint.Workspace_id = workspace.ID
+			if err := tx.Create(sprint).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, field := range content.Custom_fields {
+			field.ID = 0
+			field.Workspace_id = workspace.ID
+			if err := tx.Create(field).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, importTask := range content.Tasks {
+			task := importTask.Task
+			task.Workspace_id = workspace.ID
+			task.Sprint_id = 0
+			if importTask.Sprint != nil {
+				task.Sprint_id = importTask.Sprint.ID
+			}
+			taskLabels := make([]models.Label, 0, len(task.Labels))
+			for _, label := range task.Labels {
+				if created, ok := labelsByName[label.Name]; ok {
+					taskLabels = append(taskLabels, created)
+				}
+			}
+			task.Labels = taskLabels
+			if err := tx.Create(task).Error; err != nil {
+				return err
+			}
+			if err := recordStatusChange(tx, task, nil); err != nil {
+				return err
+			}
+
+			values := make([]*models.CustomFieldValue, 0, len(importTask.Custom_field_values))
+			for _, value := range importTask.Custom_field_values {
+				values = append(values, &models.CustomFieldValue{Custom_field_id: value.Field.ID, Value: value.Value})
+			}
+			if err := setCustomFieldValues(tx, task.ID, values, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func (repo *Workspace) FindByID(id uint) (*models.Workspace, error) {
 	var workspace models.Workspace
 	result := repo.db.First(&workspace, "id = ?", id)
@@ -35,12 +116,138 @@ func (repo *Workspace) FindByName(name string) (*models.Workspace, error) {
 	return &workspace, result.Error
 }
 
+func (repo *Workspace) FindByPublicSlug(slug string) (*models.Workspace, error) {
+	var workspace models.Workspace
+	result := repo.db.First(&workspace, "public_slug = ? AND visibility = ?", slug, models.WorkspaceVisibilityPublicLink)
+	if errors.Is(gorm.ErrRecordNotFound, result.Error) {
+		return nil, nil
+	}
+	return &workspace, result.Error
+}
+
 func (repo *Workspace) Update(workspace *models.Workspace) error {
-	result := repo.db.Save(workspace)
-	return result.Error
+	version := workspace.Version
+	workspace.Version++
+	result := repo.db.Model(workspace).Where("version = ?", version).Select("*").Updates(workspace)
+	if result.Error != nil {
+		workspace.Version = version
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		workspace.Version = version
+		return repository.ErrStaleVersion
+	}
+	return nil
 }
 
-func (repo *Workspace) Delete(id uint) error {
-	result := repo.db.Where("id = ?", id).Delete(&models.Workspace{})
-	return result.Error
+func (repo *Workspace) FindDeletedByID(id uint) (*models.Workspace, error) {
+	var workspace models.Workspace
+	result := repo.db.Unscoped().Where("deleted_at IS NOT NULL").First(&workspace, "id = ?", id)
+	if errors.Is(gorm.ErrRecordNotFound, result.Error) {
+		return nil, nil
+	}
+	return &workspace, result.Error
+}
+
+// Delete soft-deletes the workspace and cascades to its tasks, their subtasks
+// and its memberships. Everything is stamped with the same deleted_at so that
+// Restore brings back only what this call removed.
+func (repo *Workspace) Delete(id uint, version uint) error {
+	now := repo.db.NowFunc()
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		// Stamping the workspace first checks its version; deleteWorkspace
+		// then skips the row as already deleted.
+		result := tx.Model(&models.Workspace{}).Where("id = ? AND version = ?", id, version).Update("deleted_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return repository.ErrStaleVersion
+		}
+		return deleteWorkspace(tx, id, now)
+	})
+}
+
+// deleteWorkspace soft-deletes a workspace with its tasks, subtasks and
+// memberships, all stamped with the same time so that Restore can tell
+// them apart from rows deleted earlier.
+func deleteWorkspace(tx *gorm.DB, id uint, now time.Time) error {
+	taskIDs := tx.Model(&models.Task{}).Select("id").Where("workspace_id = ?", id)
+	if err := tx.Model(&models.SubTask{}).Where("task_id IN (?)", taskIDs).Update("deleted_at", now).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.Task{}).Where("workspace_id = ?", id).Update("deleted_at", now).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.UserWorkspaceRole{}).Where("workspace_id = ?", id).Update("deleted_at", now).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.Workspace{}).Where("id = ?", id).Update("deleted_at", now).Error
+}
+
+func (repo *Workspace) Restore(id uint) error {
+	workspace, err := repo.FindDeletedByID(id)
+	if err != nil {
+		return err
+	}
+	if workspace == nil {
+		return gorm.ErrRecordNotFound
+	}
+
+	deletedAt := workspace.DeletedAt
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		taskIDs := tx.Unscoped().Model(&models.Task{}).Select("id").Where("workspace_id = ?", id)
+		if err := tx.Unscoped().Model(&models.SubTask{}).
+			Where("task_id IN (?) AND deleted_at = ?", taskIDs, deletedAt).
+			Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(&models.Task{}).
+			Where("workspace_id = ? AND deleted_at = ?", id, deletedAt).
+			Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Model(&models.UserWorkspaceRole{}).
+			Where("workspace_id = ? AND deleted_at = ?", id, deletedAt).
+			Update("deleted_at", nil).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Model(&models.Workspace{}).Where("id = ?", id).Update("deleted_at", nil).Error
+	})
+}
+
+// Purge permanently removes workspaces that were soft-deleted before the
+// given time, with their memberships, labels, custom fields, workflow,
+// sprints, invitations, team roles and webhooks along with the webhooks'
+// deliveries. Tasks are purged by the task repository.
+func (repo *Workspace) Purge(before time.Time) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("deleted_at < ?", before).Delete(&models.UserWorkspaceRole{}).Error; err != nil {
+			return err
+		}
+		purged := tx.Unscoped().Model(&models.Workspace{}).Select("id").Where("deleted_at < ?", before)
+		webhooks := tx.Unscoped().Model(&models.Webhook{}).Select("id").Where("workspace_id IN (?)", purged)
+		if err := tx.Unscoped().Where("webhook_id IN (?)", webhooks).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		for _, model := range []interface{}{
+			&models.Webhook{}, &models.Sprint{}, &models.Invitation{}, &models.WorkflowStatus{},
+			&models.WorkflowPriority{}, &models.TeamWorkspaceRole{},
+		} {
+			if err := tx.Unscoped().Where("workspace_id IN (?)", purged).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		labels := tx.Unscoped().Model(&models.Label{}).Select("id").Where("workspace_id IN (?)", purged)
+		if err := tx.Exec("DELETE FROM task_labels WHERE label_id IN (?)", labels).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("workspace_id IN (?)", purged).Delete(&models.Label{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("workspace_id IN (?)", purged).Delete(&models.CustomField{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Where("deleted_at < ?", before).Delete(&models.Workspace{}).Error
+	})
 }
@@ -0,0 +1,62 @@
+package gorm
+
+import (
+	"github.com/raeinsoltani/gorello/back/models"
+	"gorm.io/gorm"
+)
+
+type WorkspaceTemplate struct {
+	db *gorm.DB
+}
+
+func NewWorkspaceTemplateRepo(db *gorm.DB) *WorkspaceTemplate {
+	return &WorkspaceTemplate{db: db}
+}
+
+func (repo *WorkspaceTemplate) Create(template *models.WorkspaceTemplate) error {
+	result := repo.db.Create(template)
+	return result.Error
+}
+
+func (repo *WorkspaceTemplate) FindByID(id uint) (*models.WorkspaceTemplate, error) {
+	var template models.WorkspaceTemplate
+	result := repo.db.First(&template, "id = ?", id)
+	return &template, result.Error
+}
+
+func (repo *WorkspaceTemplate) FindAvailable(user_id uint) ([]*models.WorkspaceTemplate, error) {
+	var templates []*models.WorkspaceTemplate
+	result := repo.db.Order("built_in DESC, id").Find(&templates, "built_in OR user_id = ?", user_id)
+	return templates, result.Error
+}
+
+func (repo *WorkspaceTemplate) Delete(id uint) error {
+	result := repo.db.Delete(&models.WorkspaceTemplate{}, id)
+	return result.Error
+}
+
+func (repo *WorkspaceTemplate) SaveBuiltIn(templates []*models.WorkspaceTemplate) error {
+	return repo.db.Transaction(func(tx *gorm.DB) error {
+		for _, template := range templates {
+			var existing models.WorkspaceTemplate
+			result := tx.Limit(1).Find(&existing, "built_in AND name = ?", template.Name)
+			if result.Error != nil {
+				return result.Error
+			}
+
+			template.Built_in = true
+			if result.RowsAffected == 0 {
+				if err := tx.Create(template).Error; err != nil {
+					return err
+				}
+				continue
+			}
+
+			template.ID = existing.ID
+			if err := tx.Model(&existing).Select("description", "content").Updates(template).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
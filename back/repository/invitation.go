@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/raeinsoltani/gorello/back/models"
+)
+
+var (
+	// ErrInvitationUnusable is returned by Accept for an invitation that was
+	// revoked, has expired or has no uses left.
+	ErrInvitationUnusable = errors.New("invitation is no longer valid")
+	// ErrAlreadyMember is returned by Accept when the user is already in the
+	// workspace.
+	ErrAlreadyMember = errors.New("user is already a member of the workspace")
+)
+
+type Invitation interface {
+	Create(invitation *models.Invitation) error
+	FindByID(id uint) (*models.Invitation, error)
+	FindByHash(hash string) (*models.Invitation, error)
+	// FindPendingByWorkspaceID returns the invitations that can still be
+	// accepted.
+	FindPendingByWorkspaceID(workspace_id uint) ([]*models.Invitation, error)
+	Revoke(id uint) error
+	// Accept uses up the invitation and adds the user to its workspace with
+	// the invitation's role, returning the new membership.
+	Accept(id uint, user_id uint) (*models.UserWorkspaceRole, error)
+	// AcceptNewUser creates the user and accepts the invitation for them in
+	// one transaction.
+	AcceptNewUser(id uint, user *models.User) (*models.UserWorkspaceRole, error)
+}
@@ -0,0 +1,12 @@
+package repository
+
+import (
+	"github.com/raeinsoltani/gorello/back/models"
+)
+
+type Label interface {
+	Create(label *models.Label) error
+	FindByID(id uint) (*models.Label, error)
+	FindByWorkspaceID(workspace_id uint) ([]*models.Label, error)
+	Delete(id uint) error
+}
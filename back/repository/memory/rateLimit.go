@@ -0,0 +1,127 @@
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"github.com/raeinsoltani/gorello/back/repository"
+)
+
+// sweepInterval is how often idle buckets and stale failures are dropped.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	// full is when the bucket will have refilled, after which it can be
+	// dropped.
+	full time.Time
+}
+
+type failure struct {
+	failures    uint
+	lockedUntil time.Time
+	updatedAt   time.Time
+	forgetAt    time.Time
+}
+
+// RateLimit keeps rate limit buckets and lockouts in process memory. It
+// suits a single instance of the API; the limits reset on restart.
+type RateLimit struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	failures  map[string]*failure
+	lastSweep time.Time
+}
+
+func NewRateLimitRepo() *RateLimit {
+	return &RateLimit{
+		buckets:   make(map[string]*bucket),
+		failures:  make(map[string]*failure),
+		lastSweep: time.Now(),
+	}
+}
+
+func (repo *RateLimit) Take(key string, policy repository.RateLimitPolicy) (time.Duration, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	now := time.Now()
+	repo.sweep(now)
+
+	b, ok := repo.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(policy.Events), updatedAt: now}
+		repo.buckets[key] = b
+	}
+
+	var wait time.Duration
+	b.tokens, wait = policy.Take(b.tokens, now.Sub(b.updatedAt))
+	b.updatedAt = now
+	b.full = now.Add(time.Duration((float64(policy.Events) - b.tokens) / float64(policy.Events) * float64(policy.Per)))
+	return wait, nil
+}
+
+func (repo *RateLimit) LockedFor(key string) (time.Duration, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	f, ok := repo.failures[key]
+	if !ok {
+		return 0, nil
+	}
+	return time.Until(f.lockedUntil), nil
+}
+
+func (repo *RateLimit) RecordFailure(key string, policy repository.LockoutPolicy) (time.Duration, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	now := time.Now()
+	repo.sweep(now)
+
+	f, ok := repo.failures[key]
+	if !ok || now.Sub(f.updatedAt) > policy.Max {
+		f = &failure{}
+		repo.failures[key] = f
+	}
+	f.failures++
+	f.updatedAt = now
+	f.forgetAt = now.Add(policy.Max)
+	lockout := policy.Duration(f.failures)
+	if lockout > 0 {
+		f.lockedUntil = now.Add(lockout)
+		if f.lockedUntil.After(f.forgetAt) {
+			f.forgetAt = f.lockedUntil
+		}
+	}
+	return lockout, nil
+}
+
+func (repo *RateLimit) ResetFailures(key string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	delete(repo.failures, key)
+	return nil
+}
+
+// sweep drops full buckets and forgotten failures so that the maps don't
+// grow with every address that ever made a request. The caller holds mu.
+func (repo *RateLimit) sweep(now time.Time) {
+	if now.Sub(repo.lastSweep) < sweepInterval {
+		return
+	}
+	repo.lastSweep = now
+
+	for key, b := range repo.buckets {
+		if now.After(b.full) {
+			delete(repo.buckets, key)
+		}
+	}
+	for key, f := range repo.failures {
+		if now.After(f.forgetAt) {
+			delete(repo.failures, key)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"github.com/raeinsoltani/gorello/back/models"
+)
+
+type Organization interface {
+	// Create creates the organization with its creator as the first admin.
+	Create(organization *models.Organization, creator_id uint) error
+	FindByID(id uint) (*models.Organization, error)
+	FindByUserID(user_id uint) ([]*models.Organization, error)
+	// Update returns ErrSeatLimit if the seat limit is below the current
+	// number of members.
+	Update(organization *models.Organization) error
+	// Delete removes the organization with its members and teams. Its
+	// workspaces stay with their members.
+	Delete(id uint) error
+	FindMembers(organization_id uint) ([]*models.OrganizationMember, error)
+	// FindMember returns nil if the user is not a member.
+	FindMember(organization_id uint, user_id uint) (*models.OrganizationMember, error)
+	// SetMember adds the user or changes their role. It returns
+	// ErrSeatLimit when adding to a full organization and ErrLastAdmin
+	// instead of demoting the only admin.
+	SetMember(organization_id uint, user_id uint, role uint) error
+	// RemoveMember also removes the user from the organization's teams.
+	RemoveMember(organization_id uint, user_id uint) error
+	FindWorkspaces(organization_id uint) ([]*models.Workspace, error)
+}
@@ -0,0 +1,16 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/raeinsoltani/gorello/back/models"
+)
+
+type PersonalAccessToken interface {
+	Create(token *models.PersonalAccessToken) error
+	FindByID(id uint) (*models.PersonalAccessToken, error)
+	FindByUserID(user_id uint) ([]*models.PersonalAccessToken, error)
+	FindByHash(hash string) (*models.PersonalAccessToken, error)
+	Touch(id uint, used_at time.Time) error
+	Revoke(id uint) error
+}
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"time"
+)
+
+// RateLimitPolicy is a token bucket holding up to Events tokens and
+// refilling at Events per Per.
+type RateLimitPolicy struct {
+	Events int
+	Per    time.Duration
+}
+
+// Take refills a bucket that held tokens elapsed ago and takes one token
+// from it. It returns the tokens left and, when the bucket was empty, how
+// long until a token is available.
+func (p RateLimitPolicy) Take(tokens float64, elapsed time.Duration) (float64, time.Duration) {
+	rate := float64(p.Events) / p.Per.Seconds()
+	tokens += elapsed.Seconds() * rate
+	if tokens > float64(p.Events) {
+		tokens = float64(p.Events)
+	}
+	if tokens >= 1 {
+		return tokens - 1, 0
+	}
+	return tokens, time.Duration((1 - tokens) / rate * float64(time.Second))
+}
+
+// LockoutPolicy locks an account after Threshold consecutive failures, for
+// Base at first and twice as long with each further failure, up to Max.
+type LockoutPolicy struct {
+	Threshold uint
+	Base      time.Duration
+	Max       time.Duration
+}
+
+// Duration is how long the account is locked after the given number of
+// consecutive failures.
+func (p LockoutPolicy) Duration(failures uint) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+	lockout := p.Base
+	for i := p.Threshold; i < failures; i++ {
+		lockout *= 2
+		if lockout >= p.Max {
+			return p.Max
+		}
+	}
+	return lockout
+}
+
+type RateLimit interface {
+	// Take takes a token from the key's bucket, returning how long to
+	// wait when there is none.
+	Take(key string, policy RateLimitPolicy) (time.Duration, error)
+	// LockedFor returns how much longer the key is locked out.
+	LockedFor(key string) (time.Duration, error)
+	// RecordFailure counts a failure for the key and returns the lockout
+	// it triggers, if any.
+	RecordFailure(key string, policy LockoutPolicy) (time.Duration, error)
+	ResetFailures(key string) error
+}
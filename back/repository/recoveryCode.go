@@ -0,0 +1,8 @@
+package repository
+
+type RecoveryCode interface {
+	Replace(user_id uint, hashes []string) error
+	Use(user_id uint, hash string) (bool, error)
+	CountUnused(user_id uint) (int64, error)
+	DeleteByUserID(user_id uint) error
+}
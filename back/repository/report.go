@@ -0,0 +1,45 @@
+package repository
+
+import "time"
+
+type CountDTO struct {
+	Key   uint  `json:"key"`
+	Count int64 `json:"count"`
+}
+
+type TaskCountsDTO struct {
+	Total      int64      `json:"total"`
+	Overdue    int64      `json:"overdue"`
+	ByStatus   []CountDTO `json:"by_status"`
+	ByPriority []CountDTO `json:"by_priority"`
+	ByAssignee []CountDTO `json:"by_assignee"`
+}
+
+type ThroughputDTO struct {
+	Week      time.Time `json:"week"`
+	Completed int64     `json:"completed"`
+}
+
+// CycleTimeDTO holds averages in hours. Lead time runs from creation to
+// the first move to done, cycle time from the first move to in progress.
+type CycleTimeDTO struct {
+	Completed         int64    `json:"completed"`
+	AvgLeadTimeHours  *float64 `json:"avg_lead_time_hours"`
+	AvgCycleTimeHours *float64 `json:"avg_cycle_time_hours"`
+}
+
+// TimeTrackingDTO compares estimated and actual time in hours over the
+// tasks where both could be parsed.
+type TimeTrackingDTO struct {
+	Tasks          int64    `json:"tasks"`
+	EstimatedHours float64  `json:"estimated_hours"`
+	ActualHours    float64  `json:"actual_hours"`
+	Accuracy       *float64 `json:"accuracy"`
+}
+
+type Report interface {
+	TaskCounts(workspace_id uint) (*TaskCountsDTO, error)
+	Throughput(workspace_id uint, since time.Time) ([]*ThroughputDTO, error)
+	CycleTime(workspace_id uint, since time.Time) (*CycleTimeDTO, error)
+	TimeTracking(workspace_id uint) (*TimeTrackingDTO, error)
+}
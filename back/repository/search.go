@@ -0,0 +1,45 @@
+package repository
+
+// SearchQuery is a parsed /search request. Text is matched with full-text
+// search; the remaining fields are exact filters and nil/empty fields don't
+// filter.
+type SearchQuery struct {
+	User_id      uint
+	Text         string
+	Types        []string
+	Workspace_id *uint
+	Assignee_id  *uint
+	Status       *uint
+	Priority     *uint
+	DueBefore    string
+	DueAfter     string
+	DueOn        string
+	Limit        int
+	// Status_name matches the tasks whose status, in their workspace's
+	// workflow, has this name or category.
+	Status_name string
+}
+
+// HasTaskFilters reports whether the query filters on task fields, which
+// rules out workspace results.
+func (q SearchQuery) HasTaskFilters() bool {
+	return q.Assignee_id != nil || q.Status != nil || q.Status_name != "" || q.Priority != nil ||
+		q.DueBefore != "" || q.DueAfter != "" || q.DueOn != ""
+}
+
+type SearchResultDTO struct {
+	Type         string  `json:"type"`
+	ID           uint    `json:"id"`
+	Workspace_id uint    `json:"workspace_id"`
+	Task_id      uint    `json:"task_id,omitempty"`
+	Title        string  `json:"title"`
+	Snippet      string  `json:"snippet"`
+	Rank         float64 `json:"rank"`
+	// Archived is set for archived workspaces and tasks, and for subtasks
+	// of archived tasks.
+	Archived bool `json:"archived"`
+}
+
+type Search interface {
+	Search(query SearchQuery) ([]*SearchResultDTO, error)
+}
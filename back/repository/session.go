@@ -0,0 +1,16 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/raeinsoltani/gorello/back/models"
+)
+
+type Session interface {
+	Create(session *models.Session) error
+	FindByID(id uint) (*models.Session, error)
+	FindActiveByUserID(user_id uint) ([]*models.Session, error)
+	Touch(id uint, seen_at time.Time, ip string) error
+	Revoke(id uint) error
+	RevokeAllExcept(user_id uint, keep_id uint) error
+}
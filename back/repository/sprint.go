@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/raeinsoltani/gorello/back/models"
+)
+
+// BurndownPointDTO is the state of a sprint at the end of one day.
+type BurndownPointDTO struct {
+	Day              time.Time `json:"day"`
+	Total_points     uint      `json:"total_points"`
+	Completed_points uint      `json:"completed_points"`
+	Remaining_points uint      `json:"remaining_points"`
+	Ideal_remaining  float64   `json:"ideal_remaining"`
+	Completed_tasks  uint      `json:"completed_tasks"`
+}
+
+type Sprint interface {
+	Create(sprint *models.Sprint) error
+	FindByID(id uint) (*models.Sprint, error)
+	FindByWorkspaceID(workspace_id uint) ([]*models.Sprint, error)
+	Update(sprint *models.Sprint) error
+	Complete(sprint *models.Sprint, rollover_sprint_id uint) error
+	Burndown(sprint *models.Sprint) ([]*BurndownPointDTO, error)
+}
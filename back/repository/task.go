@@ -1,11 +1,88 @@
 package repository
 
-import "github.com/raeinsoltani/gorello/back/models"
+import (
+	"time"
+
+	"github.com/raeinsoltani/gorello/back/models"
+)
+
+// TaskFilter narrows a workspace's task listing. Nil fields don't filter.
+type TaskFilter struct {
+	Status      *uint
+	Priority    *uint
+	Assignee_id *uint
+	Sprint_id   *uint
+	// Archived keeps only archived tasks if true and only unarchived ones
+	// if false.
+	Archived *bool
+	// Custom_fields keeps tasks whose values contain the given ones.
+	Custom_fields []CustomFieldFilter
+	// Sort_field orders the tasks by their value for the custom field,
+	// with the tasks that have none last.
+	Sort_field *uint
+	Sort_desc  bool
+}
+
+// CustomFieldFilter matches a task's value for the field against Value, a
+// JSON value that must be contained in it as with the jsonb @> operator:
+// equal for most fields, one of the chosen options for multi-select ones.
+type CustomFieldFilter struct {
+	Field_id uint
+	Value    string
+}
+
+const (
+	TaskBulkSetStatus   = "set_status"
+	TaskBulkSetPriority = "set_priority"
+	TaskBulkAssign      = "assign"
+	TaskBulkAddLabel    = "add_label"
+	TaskBulkRemoveLabel = "remove_label"
+	TaskBulkMove        = "move"
+	TaskBulkDelete      = "delete"
+	TaskBulkSetSprint   = "set_sprint"
+)
+
+// TaskBulkOperation is one change applied to many tasks at once. Only the
+// field matching Op is read.
+type TaskBulkOperation struct {
+	Op           string
+	Status       uint
+	Priority     uint
+	Assignee_id  uint
+	Label_id     uint
+	Workspace_id uint
+	Sprint_id    uint
+
+	// Custom_field_values are the values each moved task has in the new
+	// workspace, by task id. Values it had for the old workspace's fields
+	// are removed.
+	Custom_field_values map[uint][]*models.CustomFieldValue
+}
 
 type Task interface {
 	Create(task *models.Task) error
+	// CreateWithCustomFields creates the task with its custom field values
+	// in one transaction.
+	CreateWithCustomFields(task *models.Task, values []*models.CustomFieldValue) error
+	CreateBatch(tasks []*models.Task) error
+	// CreateBatchWithCustomFields creates all tasks in one transaction, each
+	// with the custom field values at the same index.
+	CreateBatchWithCustomFields(tasks []*models.Task, values [][]*models.CustomFieldValue) error
 	FindByID(id uint) (*models.Task, error)
 	FindByWorkspaceID(id uint) ([]*models.Task, error)
+	FindByWorkspaceIDWithDetails(id uint) ([]*models.Task, error)
+	FindByFilter(workspace_id uint, filter TaskFilter) ([]*models.Task, error)
+	FindByAssigneeID(user_id uint) ([]*models.Task, error)
+	FindDeletedByWorkspaceID(id uint) ([]*models.Task, error)
 	Update(task *models.Task) error
-	Delete(id uint) error
+	// UpdateWithCustomFields updates the task in one transaction with its
+	// custom field values, replacing any it had for the same fields, and
+	// removing its values for the cleared fields.
+	UpdateWithCustomFields(task *models.Task, values []*models.CustomFieldValue, cleared []uint) error
+	// Delete returns ErrStaleVersion if the task is no longer at the given
+	// version.
+	Delete(id uint, version uint) error
+	BulkApply(ids []uint, op TaskBulkOperation) error
+	Restore(id uint) error
+	Purge(before time.Time) error
 }
@@ -0,0 +1,13 @@
+package repository
+
+import (
+	"github.com/raeinsoltani/gorello/back/models"
+)
+
+type TaskLink interface {
+	Create(link *models.TaskLink) error
+	FindByID(id uint) (*models.TaskLink, error)
+	FindByTaskID(task_id uint) ([]*models.TaskLink, error)
+	FindByWorkspaceID(workspace_id uint) ([]*models.TaskLink, error)
+	Delete(id uint) error
+}
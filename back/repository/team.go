@@ -0,0 +1,22 @@
+package repository
+
+import (
+	"github.com/raeinsoltani/gorello/back/models"
+)
+
+type Team interface {
+	Create(team *models.Team) error
+	FindByID(id uint) (*models.Team, error)
+	FindByOrganizationID(organization_id uint) ([]*models.Team, error)
+	Update(team *models.Team) error
+	// Delete removes the team with its members and workspace roles.
+	Delete(id uint) error
+	FindMembers(team_id uint) ([]*models.TeamMember, error)
+	AddMember(team_id uint, user_id uint) error
+	RemoveMember(team_id uint, user_id uint) error
+	FindWorkspaceRoles(team_id uint) ([]*models.TeamWorkspaceRole, error)
+	// SetWorkspaceRole grants the team the role in the workspace, replacing
+	// any role it had there.
+	SetWorkspaceRole(role *models.TeamWorkspaceRole) error
+	RemoveWorkspaceRole(team_id uint, workspace_id uint) error
+}
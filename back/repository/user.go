@@ -10,12 +10,29 @@ type UserSearchResultDTO struct {
 	Email    string `json:"email"`
 }
 
+// AccountDeletionPlan says what happens to a deleted user's data. Every
+// workspace the user solely owns must be either transferred or deleted.
+type AccountDeletionPlan struct {
+	// Transfer_workspaces maps workspace ids to the member who becomes
+	// owner.
+	Transfer_workspaces map[uint]uint
+	Delete_workspaces   []uint
+	// Reassign_tasks_to takes over the user's tasks in the workspaces it
+	// is a member of; all other tasks are unassigned. 0 unassigns them all.
+	Reassign_tasks_to uint
+}
+
 type User interface {
 	Create(user *models.User) error
 	FindByID(id uint) (*models.User, error)
 	FindByUsername(username string) (*models.User, error)
+	FindByEmail(email string) (*models.User, error)
+	FindByOidcSubject(subject string) (*models.User, error)
 	FindByKeyWord(keyword string) ([]*UserSearchResultDTO, error)
 	Update(user *models.User) error
 	Delete(username string) error
+	// DeleteAccount returns ErrStaleVersion if the user is no longer at
+	// user.Version.
+	DeleteAccount(user *models.User, plan AccountDeletionPlan) error
 	FindAll() ([]*models.User, error)
 }
@@ -8,5 +8,27 @@ type UserWorkspaceRole interface {
 	Create(userWorkspaceRole *models.UserWorkspaceRole) error
 	FindByID(id uint) (*models.UserWorkspaceRole, error)
 	FindByUserID(user_id uint) ([]*models.UserWorkspaceRole, error)
+	// FindEffectiveByUserID returns the user's role in every workspace they
+	// can access: their own memberships, roles given to their teams, owner
+	// access to the workspaces of organizations they administer, and member
+	// access to organization-visible workspaces of their organizations. The
+	// highest role wins.
+	FindEffectiveByUserID(user_id uint) ([]*models.UserWorkspaceRole, error)
 	FindByWorkspaceID(workspace_id uint) ([]*models.UserWorkspaceRole, error)
+	// FindEffectiveByWorkspaceID returns the effective role of everyone who
+	// can access the workspace.
+	FindEffectiveByWorkspaceID(workspace_id uint) ([]*models.UserWorkspaceRole, error)
+	// FindEffectiveByWorkspaceIDUnscoped is FindEffectiveByWorkspaceID for a
+	// workspace that may be in the trash, counting the memberships deleted
+	// along with it.
+	FindEffectiveByWorkspaceIDUnscoped(workspace_id uint) ([]*models.UserWorkspaceRole, error)
+	// UpdateRole and Delete return ErrLastOwner instead of demoting or
+	// removing a workspace's only owner. Only direct memberships count as
+	// owners, so making someone who has access through a team or the
+	// organization an owner gives them one.
+	UpdateRole(workspace_id uint, user_id uint, role uint) error
+	Delete(workspace_id uint, user_id uint) error
+	// TransferOwnership makes to an owner and, unless keep is set, demotes
+	// from to a regular member.
+	TransferOwnership(workspace_id uint, from uint, to uint, keep bool) error
 }
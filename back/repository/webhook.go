@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/raeinsoltani/gorello/back/models"
+)
+
+const (
+	EventTaskCreated         = "task.created"
+	EventTaskUpdated         = "task.updated"
+	EventTaskDeleted         = "task.deleted"
+	EventTaskRestored        = "task.restored"
+	EventTaskArchived        = "task.archived"
+	EventTaskUnarchived      = "task.unarchived"
+	EventMemberAdded         = "member.added"
+	EventMemberUpdated       = "member.updated"
+	EventMemberRemoved       = "member.removed"
+	EventWorkspaceCreated    = "workspace.created"
+	EventWorkspaceUpdated    = "workspace.updated"
+	EventWorkspaceDeleted    = "workspace.deleted"
+	EventWorkspaceRestored   = "workspace.restored"
+	EventWorkspaceArchived   = "workspace.archived"
+	EventWorkspaceUnarchived = "workspace.unarchived"
+	EventPing                = "ping"
+)
+
+// Events lists every event a webhook can subscribe to.
+var Events = []string{
+	EventTaskCreated, EventTaskUpdated, EventTaskDeleted, EventTaskRestored, EventTaskArchived, EventTaskUnarchived,
+	EventMemberAdded, EventMemberUpdated, EventMemberRemoved,
+	EventWorkspaceCreated, EventWorkspaceUpdated, EventWorkspaceDeleted, EventWorkspaceRestored,
+	EventWorkspaceArchived, EventWorkspaceUnarchived, EventPing,
+}
+
+type Webhook interface {
+	Create(webhook *models.Webhook) error
+	FindByID(id uint) (*models.Webhook, error)
+	FindByWorkspaceID(workspace_id uint) ([]*models.Webhook, error)
+	Update(webhook *models.Webhook) error
+	Delete(id uint) error
+	// Enqueue queues a delivery of the payload to every active webhook of
+	// the workspace subscribed to the event.
+	Enqueue(workspace_id uint, event string, payload []byte) error
+	CreateDelivery(delivery *models.WebhookDelivery) error
+	FindDeliveryByID(id uint) (*models.WebhookDelivery, error)
+	FindDeliveriesByWebhookID(webhook_id uint, limit int) ([]*models.WebhookDelivery, error)
+	// ClaimDueDeliveries returns pending deliveries whose next attempt is
+	// due and pushes that attempt back by lease, so concurrent senders
+	// don't pick them up twice.
+	ClaimDueDeliveries(limit int, lease time.Duration) ([]*models.WebhookDelivery, error)
+	UpdateDelivery(delivery *models.WebhookDelivery) error
+}
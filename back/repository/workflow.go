@@ -0,0 +1,19 @@
+package repository
+
+import (
+	"github.com/raeinsoltani/gorello/back/models"
+)
+
+type Workflow interface {
+	// FindStatuses returns the workspace's statuses in order, or none if it
+	// uses the default workflow.
+	FindStatuses(workspace_id uint) ([]*models.WorkflowStatus, error)
+	// FindPriorities returns the workspace's priorities in order, or none
+	// if it accepts any priority.
+	FindPriorities(workspace_id uint) ([]*models.WorkflowPriority, error)
+	// Replace sets the workspace's statuses and priorities; an empty list
+	// goes back to the default. It returns ErrWorkflowInUse if a task, even
+	// one in the trash, still has a status or priority that would be
+	// removed.
+	Replace(workspace_id uint, statuses []*models.WorkflowStatus, priorities []*models.WorkflowPriority) error
+}
@@ -1,13 +1,52 @@
 package repository
 
 import (
+	"time"
+
 	"github.com/raeinsoltani/gorello/back/models"
 )
 
+// WorkspaceContent is what Import creates along with a workspace. Tasks
+// refer to labels by name, and to their sprint and custom fields through
+// ImportTask.
+type WorkspaceContent struct {
+	Roles         []*models.UserWorkspaceRole
+	Labels        []*models.Label
+	Statuses      []*models.WorkflowStatus
+	Priorities    []*models.WorkflowPriority
+	Sprints       []*models.Sprint
+	Custom_fields []*models.CustomField
+	Tasks         []*ImportTask
+}
+
+// ImportTask is a task to import. Sprint, if set, is one of the content's
+// Sprints, and the fields of its values are among its Custom_fields.
+type ImportTask struct {
+	Task                *models.Task
+	Sprint              *models.Sprint
+	Custom_field_values []ImportCustomFieldValue
+}
+
+// ImportCustomFieldValue is a task's value for a custom field, as stored in
+// CustomFieldValue.Value.
+type ImportCustomFieldValue struct {
+	Field *models.CustomField
+	Value string
+}
+
 type Workspace interface {
 	Create(workspace *models.Workspace) error
+	// Import creates the workspace with its content in one transaction.
+	Import(workspace *models.Workspace, content WorkspaceContent) error
 	FindByID(id uint) (*models.Workspace, error)
+	FindDeletedByID(id uint) (*models.Workspace, error)
 	FindByName(name string) (*models.Workspace, error)
+	// FindByPublicSlug returns nil if no workspace has the slug.
+	FindByPublicSlug(slug string) (*models.Workspace, error)
 	Update(workspace *models.Workspace) error
-	Delete(id uint) error
+	// Delete returns ErrStaleVersion if the workspace is no longer at the
+	// given version.
+	Delete(id uint, version uint) error
+	Restore(id uint) error
+	Purge(before time.Time) error
 }
@@ -0,0 +1,16 @@
+package repository
+
+import (
+	"github.com/raeinsoltani/gorello/back/models"
+)
+
+type WorkspaceTemplate interface {
+	Create(template *models.WorkspaceTemplate) error
+	FindByID(id uint) (*models.WorkspaceTemplate, error)
+	// FindAvailable returns the built-in templates and the user's own.
+	FindAvailable(user_id uint) ([]*models.WorkspaceTemplate, error)
+	Delete(id uint) error
+	// SaveBuiltIn creates or updates the built-in templates, matching them
+	// by name.
+	SaveBuiltIn(templates []*models.WorkspaceTemplate) error
+}
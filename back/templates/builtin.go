@@ -0,0 +1,122 @@
+// Package templates holds the workspace templates that ship with the
+// server.
+package templates
+
+import (
+	"github.com/raeinsoltani/gorello/back/models"
+)
+
+// BuiltIn returns the built-in templates. They are saved on startup, so
+// changes here reach existing deployments.
+func BuiltIn() []*models.WorkspaceTemplate {
+	return []*models.WorkspaceTemplate{
+		{
+			Name:        "Kanban",
+			Description: "A simple board that moves work from to do, through in progress, to done.",
+			Content: models.TemplateContent{
+				Labels: []models.TemplateLabel{
+					{Name: "Feature", Color: "#61bd4f"},
+					{Name: "Bug", Color: "#eb5a46"},
+					{Name: "Improvement", Color: "#0079bf"},
+					{Name: "Blocked", Color: "#000000"},
+				},
+				Tasks: []models.TemplateTask{
+					{
+						Title:       "Welcome to your board",
+						Description: "Move tasks along as work progresses.",
+						Status:      models.TaskStatusTodo,
+						SubTasks:    []string{"Invite your team", "Add your first tasks", "Set up labels"},
+					},
+					{
+						Title:       "Example task in progress",
+						Description: "Keep work in progress small.",
+						Status:      models.TaskStatusInProgress,
+						Labels:      []string{"Feature"},
+					},
+					{
+						Title:  "Example finished task",
+						Status: models.TaskStatusDone,
+						Labels: []string{"Improvement"},
+					},
+				},
+			},
+		},
+		{
+			Name:        "Scrum",
+			Description: "A product backlog with story points, ready for sprint planning.",
+			Content: models.TemplateContent{
+				Labels: []models.TemplateLabel{
+					{Name: "Story", Color: "#61bd4f"},
+					{Name: "Bug", Color: "#eb5a46"},
+					{Name: "Spike", Color: "#c377e0"},
+					{Name: "Tech debt", Color: "#ff9f1a"},
+				},
+				Tasks: []models.TemplateTask{
+					{
+						Title:        "As a user, I can sign up",
+						Description:  "Example user story.",
+						Labels:       []string{"Story"},
+						Story_points: 3,
+						SubTasks:     []string{"Write acceptance criteria", "Design the form", "Implement", "Test"},
+					},
+					{
+						Title:        "Investigate hosting options",
+						Description:  "Time-boxed research.",
+						Labels:       []string{"Spike"},
+						Story_points: 2,
+					},
+					{
+						Title:       "Plan the first sprint",
+						Description: "Pick stories that fit the team's capacity.",
+						Priority:    1,
+						SubTasks:    []string{"Estimate the backlog", "Agree on the sprint goal", "Create the sprint"},
+					},
+				},
+			},
+		},
+		{
+			Name:        "Bug triage",
+			Description: "Collect incoming bugs and sort them by severity.",
+			Content: models.TemplateContent{
+				Labels: []models.TemplateLabel{
+					{Name: "Critical", Color: "#eb5a46"},
+					{Name: "Major", Color: "#ff9f1a"},
+					{Name: "Minor", Color: "#f2d600"},
+					{Name: "Trivial", Color: "#b3bac5"},
+					{Name: "Needs info", Color: "#0079bf"},
+					{Name: "Cannot reproduce", Color: "#838c91"},
+				},
+				Tasks: []models.TemplateTask{
+					{
+						Title:       "How to triage a bug",
+						Description: "Follow these steps for every new bug.",
+						SubTasks: []string{
+							"Reproduce the bug",
+							"Label its severity",
+							"Ask the reporter for missing information",
+							"Assign an owner",
+						},
+					},
+					{
+						Title:       "Example: app crashes on login",
+						Description: "Steps to reproduce, expected and actual.",
+						Priority:    2,
+						Labels:      []string{"Critical"},
+					},
+				},
+				Statuses: []models.TemplateStatus{
+					{Value: 0, Name: "New", Category: models.StatusCategoryTodo},
+					{Value: 1, Name: "Confirmed", Category: models.StatusCategoryInProgress, Allowed_from: []uint{0}},
+					{Value: 2, Name: "Fixed", Category: models.StatusCategoryDone, Allowed_from: []uint{1}},
+					{Value: 3, Name: "Won't fix", Category: models.StatusCategoryDone},
+				},
+				Priorities: []models.TemplatePriority{
+					{Value: 0, Name: "Low"},
+					{Value: 1, Name: "Medium"},
+					{Value: 2, Name: "High"},
+					{Value: 3, Name: "Urgent"},
+				},
+			},
+		},
+	}
+}
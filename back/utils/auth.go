@@ -1,7 +1,11 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt"
@@ -10,6 +14,13 @@ import (
 
 var jwtSecretKey = []byte("your_secret_key")
 
+// AccessTokenPrefix starts every personal access token, which tells them
+// apart from JWTs in the Authorization header.
+const AccessTokenPrefix = "glo_"
+
+// InvitationTokenPrefix starts every invitation token.
+const InvitationTokenPrefix = "gli_"
+
 func HashPassword(password string) string {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
 	if err != nil {
@@ -23,12 +34,18 @@ func CheckPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
-func GenerateJWT(username string) (string, error) {
+// JWTLifetime is how long a login JWT, and the session behind it, lasts.
+const JWTLifetime = time.Hour * 24
+
+// GenerateJWT issues the login token for a session; the token is only
+// accepted while its session hasn't been revoked.
+func GenerateJWT(username string, sessionId uint) (string, error) {
 	token := jwt.New(jwt.SigningMethodHS256)
 	claims := token.Claims.(jwt.MapClaims)
 
 	claims["username"] = username
-	claims["exp"] = time.Now().Add(time.Hour * 24).Unix()
+	claims["sid"] = sessionId
+	claims["exp"] = time.Now().Add(JWTLifetime).Unix()
 
 	tokenString, err := token.SignedString(jwtSecretKey)
 	if err != nil {
@@ -38,7 +55,7 @@ func GenerateJWT(username string) (string, error) {
 	return tokenString, nil
 }
 
-func ParseJWT(tokenStr string) (string, error) {
+func ParseJWT(tokenStr string) (string, uint, error) {
 	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
@@ -47,9 +64,130 @@ func ParseJWT(tokenStr string) (string, error) {
 	})
 
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		username := claims["username"].(string)
-		return username, nil
+		// Tokens issued for other purposes, such as the OIDC login state,
+		// carry no username and are not logins.
+		username, ok := claims["username"].(string)
+		sessionId, hasSession := claims["sid"].(float64)
+		if !ok || !hasSession || claims["purpose"] != nil {
+			return "", 0, fmt.Errorf("not a login token")
+		}
+		return username, uint(sessionId), nil
 	} else {
+		return "", 0, err
+	}
+}
+
+// OIDCState is what an OIDC login keeps in a cookie until the callback.
+type OIDCState struct {
+	State    string
+	Nonce    string
+	Verifier string
+	// Link_user_id is set when a signed-in user links the identity to
+	// their account instead of logging in.
+	Link_user_id uint
+}
+
+// GenerateOIDCStateJWT signs the state of an OIDC login so that it can be
+// kept in a cookie until the callback.
+func GenerateOIDCStateJWT(state OIDCState) (string, error) {
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+
+	claims["purpose"] = "oidc"
+	claims["state"] = state.State
+	claims["nonce"] = state.Nonce
+	claims["verifier"] = state.Verifier
+	claims["link_user_id"] = state.Link_user_id
+	claims["exp"] = time.Now().Add(10 * time.Minute).Unix()
+
+	return token.SignedString(jwtSecretKey)
+}
+
+func ParseOIDCStateJWT(tokenStr string) (*OIDCState, error) {
+	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return jwtSecretKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["purpose"] != "oidc" {
+		return nil, fmt.Errorf("not an OIDC state token")
+	}
+	state := &OIDCState{}
+	state.State, _ = claims["state"].(string)
+	state.Nonce, _ = claims["nonce"].(string)
+	state.Verifier, _ = claims["verifier"].(string)
+	linkUserId, _ := claims["link_user_id"].(float64)
+	state.Link_user_id = uint(linkUserId)
+	return state, nil
+}
+
+// GenerateAccessToken returns a new personal access token and the hash
+// under which it is stored.
+func GenerateAccessToken() (string, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	token := AccessTokenPrefix + hex.EncodeToString(secret)
+	return token, HashAccessToken(token), nil
+}
+
+// HashAccessToken hashes a personal access token for storage and lookup.
+// Tokens are long and random, so a plain SHA-256 is enough.
+func HashAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func IsAccessToken(token string) bool {
+	return strings.HasPrefix(token, AccessTokenPrefix)
+}
+
+// GenerateInvitationToken returns a new invitation token and the hash to
+// store, made the same way as access tokens.
+func GenerateInvitationToken() (string, string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	token := InvitationTokenPrefix + hex.EncodeToString(secret)
+	return token, HashAccessToken(token), nil
+}
+
+// GenerateChallengeJWT issues the short-lived token that stands between
+// the password and the second factor of a two-step login.
+func GenerateChallengeJWT(username string) (string, error) {
+	token := jwt.New(jwt.SigningMethodHS256)
+	claims := token.Claims.(jwt.MapClaims)
+
+	claims["purpose"] = "2fa"
+	claims["challenge_username"] = username
+	claims["exp"] = time.Now().Add(5 * time.Minute).Unix()
+
+	return token.SignedString(jwtSecretKey)
+}
+
+func ParseChallengeJWT(tokenStr string) (string, error) {
+	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return jwtSecretKey, nil
+	})
+	if err != nil {
 		return "", err
 	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	username, _ := claims["challenge_username"].(string)
+	if !ok || claims["purpose"] != "2fa" || username == "" {
+		return "", fmt.Errorf("not a 2FA challenge token")
+	}
+	return username, nil
 }
@@ -0,0 +1,131 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+)
+
+const (
+	maxAttempts  = 8
+	baseBackoff  = 30 * time.Second
+	maxBackoff   = 6 * time.Hour
+	claimLease   = 5 * time.Minute
+	claimBatch   = 20
+	pollInterval = 5 * time.Second
+)
+
+// Sign returns the value of the X-Gorello-Signature header for a payload:
+// the hex HMAC-SHA256 of the body keyed with the webhook secret.
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff is the delay before retrying after the given number of failed
+// attempts: 30s, 1m, 2m, ... capped at 6 hours.
+func Backoff(attempts uint) time.Duration {
+	delay := baseBackoff
+	for i := uint(1); i < attempts; i++ {
+		delay *= 2
+		if delay >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return delay
+}
+
+// Sender delivers queued webhook deliveries in the background.
+type Sender struct {
+	WebhookRepo repository.Webhook
+	Client      *http.Client
+}
+
+func NewSender(webhookRepo repository.Webhook) *Sender {
+	return &Sender{
+		WebhookRepo: webhookRepo,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Run polls for due deliveries forever.
+func (s *Sender) Run() {
+	for {
+		deliveries, err := s.WebhookRepo.ClaimDueDeliveries(claimBatch, claimLease)
+		if err != nil {
+			log.Printf("error claiming webhook deliveries: %s", err.Error())
+		}
+		for _, delivery := range deliveries {
+			s.Deliver(delivery)
+		}
+		if len(deliveries) < claimBatch {
+			time.Sleep(pollInterval)
+		}
+	}
+}
+
+// Deliver makes one attempt at a delivery and records the outcome, either
+// finishing it or scheduling the next attempt.
+func (s *Sender) Deliver(delivery *models.WebhookDelivery) {
+	delivery.Attempts++
+
+	webhook, err := s.WebhookRepo.FindByID(delivery.Webhook_id)
+	if err == nil {
+		err = s.post(webhook, delivery)
+	}
+
+	now := time.Now()
+	switch {
+	case err == nil:
+		delivery.Status = models.WebhookDeliverySucceeded
+		delivery.Last_error = ""
+		delivery.Delivered_at = &now
+	case delivery.Attempts >= maxAttempts || !webhook.Active:
+		delivery.Status = models.WebhookDeliveryFailed
+		delivery.Last_error = err.Error()
+	default:
+		delivery.Last_error = err.Error()
+		delivery.Next_attempt_at = now.Add(Backoff(delivery.Attempts))
+	}
+
+	if err := s.WebhookRepo.UpdateDelivery(delivery); err != nil {
+		log.Printf("error updating webhook delivery %d: %s", delivery.ID, err.Error())
+	}
+}
+
+func (s *Sender) post(webhook *models.Webhook, delivery *models.WebhookDelivery) error {
+	payload := []byte(delivery.Payload)
+	request, err := http.NewRequest(http.MethodPost, webhook.Url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "Gorello-Hookshot")
+	request.Header.Set("X-Gorello-Event", delivery.Event)
+	request.Header.Set("X-Gorello-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	request.Header.Set("X-Gorello-Signature", Sign(webhook.Secret, payload))
+
+	response, err := s.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+
+	delivery.Response_code = response.StatusCode
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("receiver responded with %s", response.Status)
+	}
+	return nil
+}
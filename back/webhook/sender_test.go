@@ -0,0 +1,154 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/raeinsoltani/gorello/back/models"
+	"github.com/raeinsoltani/gorello/back/repository"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+// memoryWebhooks keeps one webhook and the deliveries the sender updates.
+type memoryWebhooks struct {
+	repository.Webhook
+	webhook *models.Webhook
+	updated []models.WebhookDelivery
+}
+
+func (r *memoryWebhooks) FindByID(id uint) (*models.Webhook, error) {
+	return r.webhook, nil
+}
+
+func (r *memoryWebhooks) UpdateDelivery(delivery *models.WebhookDelivery) error {
+	r.updated = append(r.updated, *delivery)
+	return nil
+}
+
+// receiver is a local HTTP receiver that records the last request and
+// answers with status.
+type receiver struct {
+	status  int
+	request *http.Request
+	body    []byte
+}
+
+func newReceiver(t *testing.T, status int) (*receiver, *httptest.Server) {
+	t.Helper()
+	r := &receiver{status: status}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		body, err := io.ReadAll(request.Body)
+		if err != nil {
+			t.Errorf("reading delivery: %s", err)
+		}
+		r.request, r.body = request, body
+		w.WriteHeader(r.status)
+	}))
+	t.Cleanup(server.Close)
+	return r, server
+}
+
+func newSender(url string) (*Sender, *memoryWebhooks) {
+	hook := &models.Webhook{Url: url, Secret: testSecret, Events: "*", Active: true}
+	hook.ID = 1
+	repo := &memoryWebhooks{webhook: hook}
+	return NewSender(repo), repo
+}
+
+func newDelivery() *models.WebhookDelivery {
+	delivery := &models.WebhookDelivery{
+		Webhook_id: 1,
+		Event:      repository.EventTaskCreated,
+		Payload:    `{"event":"task.created","workspace_id":3}`,
+		Status:     models.WebhookDeliveryPending,
+	}
+	delivery.ID = 42
+	return delivery
+}
+
+func TestDeliverSignsPayload(t *testing.T) {
+	r, server := newReceiver(t, http.StatusNoContent)
+	sender, repo := newSender(server.URL)
+	delivery := newDelivery()
+
+	sender.Deliver(delivery)
+
+	if r.request == nil {
+		t.Fatal("receiver got no request")
+	}
+	if string(r.body) != delivery.Payload {
+		t.Errorf("body = %s, want %s", r.body, delivery.Payload)
+	}
+	mac := hmac.New(sha256.New, []byte(testSecret))
+	mac.Write([]byte(delivery.Payload))
+	if got, want := r.request.Header.Get("X-Gorello-Signature"), "sha256="+hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("signature = %q, want %q", got, want)
+	}
+	if got := r.request.Header.Get("X-Gorello-Event"); got != repository.EventTaskCreated {
+		t.Errorf("event header = %q", got)
+	}
+	if got := r.request.Header.Get("X-Gorello-Delivery"); got != "42" {
+		t.Errorf("delivery header = %q", got)
+	}
+
+	if len(repo.updated) != 1 {
+		t.Fatalf("delivery updated %d times, want 1", len(repo.updated))
+	}
+	recorded := repo.updated[0]
+	if recorded.Status != models.WebhookDeliverySucceeded || recorded.Response_code != http.StatusNoContent ||
+		recorded.Delivered_at == nil || recorded.Attempts != 1 {
+		t.Errorf("delivery = %+v, want one successful attempt", recorded)
+	}
+}
+
+func TestDeliverSchedulesRetryOnFailure(t *testing.T) {
+	_, server := newReceiver(t, http.StatusInternalServerError)
+	sender, repo := newSender(server.URL)
+
+	before := time.Now()
+	sender.Deliver(newDelivery())
+
+	recorded := repo.updated[0]
+	if recorded.Status != models.WebhookDeliveryPending || recorded.Response_code != http.StatusInternalServerError {
+		t.Errorf("delivery = %+v, want a pending retry", recorded)
+	}
+	if recorded.Last_error == "" {
+		t.Error("last error not recorded")
+	}
+	if retry := recorded.Next_attempt_at.Sub(before); retry < baseBackoff || retry > baseBackoff+time.Minute {
+		t.Errorf("next attempt in %s, want about %s", retry, baseBackoff)
+	}
+}
+
+func TestDeliverGivesUpAfterLastAttempt(t *testing.T) {
+	_, server := newReceiver(t, http.StatusBadGateway)
+	sender, repo := newSender(server.URL)
+	delivery := newDelivery()
+	delivery.Attempts = maxAttempts - 1
+
+	sender.Deliver(delivery)
+
+	if recorded := repo.updated[0]; recorded.Status != models.WebhookDeliveryFailed || recorded.Attempts != maxAttempts {
+		t.Errorf("delivery = %+v, want failed after %d attempts", recorded, maxAttempts)
+	}
+}
+
+func TestBackoffDoublesUpToCap(t *testing.T) {
+	for attempts, want := range map[uint]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		4:  4 * time.Minute,
+		20: maxBackoff,
+	} {
+		if got := Backoff(attempts); got != want {
+			t.Errorf("Backoff(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}